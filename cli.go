@@ -0,0 +1,993 @@
+package main
+
+import (
+	"context"
+	"dnsrecon/config"
+	"dnsrecon/dnsrecon"
+	"dnsrecon/handlers"
+	"dnsrecon/resolvers"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// importProbeWorkers is the number of imported resolvers probed at the same time
+const importProbeWorkers = 50
+
+// Exit codes of the lookup, scan and resolvers commands
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitNXDomain = 3
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: dnsrecon <command> [flags]
+
+commands:
+  serve [flags]               start the HTTP server, the default command
+  lookup [flags] <domain>     look up a domain
+  scan [flags] -f <file>      look up every domain in a file, one per line or a JSON array, - reads stdin
+  trace [flags] <domain>      resolve a domain from the root servers and show every referral
+  ip [flags] <address|cidr>   look up the PTR records of an address or every address of a range
+  resolvers validate [flags]  check resolvers.yaml and query a name through every resolver
+  resolvers import [flags] [csv file or url]
+                              add resolvers from the public-dns.info nameservers CSV to resolvers.yaml
+
+serve, lookup, scan, trace, ip and resolvers validate have a flag for every config.yaml setting, e.g. -dns-timeout 5,
+and -config and -resolvers to read other files. Only serve creates the files when they don't exist.
+
+exit codes:
+  0  every domain returned NOERROR
+  1  a lookup failed
+  2  invalid arguments
+  3  a domain returned NXDOMAIN
+
+Run dnsrecon <command> -h for the flags of each command.
+`)
+}
+
+// lookupFlags are the flags shared by lookup and scan
+type lookupFlags struct {
+	output     string
+	v2         bool
+	types      string
+	dnssec     bool
+	axfr       bool
+	ixfrSerial uint
+	spf        bool
+	email      bool
+	delegation bool
+	reverse    bool
+	provenance bool
+}
+
+func (f *lookupFlags) register(flags *flag.FlagSet, output string) {
+	flags.StringVar(&f.output, "o", output, "output format: json, ndjson or table")
+	flags.BoolVar(&f.v2, "v2", false, "use the v2 schema for json and ndjson output")
+	flags.StringVar(&f.types, "types", "", "comma separated record types to resolve, e.g. mx,txt")
+	flags.BoolVar(&f.dnssec, "dnssec", false, "validate the DNSSEC chain of trust")
+	flags.BoolVar(&f.axfr, "axfr", false, "try zone transfers against the nameservers")
+	flags.UintVar(&f.ixfrSerial, "ixfr-serial", 0, "also try an IXFR from this serial")
+	flags.BoolVar(&f.spf, "spf", false, "parse the SPF record and expand its includes")
+	flags.BoolVar(&f.email, "email", false, "check DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records")
+	flags.BoolVar(&f.delegation, "delegation", false, "compare the parent delegation with the zone's nameservers")
+	flags.BoolVar(&f.reverse, "reverse", false, "look up the PTR records of every address found and confirm they resolve back")
+	flags.BoolVar(&f.provenance, "provenance", false, "include the resolver, attempts, timing and flags of every query in json output")
+}
+
+func (f *lookupFlags) options() (dnsrecon.LookupOptions, error) {
+
+	var opts dnsrecon.LookupOptions
+	var err error
+
+	switch f.output {
+	case "json", "ndjson", "table":
+	default:
+		return opts, fmt.Errorf("unknown output format %q", f.output)
+	}
+
+	opts.Types, err = dnsrecon.ParseRecordTypes(f.types)
+	if err != nil {
+		return opts, err
+	}
+
+	if f.ixfrSerial > 1<<32-1 {
+		return opts, fmt.Errorf("invalid ixfr-serial value %d", f.ixfrSerial)
+	}
+
+	opts.DNSSEC = f.dnssec
+	opts.ZoneTransfer = f.axfr || f.ixfrSerial != 0
+	opts.IXFRSerial = uint32(f.ixfrSerial)
+	opts.SPF = f.spf
+	opts.Email = f.email
+	opts.Delegation = f.delegation
+	opts.ReverseDNS = f.reverse
+	opts.Provenance = f.provenance
+
+	return opts, nil
+}
+
+// parseFlags allows flags both before and after the positional arguments
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// registerConfigFlags adds a flag per config setting, -config and -resolvers like serve has
+func registerConfigFlags(flags *flag.FlagSet) {
+	config.RegisterFlags(flags)
+	flags.StringVar(&resolvers.ResolversFile, "resolvers", resolvers.ResolversFile, "resolvers file")
+}
+
+// newCLIServer loads the dns client pool, the defaults are used for missing config and resolvers files
+// Unlike serve it doesn't create the files so lookups leave the working directory alone
+func newCLIServer() *handlers.Server {
+
+	s, _, err := newServer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
+	if s.Pool.Size() == 0 {
+		fmt.Fprintln(os.Stderr, "no resolvers in resolvers.yaml")
+		os.Exit(exitFailure)
+	}
+
+	return s
+}
+
+func lookupCommand(args []string) int {
+
+	var lf lookupFlags
+
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	registerConfigFlags(flags)
+	lf.register(flags, "json")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dnsrecon lookup [flags] <domain>")
+		return exitUsage
+	}
+
+	opts, err := lf.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	domain := positional[0]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		fmt.Fprintf(os.Stderr, "invalid domain name %q\n", domain)
+		return exitUsage
+	}
+
+	s := newCLIServer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	domainData := lookupDomain(ctx, s, domain, opts)
+	if domainData == nil {
+		return exitFailure
+	}
+
+	out := &output{w: os.Stdout, format: lf.output, v2: lf.v2}
+	out.write(domainData)
+	out.flush(false)
+
+	return exitCode(domainData.Status)
+}
+
+func scanCommand(args []string) int {
+
+	var lf lookupFlags
+
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	registerConfigFlags(flags)
+	lf.register(flags, "ndjson")
+	filename := flags.String("f", "", "file with the domains to scan, - reads stdin")
+	workers := flags.Int("workers", 0, "number of domains looked up at the same time, defaults to the number of resolvers")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if *filename == "" || len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: dnsrecon scan [flags] -f <file>")
+		return exitUsage
+	}
+
+	opts, err := lf.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		defer f.Close()
+		r = f
+	}
+
+	domains, err := dnsrecon.ParseDomainList(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	s := newCLIServer()
+
+	if *workers <= 0 {
+		*workers = s.Pool.Size()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	candidates := make(chan string)
+	results := make(chan interface{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < *workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for domain := range candidates {
+				if _, ok := dns.IsDomainName(domain); !ok {
+					results <- handlers.BulkError{Name: domain, Status: "ERROR", Error: "invalid domain name"}
+					continue
+				}
+				if domainData := lookupDomain(ctx, s, domain, opts); domainData != nil {
+					results <- domainData
+				} else {
+					results <- handlers.BulkError{Name: domain, Status: "ERROR", Error: dnsrecon.ErrCancelled}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(results)
+
+	feed:
+		for _, domain := range domains {
+			select {
+			case candidates <- domain:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+
+		close(candidates)
+		wg.Wait()
+	}()
+
+	out := &output{w: os.Stdout, format: lf.output, v2: lf.v2}
+	code := exitOK
+
+	for result := range results {
+		out.write(result)
+
+		switch v := result.(type) {
+		case *dnsrecon.DomainData:
+			code = worstExitCode(code, exitCode(v.Status))
+		case handlers.BulkError:
+			code = worstExitCode(code, exitFailure)
+		}
+	}
+
+	out.flush(true)
+
+	if ctx.Err() != nil {
+		return exitFailure
+	}
+
+	return code
+}
+
+func traceCommand(args []string) int {
+
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	registerConfigFlags(flags)
+	format := flags.String("o", "table", "output format: json or table")
+	typeName := flags.String("type", "a", "record type to resolve")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dnsrecon trace [flags] <domain>")
+		return exitUsage
+	}
+
+	if *format != "json" && *format != "table" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *format)
+		return exitUsage
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(*typeName)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown record type %q\n", *typeName)
+		return exitUsage
+	}
+
+	domain := positional[0]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		fmt.Fprintf(os.Stderr, "invalid domain name %q\n", domain)
+		return exitUsage
+	}
+
+	s := newCLIServer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dnsClient, err := s.Pool.Get(ctx)
+	if err != nil {
+		return exitFailure
+	}
+	trace := dnsClient.Trace(ctx, domain, qtype)
+	s.Pool.Put(dnsClient)
+
+	out := &output{w: os.Stdout, format: *format}
+	out.write(trace)
+	out.flush(false)
+
+	if trace.Error != "" {
+		fmt.Fprintln(os.Stderr, trace.Error)
+	}
+
+	return exitCode(trace.Status)
+}
+
+func ipCommand(args []string) int {
+
+	flags := flag.NewFlagSet("ip", flag.ContinueOnError)
+	registerConfigFlags(flags)
+	format := flags.String("o", "ndjson", "output format: json, ndjson or table")
+	confirm := flags.Bool("confirm", false, "check the PTR names resolve back to the address")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dnsrecon ip [flags] <address|cidr>")
+		return exitUsage
+	}
+
+	switch *format {
+	case "json", "ndjson", "table":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *format)
+		return exitUsage
+	}
+
+	s := newCLIServer()
+
+	sweep, err := dnsrecon.ParseSweepRange(positional[0], s.Config.SweepMaxAddresses)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out := &output{w: os.Stdout, format: *format}
+	out.write(sweep)
+
+	results := make(chan dnsrecon.SweepHost)
+	go s.Pool.SweepPTR(ctx, sweep, *confirm, results)
+
+	code := exitOK
+	for host := range results {
+		out.write(host)
+		if host.Status == dnsrecon.ReverseError {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", host.Address, host.Error)
+			code = exitFailure
+		}
+	}
+	out.flush(true)
+
+	if ctx.Err() != nil {
+		return exitFailure
+	}
+
+	return code
+}
+
+// lookupDomain gets the dns data with a client from the pool and retries once with another client on errors
+// It returns nil when the context is done before a client is available
+func lookupDomain(ctx context.Context, s *handlers.Server, domain string, opts dnsrecon.LookupOptions) *dnsrecon.DomainData {
+
+	var domainData *dnsrecon.DomainData
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		dnsClient, err := s.Pool.Get(ctx)
+		if err != nil {
+			break
+		}
+		domainData = dnsClient.GetDnsDataWithOptions(ctx, domain, opts)
+		s.Pool.Put(dnsClient)
+
+		if domainData.Status != "ERROR" {
+			break
+		}
+	}
+
+	return domainData
+}
+
+func exitCode(status string) int {
+
+	switch status {
+	case "NOERROR":
+		return exitOK
+	case "NXDOMAIN":
+		return exitNXDomain
+	default:
+		return exitFailure
+	}
+}
+
+// worstExitCode prefers failures over NXDOMAIN over success
+func worstExitCode(a, b int) int {
+
+	if a == exitFailure || b == exitFailure {
+		return exitFailure
+	}
+	if a == exitNXDomain || b == exitNXDomain {
+		return exitNXDomain
+	}
+	return exitOK
+}
+
+// output writes lookup results as json, ndjson or a table
+// json results are buffered so several of them can be written as one array
+type output struct {
+	w       io.Writer
+	format  string
+	v2      bool
+	results []interface{}
+}
+
+func (o *output) write(result interface{}) {
+
+	if d, ok := result.(*dnsrecon.DomainData); ok && o.v2 {
+		result = d.V2()
+	}
+
+	switch o.format {
+	case "json":
+		o.results = append(o.results, result)
+
+	case "ndjson":
+		json.NewEncoder(o.w).Encode(result)
+
+	case "table":
+		writeTable(o.w, result)
+	}
+}
+
+func (o *output) flush(array bool) {
+
+	if o.format != "json" {
+		return
+	}
+
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+
+	if array {
+		if o.results == nil {
+			o.results = make([]interface{}, 0)
+		}
+		encoder.Encode(o.results)
+		return
+	}
+
+	for _, result := range o.results {
+		encoder.Encode(result)
+	}
+}
+
+func writeTable(w io.Writer, result interface{}) {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	switch v := result.(type) {
+	case handlers.BulkError:
+		fmt.Fprintf(tw, "%s\t%s\t%s\n\n", v.Name, v.Status, v.Error)
+
+	case *dnsrecon.DomainData:
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Name, v.Status, v.Timestamp.Format(time.RFC3339))
+
+		fmt.Fprintln(tw, "NAME\tTTL\tTYPE\tDATA")
+		for _, rr := range v.Records() {
+			h := rr.Header()
+			data := strings.TrimPrefix(rr.String(), h.String())
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", h.Name, h.Ttl, dns.TypeToString[h.Rrtype], data)
+		}
+
+		if v.DNSSEC != nil {
+			fmt.Fprintf(tw, "DNSSEC\t%s\t%s\n", v.DNSSEC.Status, v.DNSSEC.Reason)
+		}
+
+		if v.SPF != nil {
+			fmt.Fprintf(tw, "SPF\t%s\t%d lookups\t%s\n", v.SPF.Status, v.SPF.Lookups, strings.Join(append(append([]string{}, v.SPF.IP4...), v.SPF.IP6...), " "))
+			for _, e := range v.SPF.Errors {
+				fmt.Fprintf(tw, "SPF\t%s\n", e)
+			}
+		}
+
+		if v.Email != nil {
+			summary := v.Email.Summary
+			fmt.Fprintf(tw, "EMAIL\tdmarc %s %s\tdkim %d\tmta-sts %t tls-rpt %t bimi %t\n", summary.DMARC, summary.DMARCPolicy, summary.DKIM, summary.MTASTS, summary.TLSRPT, summary.BIMI)
+			for _, issue := range summary.Issues {
+				fmt.Fprintf(tw, "EMAIL\t%s\n", issue)
+			}
+		}
+
+		if v.Delegation != nil {
+			fmt.Fprintf(tw, "DELEGATION\t%s\t%s\n", v.Delegation.Status, v.Delegation.Error)
+			for _, issue := range v.Delegation.Issues {
+				fmt.Fprintf(tw, "DELEGATION\t%s\n", issue)
+			}
+		}
+
+		addresses := make([]string, 0, len(v.ReverseDNS))
+		for address := range v.ReverseDNS {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		for _, address := range addresses {
+			reverse := v.ReverseDNS[address]
+			fmt.Fprintf(tw, "REVERSE\t%s %s\t%s\t%s\n", address, strings.Join(reverse.Hosts, " "), reverse.Status, strings.Join(reverse.PTR, " "))
+		}
+
+		for _, transfer := range v.ZoneTransfers {
+			fmt.Fprintf(tw, "%s\t%s %s\t%s\t%d records\n", transfer.Type, transfer.Nameserver, transfer.Address, transfer.Status, transfer.Count)
+		}
+
+		sets := make([]string, 0, len(v.Errors))
+		for set := range v.Errors {
+			sets = append(sets, set)
+		}
+		sort.Strings(sets)
+		for _, set := range sets {
+			fmt.Fprintf(tw, "ERROR\t%s\t%s\n", set, v.Errors[set])
+		}
+
+		fmt.Fprintln(tw)
+
+	case *dnsrecon.Trace:
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.0fms\n", v.Name, v.Type, v.Status, v.Duration)
+
+		fmt.Fprintln(tw, "ZONE\tSERVER\tADDRESS\tRCODE\tRTT\tRESULT")
+		for _, step := range v.Steps {
+
+			result := step.Error
+			switch {
+			case step.Referral != nil:
+				result = fmt.Sprintf("referral to %s: %s, %d glue", step.Referral.Zone, strings.Join(step.Referral.Nameservers, " "), len(step.Referral.Glue))
+			case len(step.Answer) > 0:
+				result = fmt.Sprintf("%d answers", len(step.Answer))
+			case result == "" && step.Authoritative:
+				result = "authoritative"
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.0fms\t%s\n", step.Zone, step.Server, step.Address, step.Rcode, step.RTT, result)
+		}
+
+		fmt.Fprintln(tw, "NAME\tTTL\tTYPE\tDATA")
+		for _, record := range v.Answer {
+			data, _ := json.Marshal(record.Data)
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", record.Name, record.TTL, record.Type, data)
+		}
+
+		fmt.Fprintln(tw)
+
+	// Sweep results are written as they arrive so the rows aren't aligned with each other
+	case dnsrecon.SweepRange:
+		fmt.Fprintf(tw, "%s\t%d addresses\n", v.Network, v.Addresses)
+
+	case dnsrecon.SweepHost:
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Address, strings.Join(v.PTR, " "), v.Status, v.Error)
+	}
+}
+
+// resolverCheck is the result of querying a name through one resolver address
+type resolverCheck struct {
+	Nameserver string  `json:"nameserver"`
+	Address    string  `json:"address"`
+	Status     string  `json:"status"`
+	RTT        float64 `json:"rtt_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func resolversCommand(args []string) int {
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "validate":
+			return resolversValidateCommand(args[1:])
+		case "import":
+			return resolversImportCommand(args[1:])
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "usage: dnsrecon resolvers validate|import [flags]")
+	return exitUsage
+}
+
+func resolversValidateCommand(args []string) int {
+
+	flags := flag.NewFlagSet("resolvers validate", flag.ContinueOnError)
+	name := flags.String("name", "example.com", "name resolved through every resolver")
+	timeout := flags.Duration("timeout", 0, "query timeout (default dns_timeout from the config)")
+	format := flags.String("o", "table", "output format: json, ndjson or table")
+	registerConfigFlags(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *format != "json" && *format != "ndjson" && *format != "table" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *format)
+		return exitUsage
+	}
+
+	c, err := config.ReadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitFailure
+	}
+	if *timeout <= 0 {
+		*timeout = time.Duration(c.DnsTimeout) * time.Second
+	}
+
+	rs, err := resolvers.ReadResolvers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	code := exitOK
+
+	for _, err := range rs.Validate() {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		code = exitFailure
+	}
+	for _, duplicate := range rs.Duplicates() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", duplicate)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	checks := checkResolvers(ctx, rs, *name, *timeout)
+
+	for _, check := range checks {
+		if check.Status == "failed" {
+			code = exitFailure
+		}
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(checks)
+
+	case "ndjson":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, check := range checks {
+			encoder.Encode(check)
+		}
+
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAMESERVER\tADDRESS\tSTATUS\tRTT\tERROR")
+		for _, check := range checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.1fms\t%s\n", check.Nameserver, check.Address, check.Status, check.RTT, check.Error)
+		}
+		tw.Flush()
+	}
+
+	return code
+}
+
+func resolversImportCommand(args []string) int {
+
+	flags := flag.NewFlagSet("resolvers import", flag.ContinueOnError)
+	reliability := flags.Float64("min-reliability", 0.99, "minimum reliability between 0 and 1")
+	countries := flags.String("country", "", "comma separated country codes to import, e.g. gb,us")
+	ipv4 := flags.Bool("ipv4", false, "only import IPv4 resolvers")
+	ipv6 := flags.Bool("ipv6", false, "only import IPv6 resolvers")
+	ratelimit := flags.Int("ratelimit", resolvers.DefaultImportRatelimit, "queries per second of every imported resolver")
+	limit := flags.Int("limit", 0, "maximum number of resolvers to import, 0 imports all of them")
+	dryRun := flags.Bool("dry-run", false, "print the number of resolvers that would be imported without writing "+resolvers.ResolversFile)
+	timeout := flags.Duration("timeout", 5*time.Second, "query timeout of the probes every resolver must pass before it's imported")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: dnsrecon resolvers import [flags] [csv file or url]")
+		return exitUsage
+	}
+	if *reliability < 0 || *reliability > 1 || *ratelimit <= 0 || *limit < 0 {
+		fmt.Fprintln(os.Stderr, "min-reliability must be between 0 and 1, ratelimit greater than 0 and limit not negative")
+		return exitUsage
+	}
+
+	source := resolvers.PublicDnsURL
+	if len(positional) == 1 {
+		source = positional[0]
+	}
+
+	opts := resolvers.ImportOptions{
+		MinReliability: *reliability,
+		IPv4:           *ipv4,
+		IPv6:           *ipv6,
+		Ratelimit:      *ratelimit,
+	}
+	if *countries != "" {
+		opts.Countries = strings.Split(*countries, ",")
+	}
+
+	f, err := resolvers.OpenPublicDnsCSV(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer f.Close()
+
+	servers, err := resolvers.ReadPublicDnsCSV(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+		return exitFailure
+	}
+
+	// SaveResolvers writes the default resolvers along with the imported ones when the file doesn't exist
+	rs, err := resolvers.ReadResolvers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	c, err := config.ReadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	candidates := rs.Candidates(servers, opts)
+
+	healthy, err := probeCandidates(ctx, c, candidates, *limit, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	added := rs.Import(healthy, *limit)
+
+	if *dryRun {
+		fmt.Printf("%d of %d resolvers would be imported\n", added, len(servers))
+		return exitOK
+	}
+
+	if added == 0 {
+		fmt.Printf("No new resolvers in %d rows\n", len(servers))
+		return exitOK
+	}
+
+	if err := resolvers.SaveResolvers(rs, resolvers.ResolversFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	fmt.Printf("Imported %d of %d resolvers into %s\n", added, len(servers), resolvers.ResolversFile)
+
+	return exitOK
+}
+
+// probeCandidates runs the health check probes against the resolvers and returns those that pass in their original order
+// Resolvers that fail, hijack NXDOMAIN, return wrong known answers or are slower than health_max_latency are dropped
+// Probing stops once limit resolvers passed unless limit is zero
+func probeCandidates(ctx context.Context, c *config.Config, candidates []*resolvers.Resolver, limit int, timeout time.Duration) ([]*resolvers.Resolver, error) {
+
+	knownAnswers := c.HealthKnownAnswers
+	if len(knownAnswers) == 0 {
+		knownAnswers = dnsrecon.DefaultKnownAnswers
+	}
+	answers, err := dnsrecon.ParseKnownAnswers(knownAnswers)
+	if err != nil {
+		return nil, fmt.Errorf("config: health_known_answers: %v", err)
+	}
+
+	h := &dnsrecon.HealthMonitor{
+		KnownAnswers: answers,
+		MaxLatency:   time.Duration(c.HealthMaxLatency) * time.Millisecond,
+		Log:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	passed := make([]bool, len(candidates))
+	indexes := make(chan int)
+	dropped := make(map[string]int)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var healthy int
+
+	for i := 0; i < importProbeWorkers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+
+				health := h.ProbeResolver(ctx, candidates[i], timeout)
+
+				mu.Lock()
+				if ctx.Err() == nil {
+					if health.Status == dnsrecon.HealthHealthy {
+						passed[i] = true
+						healthy++
+						if limit > 0 && healthy >= limit {
+							cancel()
+						}
+					} else {
+						dropped[health.Status]++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i := range candidates {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	var statuses []string
+	for status, count := range dropped {
+		statuses = append(statuses, fmt.Sprintf("%d %s", count, status))
+	}
+	if len(statuses) > 0 {
+		sort.Strings(statuses)
+		fmt.Fprintf(os.Stderr, "Dropped resolvers that failed the probes: %s\n", strings.Join(statuses, ", "))
+	}
+
+	var results []*resolvers.Resolver
+	for i, ok := range passed {
+		if ok {
+			results = append(results, candidates[i])
+		}
+	}
+
+	return results, nil
+}
+
+// checkResolvers queries the name through every resolver and retry server address at the same time
+func checkResolvers(ctx context.Context, rs *resolvers.Resolvers, name string, timeout time.Duration) []resolverCheck {
+
+	var checks []resolverCheck
+
+	for _, resolver := range rs.DnsServers {
+		if resolver == nil {
+			continue
+		}
+		for _, address := range resolver.Ips {
+			check := resolverCheck{Nameserver: resolver.Nameserver, Address: address}
+			if !resolver.Enable {
+				check.Status = "disabled"
+			}
+			checks = append(checks, check)
+		}
+	}
+
+	if rs.RetryServers != nil {
+		for _, address := range rs.RetryServers.Ips {
+			checks = append(checks, resolverCheck{Nameserver: rs.RetryServers.Nameserver, Address: address})
+		}
+	}
+
+	client := &dns.Client{Timeout: timeout}
+
+	var wg sync.WaitGroup
+
+	for i := range checks {
+
+		if checks[i].Status != "" {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(check *resolverCheck) {
+			defer wg.Done()
+
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+			m.RecursionDesired = true
+
+			r, rtt, err := client.ExchangeContext(ctx, m, check.Address)
+			check.RTT = float64(rtt) / float64(time.Millisecond)
+
+			switch {
+			case err != nil:
+				check.Status = "failed"
+				check.Error = err.Error()
+			case r.Rcode != dns.RcodeSuccess:
+				check.Status = "failed"
+				check.Error = dns.RcodeToString[r.Rcode]
+			case len(r.Answer) == 0:
+				check.Status = "failed"
+				check.Error = "empty answer"
+			default:
+				check.Status = "ok"
+			}
+		}(&checks[i])
+	}
+
+	wg.Wait()
+
+	return checks
+}
@@ -1,65 +1,415 @@
 package config
 
 import (
+	"flag"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"log/slog"
+	"net"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// ConfigFile is read from the working directory unless DNSRECON_CONFIG or -config is set
+var ConfigFile = "config.yaml"
+
+// EnvPrefix is prepended to the upper case setting names, e.g. DNSRECON_LISTEN_ADDRESS
+const EnvPrefix = "DNSRECON_"
+
+func init() {
+	if filename := os.Getenv(EnvPrefix + "CONFIG"); filename != "" {
+		ConfigFile = filename
+	}
+}
+
+// flagOverrides are the settings given on the command line by their yaml names
+var flagOverrides = make(map[string]string)
+
 const (
-	configFile = "config.yaml"
+	defaultListenAddress = ":8080"
+	defaultReadTimeout   = 80
+	defaultWriteTimeout  = 80
+	defaultPoolTimeout   = 40
+	defaultLogLevel      = "info"
+
+	defaultDnsTimeout     = 10
+	defaultDnsAttempts    = 3
+	defaultDnsRetryDelay  = 500
+	defaultRatelimitBurst = 5
+
+	defaultCacheSize   = 10000
+	defaultCacheMaxTTL = 86400
+
+	defaultZoneTransferTimeout    = 30
+	defaultZoneTransferMaxRecords = 100000
+
+	defaultSweepMaxAddresses = 4096
+
+	defaultJobQueueSize = 100
+	defaultJobRetention = 3600
+
+	defaultHealthCheckInterval     = 60
+	defaultHealthMaxLatency        = 2000
+	defaultHealthFailureThreshold  = 3
+	defaultHealthRecoveryThreshold = 3
 )
 
 type Config struct {
 	MaximumDnsServers int `yaml:"maximum_dns_servers"`
+
+	// HTTP server, the timeouts are in seconds
+	ListenAddress string `yaml:"listen_address"`
+	ReadTimeout   int    `yaml:"read_timeout"`
+	WriteTimeout  int    `yaml:"write_timeout"`
+
+	// Seconds a request waits for a free dns client before returning 503
+	PoolTimeout int `yaml:"pool_timeout"`
+
+	// Logs are JSON lines on stderr, the level is debug, info, warn or error
+	// debug also logs every dns query with its server, type, rcode and latency
+	LogLevel string `yaml:"log_level"`
+
+	// DNS queries, the timeout is in seconds and the delay before retrying a lookup in milliseconds
+	// Attempts includes the first query, the last attempt is sent to the retry servers
+	DnsTimeout    int `yaml:"dns_timeout"`
+	DnsAttempts   int `yaml:"dns_attempts"`
+	DnsRetryDelay int `yaml:"dns_retry_delay"`
+
+	// Queries a resolver can send at once before its ratelimit applies
+	RatelimitBurst int `yaml:"ratelimit_burst"`
+
+	// Cache settings, TTLs are in seconds
+	CacheSize   int `yaml:"cache_size"`
+	CacheMinTTL int `yaml:"cache_min_ttl"`
+	CacheMaxTTL int `yaml:"cache_max_ttl"`
+
+	// DS records of the root zone used to validate DNSSEC, the root KSKs are used when empty
+	DnssecTrustAnchors []string `yaml:"dnssec_trust_anchors"`
+
+	// Root servers iterative lookups start from as "name address", the address can include a port
+	// The IPv4 addresses of the root servers are used when empty
+	RootHints []string `yaml:"root_hints"`
+
+	// Zone transfer limits, the timeout is in seconds
+	ZoneTransferTimeout    int `yaml:"zone_transfer_timeout"`
+	ZoneTransferMaxRecords int `yaml:"zone_transfer_max_records"`
+
+	// DKIM selectors probed by the email security check, common selectors are used when empty
+	DKIMSelectors []string `yaml:"dkim_selectors"`
+
+	// File with one subdomain label per line, the bundled wordlist is used when empty
+	SubdomainWordlist string `yaml:"subdomain_wordlist"`
+
+	// File with a sinkhole address or CIDR per line followed by an optional name, flagged by /consensus
+	SinkholeList string `yaml:"sinkhole_list"`
+
+	// Largest range /ip sweeps, 4096 is an IPv4 /20
+	SweepMaxAddresses int `yaml:"sweep_max_addresses"`
+
+	// Asynchronous jobs, workers defaults to half the dns servers and retention is in seconds
+	JobWorkers   int `yaml:"job_workers"`
+	JobQueueSize int `yaml:"job_queue_size"`
+	JobRetention int `yaml:"job_retention"`
+
+	// Resolver health probes, the interval is in seconds and the latency in milliseconds
+	HealthCheckInterval     int `yaml:"health_check_interval"`
+	HealthMaxLatency        int `yaml:"health_max_latency"`
+	HealthFailureThreshold  int `yaml:"health_failure_threshold"`
+	HealthRecoveryThreshold int `yaml:"health_recovery_threshold"`
+
+	// Records every resolver must return unchanged as "name type value", the root server addresses are used when empty
+	HealthKnownAnswers []string `yaml:"health_known_answers"`
 }
 
-func CreateConfig() bool {
+// CreateConfig writes the default config file if it doesn't exist and reports whether it was created
+func CreateConfig() (bool, error) {
 
 	c := Config{}
+	c.setDefaults(nil)
+
+	if _, err := os.Stat(ConfigFile); !os.IsNotExist(err) {
+		return false, nil
+	}
 
-	c.MaximumDnsServers = 0
+	y, err := yaml.Marshal(c)
+	if err != nil {
+		return false, err
+	}
 
-	// Create config file if it doesn't exist
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+	if err := ioutil.WriteFile(ConfigFile, y, 0644); err != nil {
+		return false, err
+	}
 
-		y, err := yaml.Marshal(c)
-		if err != nil {
-			panic(err)
+	fmt.Fprintf(os.Stderr, "\nCreated %s\n", ConfigFile)
+
+	return true, nil
+}
+
+// ReadConfig reads and validates the config file, missing settings get their defaults
+// Environment variables override the file and command line flags override both
+// The defaults are used when the file doesn't exist
+func ReadConfig() (*Config, error) {
+
+	var c Config
+
+	b, err := ioutil.ReadFile(ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", ConfigFile, err)
+	}
+
+	// Keys without a value, e.g. "cache_size:", count as missing
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", ConfigFile, err)
+	}
+
+	set := make(map[string]bool)
+	for key, value := range keys {
+		if value != nil {
+			set[key] = true
 		}
+	}
 
-		f, err := os.Create(configFile)
-		if err != nil {
-			panic(err)
+	if err := c.override(set); err != nil {
+		return nil, err
+	}
+
+	c.setDefaults(set)
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", ConfigFile, err)
+	}
+
+	return &c, nil
+}
+
+// defaults are used for the settings missing from the file, the environment and the flags
+// Settings that are set keep their value even when it's zero, e.g. cache_max_ttl: 0 removes the upper clamp
+var defaults = map[string]interface{}{
+	"listen_address": defaultListenAddress,
+	"read_timeout":   defaultReadTimeout,
+	"write_timeout":  defaultWriteTimeout,
+	"pool_timeout":   defaultPoolTimeout,
+	"log_level":      defaultLogLevel,
+
+	"dns_timeout":     defaultDnsTimeout,
+	"dns_attempts":    defaultDnsAttempts,
+	"dns_retry_delay": defaultDnsRetryDelay,
+	"ratelimit_burst": defaultRatelimitBurst,
+
+	"cache_size":    defaultCacheSize,
+	"cache_max_ttl": defaultCacheMaxTTL,
+
+	"zone_transfer_timeout":     defaultZoneTransferTimeout,
+	"zone_transfer_max_records": defaultZoneTransferMaxRecords,
+
+	"sweep_max_addresses": defaultSweepMaxAddresses,
+
+	"job_queue_size": defaultJobQueueSize,
+	"job_retention":  defaultJobRetention,
+
+	"health_check_interval":     defaultHealthCheckInterval,
+	"health_max_latency":        defaultHealthMaxLatency,
+	"health_failure_threshold":  defaultHealthFailureThreshold,
+	"health_recovery_threshold": defaultHealthRecoveryThreshold,
+}
+
+// positive are the settings where zero would stop lookups or the server from working
+var positive = map[string]bool{
+	"pool_timeout":              true,
+	"dns_timeout":               true,
+	"dns_attempts":              true,
+	"ratelimit_burst":           true,
+	"cache_size":                true,
+	"zone_transfer_timeout":     true,
+	"zone_transfer_max_records": true,
+	"sweep_max_addresses":       true,
+	"job_queue_size":            true,
+	"job_retention":             true,
+	"health_check_interval":     true,
+}
+
+// setDefaults fills in the settings that aren't in set by their yaml names
+// Config files created by older versions don't have the newer settings
+func (c *Config) setDefaults(set map[string]bool) {
+
+	v := reflect.ValueOf(c).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		name := settingName(v.Type().Field(i))
+		if value, ok := defaults[name]; ok && !set[name] {
+			v.Field(i).Set(reflect.ValueOf(value))
 		}
-		defer f.Close()
+	}
+}
 
-		_, err = f.Write(y)
-		if err != nil {
-			panic(err)
+// validate returns every invalid setting in one error
+func (c *Config) validate() error {
+
+	var problems []string
+
+	// Every int setting is a count, size or duration
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		name := settingName(v.Type().Field(i))
+		if v.Field(i).Kind() != reflect.Int {
+			continue
+		}
+		if v.Field(i).Int() < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative, got %d", name, v.Field(i).Int()))
+		}
+		if v.Field(i).Int() == 0 && positive[name] {
+			problems = append(problems, fmt.Sprintf("%s must be greater than 0", name))
 		}
+	}
 
-		fmt.Printf("\nCreated %s\n", configFile)
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		problems = append(problems, fmt.Sprintf("listen_address %q must be host:port or :port", c.ListenAddress))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		problems = append(problems, fmt.Sprintf("log_level %q must be debug, info, warn or error", c.LogLevel))
+	}
+	if c.CacheMaxTTL > 0 && c.CacheMinTTL > c.CacheMaxTTL {
+		problems = append(problems, "cache_min_ttl is greater than cache_max_ttl")
+	}
 
-		return true
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
 	}
 
-	return false
+	return nil
 }
 
-func LoadConfig() *Config {
+// override applies the DNSRECON_ environment variables and then the command line flags and adds them to set
+func (c *Config) override(set map[string]bool) error {
 
-	var c Config
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
 
-	b, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		panic(err)
+	for i := 0; i < t.NumField(); i++ {
+
+		name := settingName(t.Field(i))
+
+		if value, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(name)); ok {
+			if err := setField(v.Field(i), value); err != nil {
+				return fmt.Errorf("%s%s: %v", EnvPrefix, strings.ToUpper(name), err)
+			}
+			set[name] = true
+		}
+
+		if value, ok := flagOverrides[name]; ok {
+			if err := setField(v.Field(i), value); err != nil {
+				return fmt.Errorf("-%s: %v", flagName(name), err)
+			}
+			set[name] = true
+		}
 	}
 
-	if err := yaml.Unmarshal(b, &c); err != nil {
-		panic(err)
+	return nil
+}
+
+// RegisterFlags adds -config and a flag per setting, e.g. -listen-address for listen_address
+func RegisterFlags(flags *flag.FlagSet) {
+
+	flags.Func("config", fmt.Sprintf("config file (default %s)", ConfigFile), func(filename string) error {
+		ConfigFile = filename
+		return nil
+	})
+
+	t := reflect.TypeOf(Config{})
+
+	for i := 0; i < t.NumField(); i++ {
+
+		field := t.Field(i)
+		name := settingName(field)
+
+		usage := fmt.Sprintf("overrides %s in the config file and %s%s", name, EnvPrefix, strings.ToUpper(name))
+		if field.Type.Kind() == reflect.Slice {
+			usage += ", values are comma separated"
+		}
+
+		flags.Func(flagName(name), usage, func(value string) error {
+			// Parse now so invalid values are reported with the usage
+			if err := setField(reflect.New(field.Type).Elem(), value); err != nil {
+				return err
+			}
+			flagOverrides[name] = value
+			return nil
+		})
+	}
+}
+
+// setField parses the value into an int, string or comma separated string list
+func setField(field reflect.Value, value string) error {
+
+	switch field.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(int64(n))
+
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
+
+func settingName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+func flagName(setting string) string {
+	return strings.Replace(setting, "_", "-", -1)
+}
+
+// Change is a setting that differs between two configs
+type Change struct {
+	Setting string
+	Old     interface{}
+	New     interface{}
+}
+
+// Diff lists the settings that differ by their yaml names
+func Diff(old *Config, new *Config) []Change {
+
+	var changes []Change
+
+	o := reflect.ValueOf(*old)
+	n := reflect.ValueOf(*new)
+	t := o.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changes = append(changes, Change{
+				Setting: settingName(t.Field(i)),
+				Old:     o.Field(i).Interface(),
+				New:     n.Field(i).Interface(),
+			})
+		}
 	}
 
-	return &c
+	return changes
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readConfig reads the yaml as the config file with the command line flags in args
+func readConfig(t *testing.T, yaml string, args ...string) (*Config, error) {
+
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := ConfigFile
+	ConfigFile = filename
+	flagOverrides = make(map[string]string)
+	t.Cleanup(func() {
+		ConfigFile = configFile
+		flagOverrides = make(map[string]string)
+	})
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return ReadConfig()
+}
+
+func TestReadConfigZeroSettings(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		yaml  string
+		env   string
+		args  []string
+		check func(c *Config) bool
+	}{
+		{
+			name: "missing settings get defaults",
+			yaml: "listen_address: :8053\n",
+			check: func(c *Config) bool {
+				return c.CacheMaxTTL == defaultCacheMaxTTL && c.DnsRetryDelay == defaultDnsRetryDelay
+			},
+		},
+		{
+			name: "empty values get defaults",
+			yaml: "cache_max_ttl:\ndns_retry_delay:\n",
+			check: func(c *Config) bool {
+				return c.CacheMaxTTL == defaultCacheMaxTTL && c.DnsRetryDelay == defaultDnsRetryDelay
+			},
+		},
+		{
+			name: "zero in the file",
+			yaml: "cache_max_ttl: 0\ndns_retry_delay: 0\nread_timeout: 0\nhealth_max_latency: 0\n",
+			check: func(c *Config) bool {
+				return c.CacheMaxTTL == 0 && c.DnsRetryDelay == 0 && c.ReadTimeout == 0 && c.HealthMaxLatency == 0
+			},
+		},
+		{
+			name:  "zero in the environment",
+			yaml:  "dns_retry_delay: 250\n",
+			env:   "0",
+			check: func(c *Config) bool { return c.DnsRetryDelay == 0 },
+		},
+		{
+			name:  "zero flag",
+			yaml:  "cache_max_ttl: 60\n",
+			args:  []string{"-cache-max-ttl", "0"},
+			check: func(c *Config) bool { return c.CacheMaxTTL == 0 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if tt.env != "" {
+				t.Setenv(EnvPrefix+"DNS_RETRY_DELAY", tt.env)
+			}
+
+			c, err := readConfig(t, tt.yaml, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("got %+v", c)
+			}
+		})
+	}
+}
+
+func TestReadConfigPositiveSettings(t *testing.T) {
+
+	_, err := readConfig(t, "dns_timeout: 0\ncache_size: 0\n")
+	if err == nil {
+		t.Fatal("zero dns_timeout and cache_size accepted")
+	}
+
+	for _, name := range []string{"dns_timeout", "cache_size"} {
+		if !strings.Contains(err.Error(), name+" must be greater than 0") {
+			t.Errorf("%v doesn't report %s", err, name)
+		}
+	}
+}
@@ -1,86 +1,293 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
 	"dnsrecon/config"
 	"dnsrecon/dnsrecon"
 	"dnsrecon/handlers"
+	"dnsrecon/jobs"
 	"dnsrecon/logging"
+	"dnsrecon/metrics"
 	"dnsrecon/resolvers"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 )
 
 func main() {
 
-	s := handlers.Server{}
-
-	resolvers.CreateResolversFile()
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
-	created := config.CreateConfig()
-	if created {
-		return
+	switch command {
+	case "serve":
+		serve(args)
+	case "lookup":
+		os.Exit(lookupCommand(args))
+	case "scan":
+		os.Exit(scanCommand(args))
+	case "trace":
+		os.Exit(traceCommand(args))
+	case "ip":
+		os.Exit(ipCommand(args))
+	case "resolvers":
+		os.Exit(resolversCommand(args))
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		usage()
+		os.Exit(exitUsage)
 	}
-	s.Config = config.LoadConfig()
+}
 
-	resolvers := resolvers.LoadResolvers()
+func serve(args []string) {
 
-	cache := dnsrecon.NewLruCache()
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	registerConfigFlags(flags)
+	flags.Parse(args)
 
-	s.Log = logging.NewLogger()
+	// Containers can run without the files, the defaults are used when they can't be created
+	if err := resolvers.CreateResolversFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, using the default resolvers\n", err)
+	}
+	if _, err := config.CreateConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, using the default config\n", err)
+	}
 
-	s.DnsClientChan = make(chan *dnsrecon.DnsClient, len(resolvers.DnsServers))
-	rCount := 0
+	s, rs, err := newServer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
 
-	// Load the resolvers
-	for _, resolver := range resolvers.DnsServers {
-		if rCount != 0 && rCount == s.Config.MaximumDnsServers {
-			break
-		}
-		client := dnsrecon.NewDnsClient()
-		client.Resolver = resolver
-		client.RetryResolvers = resolvers.RetryServers
-		client.Ratelimit = resolver.Ratelimit
-		client.Cache = cache
-		client.Log = logging.NewLogger()
-		client.Start()
-		s.DnsClientChan <- client
+	// Unhealthy resolvers are taken out of the pool by the background probes
+	s.Health.Start()
 
-		rCount++
-	}
+	s.Log.Info("resolvers loaded", "enabled", s.Pool.Size())
 
-	fmt.Printf("Using %d public dns servers\n", rCount)
+	// Reload config.yaml and resolvers.yaml on SIGHUP or when they change
+	rl := &reloader{s: s, config: s.Config, resolvers: rs}
+	rl.Start()
 
-	//  Clear the LRU cache every 24 hours
-	go func() {
-		for range time.Tick(time.Hour * 24) {
+	// Jobs borrow clients from the pool, by default half of them are left for the other endpoints
+	workers := s.Config.JobWorkers
+	if workers == 0 {
+		workers = s.Pool.Size() / 2
+	}
+	if workers < 1 {
+		workers = 1
+	}
 
-			cache.Mu.Lock()
-			cache.Lru.Clear()
-			cache.Mu.Unlock()
-		}
-	}()
+	s.Jobs = &jobs.Manager{
+		Pool:      s.Pool,
+		Workers:   workers,
+		QueueSize: s.Config.JobQueueSize,
+		Retention: time.Duration(s.Config.JobRetention) * time.Second,
+		Wordlist:  s.Wordlist,
+		Log:       s.Log,
+	}
+	s.Jobs.Start()
+
+	dnsrecon.RegisterCacheMetrics(s.Cache)
 
 	r := mux.NewRouter()
+	r.Use(handlers.Metrics)
 
 	r.HandleFunc("/", healthCheckHandler)
 
+	r.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+
+	r.Path("/resolvers").Methods("GET").HandlerFunc(s.ResolversHandler)
+
+	r.Path("/cache").Methods("GET").HandlerFunc(s.CacheStatsHandler)
+
 	r.Path("/domain/{domain}").Methods("GET").HandlerFunc(s.HandleFunc(s.TargetDomainHandler))
 
-	fmt.Println("Listening on port 8080")
+	r.Path("/v2/domain/{domain}").Methods("GET").HandlerFunc(s.HandleFunc(s.TargetDomainV2Handler))
+
+	r.Path("/enumerate/{domain}").Methods("GET", "POST").HandlerFunc(s.HandleFunc(s.EnumerateHandler))
+
+	r.Path("/trace/{domain}").Methods("GET").HandlerFunc(s.HandleFunc(s.TraceHandler))
+
+	// The range is an address or a CIDR, its slash is part of the path
+	r.Path("/ip/{range:.+}").Methods("GET").HandlerFunc(s.HandleFunc(s.IPHandler))
+
+	r.Path("/consensus/{domain}").Methods("GET").HandlerFunc(s.ConsensusHandler)
+
+	r.Path("/bulk").Methods("POST").HandlerFunc(s.BulkHandler)
+
+	r.Path("/jobs").Methods("POST").HandlerFunc(s.CreateJobHandler)
+
+	r.Path("/jobs/{id}").Methods("GET").HandlerFunc(s.GetJobHandler)
+
+	r.Path("/jobs/{id}").Methods("DELETE").HandlerFunc(s.CancelJobHandler)
+
+	s.Log.Info("listening", "address", s.Config.ListenAddress)
 
 	http.Handle("/", r)
 
 	srv := &http.Server{
-		ReadTimeout:  80 * time.Second,
-		WriteTimeout: 80 * time.Second,
-		Addr:         ":8080",
+		ReadTimeout:  time.Duration(s.Config.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(s.Config.WriteTimeout) * time.Second,
+		Addr:         s.Config.ListenAddress,
+	}
+
+	if err := srv.ListenAndServe(); err != nil {
+		s.Log.Error("server stopped", "error", err)
+		os.Exit(exitFailure)
+	}
+
+}
+
+// newServer loads the config and resolvers and fills the pool with a dns client per resolver
+func newServer() (*handlers.Server, *resolvers.Resolvers, error) {
+
+	var err error
+
+	s := &handlers.Server{}
+
+	s.Config, err = config.ReadConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: %v", err)
+	}
+
+	rs, err := resolvers.ReadResolvers()
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolvers: %v", err)
+	}
+	if errs := rs.Validate(); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("resolvers: %s: %v", resolvers.ResolversFile, errs[0])
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	s.Cache = dnsrecon.NewDnsCache(s.Config.CacheSize, time.Duration(s.Config.CacheMinTTL)*time.Second, time.Duration(s.Config.CacheMaxTTL)*time.Second)
+
+	if err := logging.SetLevel(s.Config.LogLevel); err != nil {
+		return nil, nil, fmt.Errorf("config: %v", err)
+	}
+	s.Log = logging.NewLogger()
+
+	s.Wordlist = dnsrecon.DefaultWordlist
+	if s.Config.SubdomainWordlist != "" {
+		s.Wordlist, err = dnsrecon.LoadWordlist(s.Config.SubdomainWordlist)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: subdomain_wordlist: %v", err)
+		}
+	}
+
+	if s.Config.SinkholeList != "" {
+		s.Sinkholes, err = dnsrecon.LoadSinkholes(s.Config.SinkholeList)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: sinkhole_list: %v", err)
+		}
+	}
+
+	dnsClients, err := newDnsClients(s.Config, rs, s.Cache, s.Log, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: %v", err)
+	}
+
+	s.Pool = dnsrecon.NewPool(dnsClients)
+
+	knownAnswers := s.Config.HealthKnownAnswers
+	if len(knownAnswers) == 0 {
+		knownAnswers = dnsrecon.DefaultKnownAnswers
+	}
+	answers, err := dnsrecon.ParseKnownAnswers(knownAnswers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: health_known_answers: %v", err)
+	}
+
+	s.Health = &dnsrecon.HealthMonitor{
+		Pool:              s.Pool,
+		KnownAnswers:      answers,
+		Interval:          time.Duration(s.Config.HealthCheckInterval) * time.Second,
+		MaxLatency:        time.Duration(s.Config.HealthMaxLatency) * time.Millisecond,
+		FailureThreshold:  s.Config.HealthFailureThreshold,
+		RecoveryThreshold: s.Config.HealthRecoveryThreshold,
+		Log:               s.Log,
+	}
+
+	return s, rs, nil
+}
+
+// newDnsClients starts a dns client per enabled resolver
+// Clients in reuse are kept for resolvers with the same name and addresses
+func newDnsClients(c *config.Config, rs *resolvers.Resolvers, cache *dnsrecon.DnsCache, logger *slog.Logger, reuse map[string]*dnsrecon.DnsClient) ([]*dnsrecon.DnsClient, error) {
+
+	trustAnchors := c.DnssecTrustAnchors
+	if len(trustAnchors) == 0 {
+		trustAnchors = dnsrecon.DefaultTrustAnchors
+	}
+	anchors, err := dnsrecon.ParseTrustAnchors(trustAnchors)
+	if err != nil {
+		return nil, fmt.Errorf("dnssec_trust_anchors: %v", err)
+	}
+
+	rootHints := c.RootHints
+	if len(rootHints) == 0 {
+		rootHints = dnsrecon.DefaultRootHints
+	}
+	hints, err := dnsrecon.ParseRootHints(rootHints)
+	if err != nil {
+		return nil, fmt.Errorf("root_hints: %v", err)
+	}
+
+	dnsClients := make([]*dnsrecon.DnsClient, 0, len(rs.DnsServers))
+	seen := make(map[string]bool)
+
+	// Load the resolvers
+	for _, resolver := range rs.DnsServers {
+		if len(dnsClients) != 0 && len(dnsClients) == c.MaximumDnsServers {
+			break
+		}
+		if !resolver.Enable {
+			continue
+		}
+
+		// Older resolvers files list some resolvers twice
+		key := resolverKey(resolver)
+		if seen[key] {
+			logger.Warn("skipping duplicate resolver", "resolver", resolver.Nameserver)
+			continue
+		}
+		seen[key] = true
+
+		if client, ok := reuse[key]; ok {
+			dnsClients = append(dnsClients, client)
+			continue
+		}
+
+		client := dnsrecon.NewDnsClient()
+		client.Resolver = resolver
+		client.RetryResolvers = rs.RetryServers
+		client.Ratelimit = resolver.Ratelimit
+		client.Cache = cache
+		client.TrustAnchors = anchors
+		client.RootHints = hints
+		client.DKIMSelectors = c.DKIMSelectors
+		client.Timeout = time.Duration(c.DnsTimeout) * time.Second
+		client.Burst = c.RatelimitBurst
+		client.Attempts = c.DnsAttempts
+		client.RetryDelay = time.Duration(c.DnsRetryDelay) * time.Millisecond
+		client.ZoneTransferTimeout = time.Duration(c.ZoneTransferTimeout) * time.Second
+		client.ZoneTransferMaxRecords = c.ZoneTransferMaxRecords
+		client.Log = logger.With("resolver", resolver.Nameserver)
+		client.Start()
+		dnsClients = append(dnsClients, client)
+	}
+
+	return dnsClients, nil
+}
 
+func resolverKey(resolver *resolvers.Resolver) string {
+	return fmt.Sprintf("%s %v", resolver.Nameserver, resolver.Ips)
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
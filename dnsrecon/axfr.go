@@ -0,0 +1,225 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	TransferAllowed     = "allowed"
+	TransferRefused     = "refused"
+	TransferUnreachable = "unreachable"
+	TransferTimeout     = "timeout"
+	TransferError       = "error"
+
+	defaultTransferTimeout    = time.Second * 30
+	defaultTransferMaxRecords = 100000
+)
+
+// ZoneTransfer is the result of an AXFR or IXFR attempt against one nameserver address
+type ZoneTransfer struct {
+	Nameserver string   `json:"nameserver"`
+	Address    string   `json:"address"`
+	Type       string   `json:"type"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Count      int      `json:"count"`
+	Truncated  bool     `json:"truncated"`
+	Duration   float64  `json:"duration_ms"`
+	Records    []Record `json:"records"`
+}
+
+type transferTarget struct {
+	nameserver string
+	address    string
+}
+
+// transferTargets lists every address of the SOA primary and the NS records once
+func transferTargets(domainData *DomainData) []transferTarget {
+
+	var targets []transferTarget
+	seen := make(map[string]bool)
+
+	add := func(nameserver string, ipset IpSet) {
+		for _, ip := range append(append([]string{}, ipset.A...), ipset.AAAA...) {
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			targets = append(targets, transferTarget{nameserver: nameserver, address: net.JoinHostPort(ip, "53")})
+		}
+	}
+
+	for nameserver, ipset := range domainData.Data.SOA.Nameserver {
+		add(nameserver, ipset)
+	}
+	for nameserver, ipset := range domainData.Data.NS {
+		add(nameserver, ipset)
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].nameserver != targets[j].nameserver {
+			return targets[i].nameserver < targets[j].nameserver
+		}
+		return targets[i].address < targets[j].address
+	})
+
+	return targets
+}
+
+// getZoneTransfers tries an AXFR, and an IXFR when a serial is set, against every nameserver address
+func (client *DnsClient) getZoneTransfers(ctx context.Context, domainData *DomainData, ixfrSerial uint32) []ZoneTransfer {
+
+	zone := domainData.Data.SOA.Name
+	if zone == "" {
+		zone = domainData.Name
+	}
+
+	var transferChannels []chan ZoneTransfer
+
+	for _, target := range transferTargets(domainData) {
+
+		qtypes := []uint16{dns.TypeAXFR}
+		if ixfrSerial != 0 {
+			qtypes = append(qtypes, dns.TypeIXFR)
+		}
+
+		for _, qtype := range qtypes {
+			transferChan := make(chan ZoneTransfer, 1)
+			go client.transferZone(ctx, zone, target, qtype, ixfrSerial, transferChan)
+			transferChannels = append(transferChannels, transferChan)
+		}
+	}
+
+	transfers := make([]ZoneTransfer, 0, len(transferChannels))
+
+	for _, transferChan := range transferChannels {
+		transfers = append(transfers, <-transferChan)
+	}
+
+	return transfers
+}
+
+// transferZone runs a single zone transfer over TCP within the configured time and size limits
+func (client *DnsClient) transferZone(ctx context.Context, zone string, target transferTarget, qtype uint16, serial uint32, transferChan chan<- ZoneTransfer) {
+
+	transfer := ZoneTransfer{
+		Nameserver: target.nameserver,
+		Address:    target.address,
+		Type:       dns.TypeToString[qtype],
+		Records:    make([]Record, 0),
+	}
+
+	start := time.Now()
+	defer func() {
+		transfer.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+		transferChan <- transfer
+	}()
+
+	timeout := client.ZoneTransferTimeout
+	if timeout == 0 {
+		timeout = defaultTransferTimeout
+	}
+	maxRecords := client.ZoneTransferMaxRecords
+	if maxRecords == 0 {
+		maxRecords = defaultTransferMaxRecords
+	}
+
+	if err := client.RatelimitRequests(ctx); err != nil {
+		transfer.Status = TransferError
+		transfer.Error = err.Error()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", target.address)
+	if err != nil {
+		transfer.Status, transfer.Error = transferStatus(ctx, err)
+		return
+	}
+
+	// The deadline covers the whole transfer, closing the connection stops it when ctx is cancelled
+	conn.SetDeadline(time.Now().Add(timeout))
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	m := new(dns.Msg)
+	if qtype == dns.TypeIXFR {
+		m.SetIxfr(fqdn(zone), serial, ".", ".")
+	} else {
+		m.SetAxfr(fqdn(zone))
+	}
+
+	t := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: timeout}
+
+	envelopes, err := t.In(m, target.address)
+	if err != nil {
+		transfer.Status, transfer.Error = transferStatus(ctx, err)
+		return
+	}
+
+	for envelope := range envelopes {
+
+		// Keep draining after an error so the transfer goroutine can exit
+		if transfer.Error != "" {
+			continue
+		}
+
+		if envelope.Error != nil {
+			transfer.Status, transfer.Error = transferStatus(ctx, envelope.Error)
+			continue
+		}
+
+		for _, rr := range envelope.RR {
+
+			if transfer.Count == maxRecords {
+				transfer.Truncated = true
+				cancel()
+				break
+			}
+
+			transfer.Records = append(transfer.Records, newRecord(rr))
+			transfer.Count++
+		}
+	}
+
+	// Records received before the size limit was hit still show the zone was transferable
+	if transfer.Error == "" || transfer.Truncated {
+		transfer.Status = TransferAllowed
+		transfer.Error = ""
+	}
+}
+
+// transferStatus classifies why a zone transfer failed
+func transferStatus(ctx context.Context, err error) (string, string) {
+
+	// A failed TCP connect says nothing about the transfer policy of the server
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return TransferUnreachable, err.Error()
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return TransferTimeout, err.Error()
+	}
+
+	if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+		return TransferTimeout, err.Error()
+	}
+
+	// Servers refuse transfers with REFUSED, NOTAUTH or by closing the connection
+	if strings.HasPrefix(err.Error(), "dns: bad xfr rcode") || err == dns.ErrSoa {
+		return TransferRefused, err.Error()
+	}
+
+	return TransferError, fmt.Sprintf("%v", err)
+}
@@ -0,0 +1,87 @@
+package dnsrecon
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"testing"
+)
+
+// serveTCP answers TCP queries with handler until the test ends and returns the address it listens on
+func serveTCP(t *testing.T, handler dns.HandlerFunc) string {
+
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{Listener: l, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+
+	go server.ActivateAndServe()
+	<-started
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	return l.Addr().String()
+}
+
+// closedAddress returns a local TCP address nothing listens on
+func closedAddress(t *testing.T) string {
+
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	return addr
+}
+
+func TestTransferZoneStatus(t *testing.T) {
+
+	refuse := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}
+
+	allow := func(w dns.ResponseWriter, r *dns.Msg) {
+		soa := mustRR(t, "example.test. 300 IN SOA ns.example.test. admin.example.test. 1 3600 600 86400 300")
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{soa, mustRR(t, "example.test. 300 IN A 192.0.2.1"), soa}
+		w.WriteMsg(m)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		status  string
+		count   int
+	}{
+		{name: "allowed", address: serveTCP(t, allow), status: TransferAllowed, count: 3},
+		{name: "refused", address: serveTCP(t, refuse), status: TransferRefused},
+		{name: "unreachable", address: closedAddress(t), status: TransferUnreachable},
+	}
+
+	client := newLocalClient(t, "127.0.0.1:53")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			transferChan := make(chan ZoneTransfer, 1)
+			client.transferZone(context.Background(), "example.test", transferTarget{nameserver: "ns.example.test", address: tt.address}, dns.TypeAXFR, 0, transferChan)
+			transfer := <-transferChan
+
+			if transfer.Status != tt.status || transfer.Count != tt.count {
+				t.Errorf("status = %s (%s) with %d records, want %s with %d", transfer.Status, transfer.Error, transfer.Count, tt.status, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package dnsrecon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	AddressPrivate  = "private"
+	AddressBogon    = "bogon"
+	AddressSinkhole = "sinkhole"
+)
+
+// privatePrefixes are only reachable inside a network, public names resolving to them leak or redirect traffic
+var privatePrefixes = parsePrefixes(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+// bogonPrefixes are reserved and never valid in public answers
+var bogonPrefixes = parsePrefixes(
+	"0.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"100::/64",
+	"2001:db8::/32",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parsePrefixes(cidrs ...string) []*net.IPNet {
+
+	prefixes := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, prefix, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+// ClassifyAddress reports whether the address is private or a bogon and the prefix it's in
+// Public addresses return an empty class
+func ClassifyAddress(ip net.IP) (class string, prefix string) {
+
+	for _, p := range privatePrefixes {
+		if p.Contains(ip) {
+			return AddressPrivate, p.String()
+		}
+	}
+
+	for _, p := range bogonPrefixes {
+		if p.Contains(ip) {
+			return AddressBogon, p.String()
+		}
+	}
+
+	return "", ""
+}
+
+// Sinkholes are addresses that blocked or seized domains are pointed at
+type Sinkholes struct {
+	prefixes []*net.IPNet
+	names    []string
+}
+
+// LoadSinkholes reads a sinkhole list file
+func LoadSinkholes(filename string) (*Sinkholes, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseSinkholes(f)
+}
+
+// ParseSinkholes reads an address or CIDR per line followed by an optional name, e.g. "146.112.61.104 OpenDNS block page"
+// Blank lines and lines starting with # are skipped
+func ParseSinkholes(r io.Reader) (*Sinkholes, error) {
+
+	var s Sinkholes
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		cidr := fields[0]
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid address %q", line, cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, prefix, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, fields[0])
+		}
+
+		name := strings.Join(fields[1:], " ")
+		if name == "" {
+			name = prefix.String()
+		}
+
+		s.prefixes = append(s.prefixes, prefix)
+		s.names = append(s.names, name)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Match returns the name of the sinkhole the address belongs to
+func (s *Sinkholes) Match(ip net.IP) (string, bool) {
+
+	if s == nil {
+		return "", false
+	}
+
+	for i, prefix := range s.prefixes {
+		if prefix.Contains(ip) {
+			return s.names[i], true
+		}
+	}
+
+	return "", false
+}
+
+// Len is the number of sinkhole addresses and ranges
+func (s *Sinkholes) Len() int {
+
+	if s == nil {
+		return 0
+	}
+
+	return len(s.prefixes)
+}
@@ -0,0 +1,66 @@
+package dnsrecon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ParseDomainList reads a JSON array of domains or one domain per line
+// Blank lines and lines starting with # are skipped, duplicates are removed keeping the first occurrence
+func ParseDomainList(r io.Reader) ([]string, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &names); err != nil {
+			return nil, fmt.Errorf("invalid domain list: %v", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+
+			// Lines may also be JSON strings
+			if strings.HasPrefix(line, `"`) {
+				if err := json.Unmarshal([]byte(line), &line); err != nil {
+					return nil, fmt.Errorf("invalid domain list: %v", err)
+				}
+			}
+
+			names = append(names, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var domains []string
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+
+		domain := normalizeDomain(strings.TrimSpace(name))
+		if domain == "" || strings.HasPrefix(domain, "#") || seen[domain] {
+			continue
+		}
+
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("domain list is empty")
+	}
+
+	return domains, nil
+}
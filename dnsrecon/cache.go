@@ -0,0 +1,253 @@
+package dnsrecon
+
+import (
+	"fmt"
+	"github.com/golang/groupcache/lru"
+	"github.com/miekg/dns"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DnsCache stores dns responses until the smallest TTL in the response expires
+// NXDOMAIN and NODATA responses are cached using the SOA minimum (RFC 2308)
+type DnsCache struct {
+	lru    *lru.Cache
+	mu     sync.Mutex
+	minTTL time.Duration
+	maxTTL time.Duration
+
+	// now is the clock, tests replace it
+	now func() time.Time
+
+	hits      uint64
+	misses    uint64
+	expired   uint64
+	evictions uint64
+}
+
+// CacheStats is a snapshot of the cache counters
+type CacheStats struct {
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Expired   uint64 `json:"expired"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	origin  origin
+	stored  time.Time
+	expires time.Time
+}
+
+// NewDnsCache creates a cache holding up to size responses
+// TTLs are clamped to minTTL and maxTTL, a zero maxTTL disables the upper clamp
+func NewDnsCache(size int, minTTL time.Duration, maxTTL time.Duration) *DnsCache {
+
+	var cache DnsCache
+
+	cache.minTTL = minTTL
+	cache.maxTTL = maxTTL
+	cache.lru = lru.New(size)
+	cache.lru.OnEvicted = cache.onEvicted
+	cache.now = time.Now
+
+	return &cache
+}
+
+func cacheKey(m *dns.Msg) string {
+
+	key := fmt.Sprintf("%s:%d", strings.ToLower(m.Question[0].Name), m.Question[0].Qtype)
+
+	// DNSSEC queries get the RRSIGs as well so they are cached separately
+	if opt := m.IsEdns0(); opt != nil && opt.Do() {
+		key += ":do"
+	}
+
+	// Resolvers don't validate queries with checking disabled, their answers can be bogus
+	if m.CheckingDisabled {
+		key += ":cd"
+	}
+
+	return key
+}
+
+// Get returns a copy of the cached response with the TTLs reduced by the time spent in the cache
+func (c *DnsCache) Get(m *dns.Msg) (*dns.Msg, bool) {
+
+	r, _, ok := c.get(m)
+
+	return r, ok
+}
+
+// get also returns the resolver that answered the cached response
+func (c *DnsCache) get(m *dns.Msg) (*dns.Msg, origin, bool) {
+
+	key := cacheKey(m)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.lru.Get(key)
+	if !ok {
+		c.misses++
+		return nil, origin{}, false
+	}
+
+	entry := value.(*cacheEntry)
+
+	if !now.Before(entry.expires) {
+		c.lru.Remove(key)
+		c.expired++
+		c.misses++
+		return nil, origin{}, false
+	}
+
+	c.hits++
+
+	r := entry.msg.Copy()
+	r.Id = m.Id
+	ageTTLs(r, uint32(now.Sub(entry.stored)/time.Second))
+
+	return r, entry.origin, true
+}
+
+// onEvicted counts entries pushed out of the lru before their TTL expired
+func (c *DnsCache) onEvicted(key lru.Key, value interface{}) {
+
+	entry := value.(*cacheEntry)
+	if c.now().Before(entry.expires) {
+		c.evictions++
+	}
+}
+
+// Set stores a response if it is cacheable
+func (c *DnsCache) Set(m *dns.Msg, r *dns.Msg) {
+	c.set(m, r, origin{})
+}
+
+// set also stores the resolver that answered the response
+func (c *DnsCache) set(m *dns.Msg, r *dns.Msg, o origin) {
+
+	ttl, ok := c.cacheTTL(r)
+	if !ok {
+		return
+	}
+
+	now := c.now()
+
+	entry := cacheEntry{
+		msg:     r.Copy(),
+		origin:  o,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	c.lru.Add(cacheKey(m), &entry)
+	c.mu.Unlock()
+}
+
+// Clear removes every entry from the cache
+func (c *DnsCache) Clear() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Cleared entries weren't pushed out by newer ones so they aren't counted as evictions
+	c.lru.OnEvicted = nil
+	c.lru.Clear()
+	c.lru.OnEvicted = c.onEvicted
+}
+
+// Stats returns the cache counters
+func (c *DnsCache) Stats() CacheStats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Size:      c.lru.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Expired:   c.expired,
+		Evictions: c.evictions,
+	}
+}
+
+// cacheTTL works out how long a response can be cached for
+// Positive answers use the smallest answer TTL, negative answers use the SOA in the authority section
+func (c *DnsCache) cacheTTL(r *dns.Msg) (time.Duration, bool) {
+
+	if r == nil || r.Truncated {
+		return 0, false
+	}
+
+	var ttl uint32
+	found := false
+
+	switch {
+	case r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0:
+		for _, rr := range r.Answer {
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+
+	case r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError:
+		// NODATA or NXDOMAIN, the negative TTL is the smaller of the SOA TTL and SOA minimum
+		for _, rr := range r.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = soa.Header().Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				found = true
+				break
+			}
+		}
+
+	default:
+		return 0, false
+	}
+
+	// Negative responses without an SOA must not be cached
+	if !found {
+		return 0, false
+	}
+
+	d := time.Duration(ttl) * time.Second
+
+	if d < c.minTTL {
+		d = c.minTTL
+	}
+	if c.maxTTL > 0 && d > c.maxTTL {
+		d = c.maxTTL
+	}
+
+	if d <= 0 {
+		return 0, false
+	}
+
+	return d, true
+}
+
+func ageTTLs(r *dns.Msg, age uint32) {
+
+	for _, section := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > age {
+				rr.Header().Ttl -= age
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+}
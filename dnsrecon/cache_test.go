@@ -0,0 +1,144 @@
+package dnsrecon
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+	"time"
+)
+
+// response builds a reply to a query for example.test with the records in the answer and authority sections
+func response(t *testing.T, rcode int, answer []string, ns []string) *dns.Msg {
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+
+	r := new(dns.Msg)
+	r.SetRcode(m, rcode)
+	for _, s := range answer {
+		r.Answer = append(r.Answer, mustRR(t, s))
+	}
+	for _, s := range ns {
+		r.Ns = append(r.Ns, mustRR(t, s))
+	}
+
+	return r
+}
+
+func TestCacheTTL(t *testing.T) {
+
+	soa := func(ttl string, minimum string) []string {
+		return []string{"example.test. " + ttl + " IN SOA ns.example.test. admin.example.test. 1 3600 600 86400 " + minimum}
+	}
+
+	truncated := response(t, dns.RcodeSuccess, []string{"example.test. 300 IN A 192.0.2.1"}, nil)
+	truncated.Truncated = true
+
+	tests := []struct {
+		name     string
+		r        *dns.Msg
+		min, max time.Duration
+		ttl      time.Duration
+	}{
+		{name: "smallest answer TTL", r: response(t, dns.RcodeSuccess, []string{"example.test. 300 IN A 192.0.2.1", "example.test. 60 IN A 192.0.2.2"}, nil), ttl: time.Minute},
+		{name: "NXDOMAIN uses the SOA minimum", r: response(t, dns.RcodeNameError, nil, soa("900", "300")), ttl: 5 * time.Minute},
+		{name: "NODATA uses the SOA TTL", r: response(t, dns.RcodeSuccess, nil, soa("120", "300")), ttl: 2 * time.Minute},
+		{name: "NXDOMAIN without SOA", r: response(t, dns.RcodeNameError, nil, nil)},
+		{name: "NODATA without SOA", r: response(t, dns.RcodeSuccess, nil, []string{"example.test. 300 IN NS ns.example.test."})},
+		{name: "SERVFAIL", r: response(t, dns.RcodeServerFailure, nil, soa("300", "300"))},
+		{name: "truncated", r: truncated},
+		{name: "zero TTL", r: response(t, dns.RcodeSuccess, []string{"example.test. 0 IN A 192.0.2.1"}, nil)},
+		{name: "min clamp", r: response(t, dns.RcodeSuccess, []string{"example.test. 5 IN A 192.0.2.1"}, nil), min: 30 * time.Second, ttl: 30 * time.Second},
+		{name: "max clamp", r: response(t, dns.RcodeSuccess, []string{"example.test. 86400 IN A 192.0.2.1"}, nil), max: 10 * time.Minute, ttl: 10 * time.Minute},
+		{name: "negative max clamp", r: response(t, dns.RcodeNameError, nil, soa("3600", "3600")), max: 10 * time.Minute, ttl: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ttl, ok := NewDnsCache(10, tt.min, tt.max).cacheTTL(tt.r)
+
+			if ok != (tt.ttl > 0) || ttl != tt.ttl {
+				t.Errorf("got %v, %v, want %v", ttl, ok, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestCacheAging(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cache := NewDnsCache(10, 0, 0)
+	cache.now = func() time.Time { return now }
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+	m.SetEdns0(1232, false)
+
+	r := response(t, dns.RcodeSuccess, []string{"example.test. 300 IN A 192.0.2.1"}, []string{"example.test. 3600 IN NS ns.example.test."})
+	r.SetEdns0(1232, false)
+
+	cache.Set(m, r)
+
+	now = now.Add(100 * time.Second)
+
+	m.Id = 1234
+	cached, ok := cache.Get(m)
+	if !ok {
+		t.Fatal("response not cached")
+	}
+	if cached.Id != 1234 {
+		t.Errorf("id %d, want the id of the query", cached.Id)
+	}
+	if ttl := cached.Answer[0].Header().Ttl; ttl != 200 {
+		t.Errorf("answer TTL %d, want 200", ttl)
+	}
+	if ttl := cached.Ns[0].Header().Ttl; ttl != 3500 {
+		t.Errorf("authority TTL %d, want 3500", ttl)
+	}
+	if ttl := cached.IsEdns0().Hdr.Ttl; ttl != r.IsEdns0().Hdr.Ttl {
+		t.Errorf("OPT TTL changed to %d", ttl)
+	}
+
+	// The stored response isn't aged by the copies
+	if ttl := r.Answer[0].Header().Ttl; ttl != 300 {
+		t.Errorf("original TTL changed to %d", ttl)
+	}
+
+	now = now.Add(200 * time.Second)
+
+	if _, ok := cache.Get(m); ok {
+		t.Error("expired response returned")
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Expired != 1 || stats.Size != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+
+	query := func(name string, do bool, cd bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		m.SetEdns0(1232, do)
+		m.CheckingDisabled = cd
+		return m
+	}
+
+	keys := map[string]bool{}
+	for _, m := range []*dns.Msg{
+		query("example.test.", false, false),
+		query("example.test.", true, false),
+		query("example.test.", false, true),
+		query("example.test.", true, true),
+	} {
+		keys[cacheKey(m)] = true
+	}
+	if len(keys) != 4 {
+		t.Errorf("keys %v, want DO and CD queries cached separately", keys)
+	}
+
+	if cacheKey(query("Example.TEST.", false, false)) != cacheKey(query("example.test.", false, false)) {
+		t.Error("names differing in case have different keys")
+	}
+}
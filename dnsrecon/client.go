@@ -2,16 +2,23 @@ package dnsrecon
 
 import (
 	"context"
-	"github.com/golang/groupcache/lru"
-	"github.com/miekg/dns"
 	"dnsrecon/resolvers"
+	"errors"
+	"github.com/miekg/dns"
 	"golang.org/x/time/rate"
-	"log"
+	"log/slog"
 	"math/rand"
 	"sync"
 	"time"
 )
 
+const (
+	defaultTimeout    = time.Second * 10
+	defaultBurst      = 5
+	defaultAttempts   = 3
+	defaultRetryDelay = time.Millisecond * 500
+)
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
@@ -21,29 +28,38 @@ type TargetLookup struct {
 	DomainDataCh chan DomainData
 }
 
-type LruCache struct {
-	Lru *lru.Cache
-	Mu  sync.RWMutex
-}
-
-func NewLruCache() *LruCache {
-	var lrucache LruCache
-	lrucache.Lru = NewCache()
-	return &lrucache
-}
-
 type DnsClient struct {
 	dns            *dns.Client
+	tcp            *dns.Client
 	Resolver       *resolvers.Resolver
 	RetryResolvers *resolvers.RetryResolver
 	limiter        *rate.Limiter
 	TargetLookupCh chan TargetLookup
 	ClientId       int
-	Cache          *LruCache
-	Log            *log.Logger
+	Cache          *DnsCache
+	Log            *slog.Logger
 	Ratelimit      int
-	Nameserver     string
-	Nameservers    struct {
+	TrustAnchors   []*dns.DS
+	RootHints      []RootHint
+	DKIMSelectors  []string
+
+	// AuthoritativePort is the port of the nameservers found through referrals by iterative lookups, 53 when empty
+	AuthoritativePort string
+
+	// Query settings, the defaults are used when zero
+	// Attempts includes the first query, the last attempt is sent to the retry resolvers
+	// RetryDelay is the wait before a failed lookup is tried again, zero retries at once
+	Timeout    time.Duration
+	Burst      int
+	Attempts   int
+	RetryDelay time.Duration
+
+	// Limits for zone transfers, the defaults are used when zero
+	ZoneTransferTimeout    time.Duration
+	ZoneTransferMaxRecords int
+
+	Nameserver  string
+	Nameservers struct {
 		Ips   []string
 		mu    sync.Mutex
 		i     int
@@ -52,7 +68,6 @@ type DnsClient struct {
 
 	mu     sync.Mutex
 	muRate sync.Mutex
-	ctx    context.Context
 }
 
 func NewDnsClient() *DnsClient {
@@ -60,35 +75,66 @@ func NewDnsClient() *DnsClient {
 	var dnsClient DnsClient
 
 	dnsClient.dns = &dns.Client{}
+	dnsClient.tcp = &dns.Client{Net: "tcp"}
 
-	return &dnsClient
-}
+	// Zero is a valid delay so its default is set here rather than in Start
+	dnsClient.RetryDelay = defaultRetryDelay
 
-func NewCache() *lru.Cache {
-	return lru.New(10000)
+	return &dnsClient
 }
 
 func (client *DnsClient) Start() {
 
+	if client.Timeout == 0 {
+		client.Timeout = defaultTimeout
+	}
+	if client.Burst == 0 {
+		client.Burst = defaultBurst
+	}
+	if client.Attempts == 0 {
+		client.Attempts = defaultAttempts
+	}
+	if client.AuthoritativePort == "" {
+		client.AuthoritativePort = defaultAuthoritativePort
+	}
+
 	r := rate.Limit(client.Resolver.Ratelimit)
 
-	client.limiter = rate.NewLimiter(r, 5)
+	client.limiter = rate.NewLimiter(r, client.Burst)
 
-	client.dns.Timeout = time.Second * 10
+	client.dns.Timeout = client.Timeout
+	client.tcp.Timeout = client.Timeout
 
 	client.Nameservers.Ips = append(client.Nameservers.Ips, client.Resolver.Ips...)
 
 	client.Nameservers.total = len(client.Nameservers.Ips)
+}
 
-	client.ctx = context.Background()
+// SetRatelimit changes the queries per second of a started client
+func (client *DnsClient) SetRatelimit(ratelimit int) {
+
+	client.muRate.Lock()
+	client.Ratelimit = ratelimit
+	client.muRate.Unlock()
+
+	client.limiter.SetLimit(rate.Limit(ratelimit))
 }
 
-func (client *DnsClient) RatelimitRequests() {
+// RatelimitRequests blocks until the limiter allows another query or the context is done
+func (client *DnsClient) RatelimitRequests(ctx context.Context) error {
 
-	err := client.limiter.WaitN(client.ctx, 1)
+	start := time.Now()
+	err := client.limiter.WaitN(ctx, 1)
+	ratelimitWait.With(client.Resolver.Nameserver).Observe(time.Since(start).Seconds())
 	if err != nil {
-		client.Log.Printf("rate limit error: %v", err)
+		if ctx.Err() != nil {
+			return contextError(ctx)
+		}
+		client.Log.ErrorContext(ctx, "rate limit error", "error", err)
+		return err
 	}
+
+	return nil
 }
 
 func (client *DnsClient) GetNameserver() string {
@@ -108,6 +154,38 @@ func (client *DnsClient) GetNameserver() string {
 
 }
 
+// sleepContext waits for the duration unless the context is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// waitRetry counts a lookup retry and waits RetryDelay before it
+func (client *DnsClient) waitRetry(ctx context.Context) error {
+
+	lookupRetriesTotal.With(client.Resolver.Nameserver).Inc()
+
+	return sleepContext(ctx, client.RetryDelay)
+}
+
+// contextError maps a finished context to the error codes used in DomainData
+func contextError(ctx context.Context) error {
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New(ErrTimeout)
+	}
+
+	return errors.New(ErrCancelled)
+}
+
 func random(min int, max int) int {
 	return rand.Intn(max-min) + min
 }
@@ -0,0 +1,268 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsensusReport compares the answers of several resolvers for the same name
+type ConsensusReport struct {
+	Name      string            `json:"name"`
+	Resolvers []string          `json:"resolvers"`
+	Results   []ConsensusResult `json:"results"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ConsensusResult groups the resolvers by the answers they returned for one record type
+// Resolvers that failed are left out of the groups and reported in Errors
+type ConsensusResult struct {
+	Type string `json:"type"`
+
+	// Consensus is true when every resolver that answered returned the same answers
+	Consensus bool `json:"consensus"`
+
+	// Groups are ordered by the number of resolvers, the majority group is the largest unless there's a tie
+	Groups []AnswerGroup `json:"groups"`
+
+	// Outliers are the resolvers outside the majority group
+	Outliers []string `json:"outliers"`
+
+	// Flagged are private, bogon and sinkhole addresses in the answers
+	Flagged []FlaggedAddress `json:"flagged"`
+
+	Errors map[string]string `json:"errors"`
+}
+
+// AnswerGroup is a set of identical answers, TTLs are ignored
+type AnswerGroup struct {
+	Rcode     string   `json:"rcode"`
+	Answers   []string `json:"answers"`
+	Resolvers []string `json:"resolvers"`
+	Majority  bool     `json:"majority"`
+}
+
+// FlaggedAddress is an answer that points somewhere a public name shouldn't
+type FlaggedAddress struct {
+	Address   string   `json:"address"`
+	Class     string   `json:"class"`
+	Detail    string   `json:"detail"`
+	Resolvers []string `json:"resolvers"`
+}
+
+// Compare resolves the name for each type through every client at the same time
+// The queries skip the cache so each answer comes from the resolver itself
+func Compare(ctx context.Context, clients []*DnsClient, name string, qtypes []uint16, sinkholes *Sinkholes) *ConsensusReport {
+
+	report := &ConsensusReport{
+		Name:      normalizeDomain(name),
+		Resolvers: make([]string, 0, len(clients)),
+		Results:   make([]ConsensusResult, len(qtypes)),
+		Timestamp: time.Now().UTC(),
+	}
+
+	for _, client := range clients {
+		report.Resolvers = append(report.Resolvers, client.Resolver.Nameserver)
+	}
+
+	responses := make([][]*dns.Msg, len(qtypes))
+	errs := make([][]error, len(qtypes))
+
+	var wg sync.WaitGroup
+
+	for i, qtype := range qtypes {
+
+		responses[i] = make([]*dns.Msg, len(clients))
+		errs[i] = make([]error, len(clients))
+
+		for j, client := range clients {
+
+			wg.Add(1)
+
+			go func(i int, j int, client *DnsClient, qtype uint16) {
+				defer wg.Done()
+				responses[i][j], errs[i][j] = client.queryResolver(ctx, name, qtype)
+			}(i, j, client, qtype)
+		}
+	}
+
+	wg.Wait()
+
+	for i, qtype := range qtypes {
+		report.Results[i] = compareAnswers(qtype, report.Resolvers, responses[i], errs[i], sinkholes)
+	}
+
+	return report
+}
+
+// compareAnswers groups the responses of one record type
+func compareAnswers(qtype uint16, resolvers []string, responses []*dns.Msg, errs []error, sinkholes *Sinkholes) ConsensusResult {
+
+	result := ConsensusResult{
+		Type:     dns.TypeToString[qtype],
+		Groups:   make([]AnswerGroup, 0),
+		Outliers: make([]string, 0),
+		Flagged:  make([]FlaggedAddress, 0),
+		Errors:   make(map[string]string),
+	}
+
+	groups := make(map[string]*AnswerGroup)
+	var keys []string
+
+	flagged := make(map[string]*FlaggedAddress)
+	var addresses []string
+
+	for i, r := range responses {
+
+		resolver := resolvers[i]
+
+		if errs[i] != nil {
+			result.Errors[resolver] = errs[i].Error()
+			continue
+		}
+
+		rcode := dns.RcodeToString[r.Rcode]
+		answers := answerSet(r, qtype)
+
+		key := rcode + "\n" + strings.Join(answers, "\n")
+		group, ok := groups[key]
+		if !ok {
+			group = &AnswerGroup{Rcode: rcode, Answers: answers}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Resolvers = append(group.Resolvers, resolver)
+
+		for _, rr := range r.Answer {
+
+			var ip net.IP
+			switch v := rr.(type) {
+			case *dns.A:
+				ip = v.A
+			case *dns.AAAA:
+				ip = v.AAAA
+			default:
+				continue
+			}
+
+			class, detail := ClassifyAddress(ip)
+			if name, ok := sinkholes.Match(ip); ok {
+				class, detail = AddressSinkhole, name
+			}
+			if class == "" {
+				continue
+			}
+
+			address := ip.String()
+			flag, ok := flagged[address]
+			if !ok {
+				flag = &FlaggedAddress{Address: address, Class: class, Detail: detail}
+				flagged[address] = flag
+				addresses = append(addresses, address)
+			}
+			if !containsFold(flag.Resolvers, resolver) {
+				flag.Resolvers = append(flag.Resolvers, resolver)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		result.Groups = append(result.Groups, *groups[key])
+	}
+
+	sort.SliceStable(result.Groups, func(i, j int) bool {
+		return len(result.Groups[i].Resolvers) > len(result.Groups[j].Resolvers)
+	})
+
+	result.Consensus = len(result.Groups) == 1
+
+	// Without a single largest group no resolver is an outlier
+	if len(result.Groups) == 1 || len(result.Groups) > 1 && len(result.Groups[0].Resolvers) > len(result.Groups[1].Resolvers) {
+		result.Groups[0].Majority = true
+		for _, group := range result.Groups[1:] {
+			result.Outliers = append(result.Outliers, group.Resolvers...)
+		}
+	}
+
+	for _, address := range addresses {
+		result.Flagged = append(result.Flagged, *flagged[address])
+	}
+
+	return result
+}
+
+// nameRdata are the types whose rdata is made of names and numbers, names are compared without case
+// The rdata of other types, e.g. TXT, is compared as returned
+var nameRdata = map[uint16]bool{
+	dns.TypeCNAME: true,
+	dns.TypeDNAME: true,
+	dns.TypeNS:    true,
+	dns.TypePTR:   true,
+	dns.TypeMX:    true,
+	dns.TypeSRV:   true,
+	dns.TypeSOA:   true,
+}
+
+// answerSet returns the sorted rdata of the answers of the type and any CNAMEs leading to them
+func answerSet(r *dns.Msg, qtype uint16) []string {
+
+	answers := make([]string, 0, len(r.Answer))
+
+	for _, rr := range r.Answer {
+		t := rr.Header().Rrtype
+		if t != qtype && t != dns.TypeCNAME {
+			continue
+		}
+		rdata := strings.TrimPrefix(rr.String(), rr.Header().String())
+		if nameRdata[t] {
+			rdata = strings.ToLower(rdata)
+		}
+		answers = append(answers, fmt.Sprintf("%s %s %s", normalizeDomain(rr.Header().Name), dns.TypeToString[t], rdata))
+	}
+
+	sort.Strings(answers)
+
+	return answers
+}
+
+// queryResolver sends the query to the addresses of the client's resolver, skipping the cache and the retry servers
+// Failed queries are tried again on the next address up to Attempts times
+func (client *DnsClient) queryResolver(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	var err error
+
+	for i := 0; i < client.Attempts; i++ {
+
+		if err := client.RatelimitRequests(ctx); err != nil {
+			return nil, err
+		}
+
+		var r *dns.Msg
+		server := client.GetNameserver()
+
+		r, _, err = client.dns.ExchangeContext(ctx, m, server)
+		if err == nil && r.Truncated {
+			r, _, err = client.tcp.ExchangeContext(ctx, m, server)
+		}
+
+		queriesTotal.With(client.Resolver.Nameserver, queryRcode(r, err)).Inc()
+
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+	}
+
+	return nil, fmt.Errorf("%s", queryRcode(nil, err))
+}
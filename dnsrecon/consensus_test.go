@@ -0,0 +1,174 @@
+package dnsrecon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+)
+
+// reply builds a response with the records as the answer
+func reply(t *testing.T, rcode int, records ...string) *dns.Msg {
+
+	r := new(dns.Msg)
+	r.Rcode = rcode
+	for _, record := range records {
+		r.Answer = append(r.Answer, mustRR(t, record))
+	}
+
+	return r
+}
+
+func TestCompareAnswers(t *testing.T) {
+
+	sinkholes, err := ParseSinkholes(strings.NewReader("146.112.61.104 OpenDNS block page\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolvers := []string{"a", "b", "c"}
+	a1 := "example.test. 300 IN A 93.184.216.1"
+
+	tests := []struct {
+		name      string
+		qtype     uint16
+		responses []*dns.Msg
+		errs      []error
+		consensus bool
+		groups    string
+		outliers  string
+		flagged   string
+		errors    string
+	}{
+		{
+			name:  "unanimous",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, a1, "example.test. 300 IN A 93.184.216.2"),
+				reply(t, dns.RcodeSuccess, "example.test. 60 IN A 93.184.216.2", a1),
+				reply(t, dns.RcodeSuccess, a1, "example.test. 300 IN A 93.184.216.2"),
+			},
+			consensus: true,
+			groups:    "NOERROR a,b,c majority",
+		},
+		{
+			name:  "outlier",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, a1),
+				reply(t, dns.RcodeNameError),
+				reply(t, dns.RcodeSuccess, a1),
+			},
+			groups:   "NOERROR a,c majority; NXDOMAIN b",
+			outliers: "b",
+		},
+		{
+			name:  "tie",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, a1),
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN A 93.184.216.9"),
+				nil,
+			},
+			errs:   []error{nil, nil, errors.New("SERVFAIL")},
+			groups: "NOERROR a; NOERROR b",
+			errors: "c SERVFAIL",
+		},
+		{
+			name:  "errored resolver",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				nil,
+				reply(t, dns.RcodeSuccess, a1),
+				reply(t, dns.RcodeSuccess, a1),
+			},
+			errs:      []error{errors.New("i/o timeout"), nil, nil},
+			consensus: true,
+			groups:    "NOERROR b,c majority",
+			errors:    "a i/o timeout",
+		},
+		{
+			name:  "flagged addresses",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN A 10.1.2.3"),
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN A 127.0.0.1"),
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN A 146.112.61.104", "example.test. 300 IN A 10.1.2.3"),
+			},
+			groups:  "NOERROR a; NOERROR b; NOERROR c",
+			flagged: "10.1.2.3 private a,c; 127.0.0.1 bogon b; 146.112.61.104 sinkhole c",
+		},
+		{
+			name:  "names are compared without case",
+			qtype: dns.TypeA,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN CNAME WWW.Example.NET.", "www.example.net. 300 IN A 93.184.216.1"),
+				reply(t, dns.RcodeSuccess, "Example.TEST. 300 IN CNAME www.example.net.", "WWW.example.net. 300 IN A 93.184.216.1"),
+				reply(t, dns.RcodeSuccess, "example.test. 300 IN CNAME www.example.net.", "www.example.net. 300 IN A 93.184.216.1"),
+			},
+			consensus: true,
+			groups:    "NOERROR a,b,c majority",
+		},
+		{
+			name:  "TXT is compared with case",
+			qtype: dns.TypeTXT,
+			responses: []*dns.Msg{
+				reply(t, dns.RcodeSuccess, `example.test. 300 IN TXT "Token=ABC"`),
+				reply(t, dns.RcodeSuccess, `example.test. 300 IN TXT "token=abc"`),
+				reply(t, dns.RcodeSuccess, `example.test. 300 IN TXT "Token=ABC"`),
+			},
+			groups:   "NOERROR a,c majority; NOERROR b",
+			outliers: "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			errs := tt.errs
+			if errs == nil {
+				errs = make([]error, len(resolvers))
+			}
+
+			result := compareAnswers(tt.qtype, resolvers, tt.responses, errs, sinkholes)
+
+			var groups []string
+			for _, group := range result.Groups {
+				s := group.Rcode + " " + strings.Join(group.Resolvers, ",")
+				if group.Majority {
+					s += " majority"
+				}
+				groups = append(groups, s)
+			}
+
+			var flagged []string
+			for _, flag := range result.Flagged {
+				flagged = append(flagged, fmt.Sprintf("%s %s %s", flag.Address, flag.Class, strings.Join(flag.Resolvers, ",")))
+			}
+
+			var failed []string
+			for _, resolver := range resolvers {
+				if e, ok := result.Errors[resolver]; ok {
+					failed = append(failed, resolver+" "+e)
+				}
+			}
+
+			if result.Consensus != tt.consensus {
+				t.Errorf("consensus %v, want %v", result.Consensus, tt.consensus)
+			}
+			if got := strings.Join(groups, "; "); got != tt.groups {
+				t.Errorf("groups %q, want %q", got, tt.groups)
+			}
+			if got := strings.Join(result.Outliers, ","); got != tt.outliers {
+				t.Errorf("outliers %q, want %q", got, tt.outliers)
+			}
+			if got := strings.Join(flagged, "; "); got != tt.flagged {
+				t.Errorf("flagged %q, want %q", got, tt.flagged)
+			}
+			if got := strings.Join(failed, "; "); got != tt.errors {
+				t.Errorf("errors %q, want %q", got, tt.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DelegationConsistent   = "consistent"
+	DelegationInconsistent = "inconsistent"
+	DelegationError        = "error"
+
+	NameserverOK   = "ok"
+	NameserverLame = "lame"
+
+	GlueOK          = "ok"
+	GlueMissing     = "missing"
+	GlueStale       = "stale"
+	GlueNotRequired = "not_required"
+)
+
+// DelegationReport compares the delegation in the parent zone with what the zone's own nameservers return
+type DelegationReport struct {
+	Zone   string `json:"zone"`
+	Parent string `json:"parent"`
+
+	// ParentServer is the parent nameserver that returned the referral
+	ParentServer string `json:"parent_server"`
+
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	ParentNS []string `json:"parent_ns"`
+	ChildNS  []string `json:"child_ns"`
+
+	Glue        []GlueCheck       `json:"glue"`
+	Nameservers []NameserverCheck `json:"nameservers"`
+
+	// Serials lists the addresses returning each SOA serial
+	Serials map[string][]string `json:"serials"`
+
+	Issues []string `json:"issues"`
+}
+
+// GlueCheck compares the glue in the referral with the addresses the zone publishes for the nameserver
+// Glue is only required for nameservers inside the zone
+type GlueCheck struct {
+	Nameserver string   `json:"nameserver"`
+	Status     string   `json:"status"`
+	Glue       []string `json:"glue"`
+	Addresses  []string `json:"addresses"`
+}
+
+// NameserverCheck is the SOA and NS query sent to one nameserver address
+// Servers that time out, return an error or answer without the AA bit are lame
+type NameserverCheck struct {
+	Nameserver    string   `json:"nameserver"`
+	Address       string   `json:"address"`
+	Status        string   `json:"status"`
+	Reason        string   `json:"reason,omitempty"`
+	Rcode         string   `json:"rcode"`
+	Authoritative bool     `json:"authoritative"`
+	RTT           float64  `json:"rtt_ms"`
+	Serial        uint32   `json:"serial"`
+	NS            []string `json:"ns"`
+}
+
+// checkDelegation finds the zone of the domain from the root and checks every nameserver listed by the parent or the zone
+func (client *DnsClient) checkDelegation(ctx context.Context, domainData *DomainData) *DelegationReport {
+
+	report := &DelegationReport{
+		ParentNS:    make([]string, 0),
+		ChildNS:     make([]string, 0),
+		Glue:        make([]GlueCheck, 0),
+		Nameservers: make([]NameserverCheck, 0),
+		Serials:     make(map[string][]string),
+		Issues:      make([]string, 0),
+	}
+
+	zone := domainData.Data.SOA.Name
+	if zone == "" {
+		zone = domainData.Name
+	}
+	report.Zone = normalizeDomain(zone)
+
+	// The referral to the zone in the trace is its delegation
+	trace := client.Trace(ctx, zone, dns.TypeNS)
+
+	var referral, last *TraceStep
+	for i := range trace.Steps {
+		if trace.Steps[i].Referral != nil && trace.Steps[i].Name == report.Zone {
+			last = &trace.Steps[i]
+			if last.Referral.Zone == report.Zone {
+				referral = last
+			}
+		}
+	}
+
+	// Servers of the parent that also serve the zone answer instead of referring
+	if referral == nil && trace.Status == "NOERROR" {
+		referral = client.parentDelegation(ctx, trace, report.Zone)
+	}
+
+	// A name that isn't a zone apex belongs to the last zone it was referred to
+	if referral == nil {
+		referral = last
+	}
+
+	if referral == nil {
+		report.Status = DelegationError
+		report.Error = trace.Error
+		if report.Error == "" {
+			report.Error = fmt.Sprintf("no delegation found for %s, %s", report.Zone, trace.Status)
+		}
+		return report
+	}
+
+	report.Zone = referral.Referral.Zone
+	report.Parent = referral.Zone
+	report.ParentServer = referral.Server
+	report.ParentNS = append(report.ParentNS, referral.Referral.Nameservers...)
+	sort.Strings(report.ParentNS)
+
+	glue := make(map[string][]string)
+	for _, record := range referral.Referral.Glue {
+		if rdata, ok := record.Data.(AddressRdata); ok {
+			glue[record.Name] = append(glue[record.Name], rdata.Address)
+		}
+	}
+
+	addresses := client.checkNameservers(ctx, fqdn(report.Zone), report.ParentNS, glue, report)
+
+	// Nameservers only the zone lists are checked once their names are known
+	var childOnly []string
+	for _, ns := range report.ChildNS {
+		if !containsFold(report.ParentNS, ns) {
+			childOnly = append(childOnly, ns)
+		}
+	}
+	for ns, addrs := range client.checkNameservers(ctx, fqdn(report.Zone), childOnly, nil, report) {
+		addresses[ns] = addrs
+	}
+
+	sort.Slice(report.Nameservers, func(i, j int) bool {
+		if report.Nameservers[i].Nameserver != report.Nameservers[j].Nameserver {
+			return report.Nameservers[i].Nameserver < report.Nameservers[j].Nameserver
+		}
+		return report.Nameservers[i].Address < report.Nameservers[j].Address
+	})
+
+	for _, ns := range report.ParentNS {
+		report.Glue = append(report.Glue, checkGlue(report.Zone, ns, glue[ns], addresses[ns]))
+	}
+
+	report.Issues = delegationIssues(report)
+
+	report.Status = DelegationConsistent
+	if len(report.Issues) > 0 {
+		report.Status = DelegationInconsistent
+	}
+
+	if ctx.Err() != nil {
+		report.Status = DelegationError
+		report.Error = contextError(ctx).Error()
+	}
+
+	return report
+}
+
+// checkNameservers queries every address of the nameservers, the glue and the addresses the nameservers publish
+// It adds the checks and the NS records returned to the report and returns the published addresses
+func (client *DnsClient) checkNameservers(ctx context.Context, zone string, nameservers []string, glue map[string][]string, report *DelegationReport) map[string][]string {
+
+	addresses := make(map[string][]string)
+	checks := make([][]NameserverCheck, len(nameservers))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, ns := range nameservers {
+
+		wg.Add(1)
+
+		go func(i int, ns string) {
+			defer wg.Done()
+
+			published := client.lookupAddresses(ctx, ns)
+
+			mu.Lock()
+			addresses[ns] = published
+			mu.Unlock()
+
+			targets := append([]string{}, glue[ns]...)
+			for _, address := range published {
+				if !containsFold(targets, address) {
+					targets = append(targets, address)
+				}
+			}
+
+			if len(targets) == 0 {
+				checks[i] = append(checks[i], NameserverCheck{
+					Nameserver: ns,
+					Status:     NameserverLame,
+					Reason:     "no addresses",
+					NS:         make([]string, 0),
+				})
+				return
+			}
+
+			for _, address := range targets {
+				checks[i] = append(checks[i], client.checkNameserver(ctx, zone, ns, address))
+			}
+		}(i, ns)
+	}
+
+	wg.Wait()
+
+	for _, nsChecks := range checks {
+		for _, check := range nsChecks {
+
+			report.Nameservers = append(report.Nameservers, check)
+
+			if check.Status != NameserverOK {
+				continue
+			}
+
+			// Nameservers sharing an address are counted once
+			serial := strconv.FormatUint(uint64(check.Serial), 10)
+			if !containsFold(report.Serials[serial], check.Address) {
+				report.Serials[serial] = append(report.Serials[serial], check.Address)
+			}
+
+			for _, ns := range check.NS {
+				if !containsFold(report.ChildNS, ns) {
+					report.ChildNS = append(report.ChildNS, ns)
+				}
+			}
+		}
+	}
+
+	sort.Strings(report.ChildNS)
+	for _, serialAddresses := range report.Serials {
+		sort.Strings(serialAddresses)
+	}
+
+	return addresses
+}
+
+// checkNameserver asks one address for the SOA and NS records of the zone
+func (client *DnsClient) checkNameserver(ctx context.Context, zone string, ns string, address string) NameserverCheck {
+
+	check := NameserverCheck{
+		Nameserver: ns,
+		Address:    address,
+		Status:     NameserverLame,
+		NS:         make([]string, 0),
+	}
+
+	server := client.authoritativeAddress(net.ParseIP(address))
+
+	r, rtt, err := client.queryAuthoritative(ctx, server, zone, dns.TypeSOA)
+
+	check.Rcode = queryRcode(r, err)
+	check.RTT = float64(rtt) / float64(time.Millisecond)
+
+	switch {
+	case err != nil:
+		check.Reason = strings.ToLower(check.Rcode)
+		return check
+	case r.Rcode != dns.RcodeSuccess:
+		check.Reason = fmt.Sprintf("%s response", check.Rcode)
+		return check
+	case !r.Authoritative:
+		check.Reason = "not authoritative"
+		return check
+	}
+
+	check.Authoritative = true
+
+	// Zero is a valid serial so the record itself has to be found
+	foundSOA := false
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, zone) {
+			check.Serial = soa.Serial
+			foundSOA = true
+		}
+	}
+	if !foundSOA {
+		check.Reason = "no SOA record"
+		return check
+	}
+
+	r, _, err = client.queryAuthoritative(ctx, server, zone, dns.TypeNS)
+	if err != nil || r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+		check.Reason = fmt.Sprintf("NS query returned %s", queryRcode(r, err))
+		return check
+	}
+
+	for _, rr := range r.Answer {
+		if nsRR, ok := rr.(*dns.NS); ok && strings.EqualFold(nsRR.Hdr.Name, zone) {
+			check.NS = append(check.NS, normalizeDomain(nsRR.Ns))
+		}
+	}
+	sort.Strings(check.NS)
+
+	check.Status = NameserverOK
+
+	return check
+}
+
+// parentDelegation asks the nameservers of the last zone the trace was referred to for the NS records of the zone
+// The answer, or a referral, takes the place of the referral the trace didn't get
+func (client *DnsClient) parentDelegation(ctx context.Context, trace *Trace, zone string) *TraceStep {
+
+	// Without a referral the parent is the root
+	parent := "."
+	var servers []authServer
+	for _, hint := range client.RootHints {
+		servers = append(servers, authServer{name: hint.Name, address: hint.Address})
+	}
+
+	for _, step := range trace.Steps {
+
+		if step.Referral == nil {
+			continue
+		}
+
+		parent = step.Referral.Zone
+		servers = nil
+
+		for _, record := range step.Referral.Glue {
+			if rdata, ok := record.Data.(AddressRdata); ok {
+				servers = append(servers, authServer{name: record.Name, address: client.authoritativeAddress(net.ParseIP(rdata.Address))})
+			}
+		}
+		for ns, addresses := range step.Referral.Resolved {
+			for _, address := range addresses {
+				servers = append(servers, authServer{name: ns, address: client.authoritativeAddress(net.ParseIP(address))})
+			}
+		}
+	}
+
+	if parent == zone {
+		return nil
+	}
+
+	for _, server := range servers {
+
+		r, rtt, err := client.queryAuthoritative(ctx, server.address, fqdn(zone), dns.TypeNS)
+		if err != nil || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		referral := &Referral{
+			Zone:        zone,
+			Nameservers: make([]string, 0),
+			Glue:        make([]Record, 0),
+		}
+
+		for _, rr := range append(r.Answer, r.Ns...) {
+			if ns, ok := rr.(*dns.NS); ok && fqdn(ns.Hdr.Name) == fqdn(zone) && !containsFold(referral.Nameservers, normalizeDomain(ns.Ns)) {
+				referral.Nameservers = append(referral.Nameservers, normalizeDomain(ns.Ns))
+			}
+		}
+		if len(referral.Nameservers) == 0 {
+			continue
+		}
+
+		for _, rr := range r.Extra {
+			switch rr.(type) {
+			case *dns.A, *dns.AAAA:
+				if containsFold(referral.Nameservers, normalizeDomain(rr.Header().Name)) {
+					referral.Glue = append(referral.Glue, newRecord(rr))
+				}
+			}
+		}
+
+		return &TraceStep{
+			Zone:          zoneName(parent),
+			Name:          zone,
+			Server:        normalizeDomain(server.name),
+			Address:       server.address,
+			Rcode:         dns.RcodeToString[r.Rcode],
+			RTT:           float64(rtt) / float64(time.Millisecond),
+			Authoritative: r.Authoritative,
+			Answer:        make([]Record, 0),
+			Referral:      referral,
+		}
+	}
+
+	return nil
+}
+
+// lookupAddresses resolves the A and AAAA records of a nameserver from the root
+func (client *DnsClient) lookupAddresses(ctx context.Context, name string) []string {
+
+	addresses := make([]string, 0)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+
+		it := &iteration{client: client}
+
+		answer, _, err := it.resolve(ctx, fqdn(name), qtype, 0, nil)
+		if err != nil {
+			continue
+		}
+
+		for _, rr := range answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				addresses = append(addresses, v.A.String())
+			case *dns.AAAA:
+				addresses = append(addresses, v.AAAA.String())
+			}
+		}
+	}
+
+	return addresses
+}
+
+// checkGlue compares the glue of a nameserver with its published addresses
+func checkGlue(zone string, ns string, glue []string, addresses []string) GlueCheck {
+
+	check := GlueCheck{
+		Nameserver: ns,
+		Status:     GlueOK,
+		Glue:       append(make([]string, 0), glue...),
+		Addresses:  append(make([]string, 0), addresses...),
+	}
+
+	sort.Strings(check.Glue)
+	sort.Strings(check.Addresses)
+
+	switch {
+	case len(glue) == 0 && dns.IsSubDomain(fqdn(zone), fqdn(ns)):
+		check.Status = GlueMissing
+	case len(glue) == 0:
+		check.Status = GlueNotRequired
+	case strings.Join(check.Glue, " ") != strings.Join(check.Addresses, " "):
+		check.Status = GlueStale
+	}
+
+	return check
+}
+
+// delegationIssues describes every difference and lame server in the report
+func delegationIssues(report *DelegationReport) []string {
+
+	issues := make([]string, 0)
+
+	for _, ns := range report.ParentNS {
+		if !containsFold(report.ChildNS, ns) && len(report.ChildNS) > 0 {
+			issues = append(issues, fmt.Sprintf("%s is delegated to by %s but not listed by the zone", ns, zoneName(fqdn(report.Parent))))
+		}
+	}
+	for _, ns := range report.ChildNS {
+		if !containsFold(report.ParentNS, ns) {
+			issues = append(issues, fmt.Sprintf("%s is listed by the zone but not delegated to by %s", ns, zoneName(fqdn(report.Parent))))
+		}
+	}
+
+	for _, glue := range report.Glue {
+		switch glue.Status {
+		case GlueMissing:
+			issues = append(issues, fmt.Sprintf("%s has no glue", glue.Nameserver))
+		case GlueStale:
+			issues = append(issues, fmt.Sprintf("%s glue %s differs from the published addresses %s", glue.Nameserver, strings.Join(glue.Glue, ","), strings.Join(glue.Addresses, ",")))
+		}
+	}
+
+	for _, check := range report.Nameservers {
+
+		server := strings.TrimSpace(check.Nameserver + " " + check.Address)
+
+		if check.Status == NameserverLame {
+			issues = append(issues, fmt.Sprintf("%s is lame: %s", server, check.Reason))
+			continue
+		}
+		if strings.Join(check.NS, " ") != strings.Join(report.ChildNS, " ") {
+			issues = append(issues, fmt.Sprintf("%s returns a different NS set: %s", server, strings.Join(check.NS, ",")))
+		}
+	}
+
+	if len(report.Serials) > 1 {
+		serials := make([]string, 0, len(report.Serials))
+		for serial, addresses := range report.Serials {
+			serials = append(serials, fmt.Sprintf("%s on %s", serial, strings.Join(addresses, ",")))
+		}
+		sort.Strings(serials)
+		issues = append(issues, fmt.Sprintf("SOA serials differ: %s", strings.Join(serials, "; ")))
+	}
+
+	return issues
+}
@@ -0,0 +1,86 @@
+package dnsrecon
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newDelegationClient serves the root at 127.0.0.1, test. and shared.test. at 127.0.0.2 and example.test. at 127.0.0.3
+// Both zones use zero as their SOA serial
+func newDelegationClient(t *testing.T) *DnsClient {
+
+	root := serveDNS(t, "127.0.0.1:0", authoritative(t, []string{"."},
+		"test. 300 IN NS ns.nic.test.",
+		"ns.nic.test. 300 IN A 127.0.0.2",
+	))
+
+	_, port, _ := net.SplitHostPort(root)
+
+	serveDNS(t, "127.0.0.2:"+port, authoritative(t, []string{"test.", "shared.test."},
+		"ns.nic.test. 300 IN A 127.0.0.2",
+		"example.test. 300 IN NS ns1.example.test.",
+		"ns1.example.test. 300 IN A 127.0.0.3",
+		"shared.test. 300 IN SOA ns.nic.test. admin.shared.test. 0 3600 600 86400 300",
+		"shared.test. 300 IN NS ns.nic.test.",
+	))
+
+	serveDNS(t, "127.0.0.3:"+port, authoritative(t, []string{"example.test."},
+		"example.test. 300 IN SOA ns1.example.test. admin.example.test. 0 3600 600 86400 300",
+		"example.test. 300 IN NS ns1.example.test.",
+		"ns1.example.test. 300 IN A 127.0.0.3",
+	))
+
+	hints, err := ParseRootHints([]string{"ns.root.test. " + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newLocalClient(t, root)
+	client.RootHints = hints
+	client.AuthoritativePort = port
+
+	return client
+}
+
+func TestCheckDelegation(t *testing.T) {
+
+	tests := []struct {
+		zone         string
+		parentServer string
+		glue         string
+	}{
+		{zone: "example.test", parentServer: "ns.nic.test", glue: GlueOK},
+		// The servers of test. answer for shared.test. instead of referring to it
+		{zone: "shared.test", parentServer: "ns.nic.test", glue: GlueNotRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+
+			domainData := &DomainData{Name: tt.zone}
+			domainData.Data.SOA.Name = tt.zone
+
+			report := newDelegationClient(t).checkDelegation(context.Background(), domainData)
+
+			if report.Status != DelegationConsistent {
+				t.Fatalf("status %s (%s), issues %v", report.Status, report.Error, report.Issues)
+			}
+			if report.Zone != tt.zone || report.Parent != "test" || report.ParentServer != tt.parentServer {
+				t.Errorf("zone %s, parent %s from %s", report.Zone, report.Parent, report.ParentServer)
+			}
+			if len(report.Glue) != 1 || report.Glue[0].Status != tt.glue {
+				t.Errorf("glue %+v, want %s", report.Glue, tt.glue)
+			}
+
+			// A zero serial is a serial, not a missing SOA record
+			if len(report.Nameservers) != 1 || report.Nameservers[0].Status != NameserverOK {
+				t.Errorf("nameservers %+v", report.Nameservers)
+			}
+			if servers := report.Serials["0"]; len(servers) != 1 || !strings.HasPrefix(servers[0], "127.0.0.") {
+				t.Errorf("serials %v", report.Serials)
+			}
+		})
+	}
+}
@@ -1,10 +1,19 @@
 package dnsrecon
 
 import (
+	"context"
+	"github.com/miekg/dns"
 	"time"
 )
 
-func (client *DnsClient) GetDnsData(targetDomain string) *DomainData {
+// GetDnsData collects the dns records for targetDomain
+// Outstanding lookups are abandoned once ctx is cancelled or its deadline passes
+func (client *DnsClient) GetDnsData(ctx context.Context, targetDomain string) *DomainData {
+	return client.GetDnsDataWithOptions(ctx, targetDomain, LookupOptions{})
+}
+
+// GetDnsDataWithOptions collects the record sets selected in opts for targetDomain
+func (client *DnsClient) GetDnsDataWithOptions(ctx context.Context, targetDomain string, opts LookupOptions) *DomainData {
 
 	soaDataChan := make(chan soaResponse, 1)
 	aDataChan := make(chan aResponse, 1)
@@ -13,19 +22,49 @@ func (client *DnsClient) GetDnsData(targetDomain string) *DomainData {
 	mxDataChan := make(chan mxResponse, 1)
 	txtDataChan := make(chan txtResponse, 1)
 	cnameDataChan := make(chan cnameResponse, 1)
+	caaDataChan := make(chan caaResponse, 1)
+	srvDataChan := make(chan srvResponse, 1)
+	naptrDataChan := make(chan naptrResponse, 1)
+	sshfpDataChan := make(chan sshfpResponse, 1)
+	tlsaDataChan := make(chan tlsaResponse, 1)
+	httpsDataChan := make(chan svcbResponse, 1)
+	svcbDataChan := make(chan svcbResponse, 1)
+	locDataChan := make(chan locResponse, 1)
+	hinfoDataChan := make(chan hinfoResponse, 1)
+	uriDataChan := make(chan uriResponse, 1)
 
 	domainData := NewDomainData()
 	domainData.Name = targetDomain
 	domainData.Timestamp = time.Now().UTC()
 	domainData.Status = "NOERROR"
 
+	if opts.Provenance {
+		var recorder *provenanceRecorder
+		ctx, recorder = withProvenance(ctx)
+		defer func() {
+			domainData.Provenance = recorder.sets()
+		}()
+	}
+
 	// Check the SOA, A and AAAA records before returned domainData with an error
 	// Some misconfigured domains return no SOA record but return A/AAAA records
 	// Unless the dns request failed causing no SOA record to be returned
 	// All valid domains have an SOA record
-	go client.getSOA(targetDomain, soaDataChan, domainData)
-	go client.getA(targetDomain, aDataChan, domainData)
-	go client.getAAAA(targetDomain, aaaaDataChan, domainData)
+	if opts.lookup(dns.TypeSOA) {
+		go client.getSOA(ctx, targetDomain, soaDataChan, domainData)
+	} else {
+		close(soaDataChan)
+	}
+	if opts.lookup(dns.TypeA) {
+		go client.getA(ctx, targetDomain, aDataChan, domainData)
+	} else {
+		close(aDataChan)
+	}
+	if opts.lookup(dns.TypeAAAA) {
+		go client.getAAAA(ctx, targetDomain, aaaaDataChan, domainData)
+	} else {
+		close(aaaaDataChan)
+	}
 
 	// Check the SOA record first as only valid domains have an SOA
 	for soaResponse := range soaDataChan {
@@ -52,8 +91,17 @@ func (client *DnsClient) GetDnsData(targetDomain string) *DomainData {
 		domainData.Data.AAAA = aaaaResponse.AAAA
 	}
 
+	// Don't start the remaining lookups if the caller has gone away
+	if ctx.Err() != nil {
+		domainData.Status = contextError(ctx).Error()
+		return domainData
+	}
+
+	// The SOA, A and AAAA checks are skipped when none of them were requested
+	apexLookups := opts.lookup(dns.TypeSOA) || opts.lookup(dns.TypeA) || opts.lookup(dns.TypeAAAA)
+
 	// Check domainData for errors
-	if len(domainData.Data.A) == 0 && len(domainData.Data.AAAA) == 0 && len(domainData.Data.SOA.Nameserver) == 0 {
+	if apexLookups && len(domainData.Data.A) == 0 && len(domainData.Data.AAAA) == 0 && len(domainData.Data.SOA.Nameserver) == 0 {
 
 		_, soaErr := domainData.Errors["soa"]
 		if soaErr {
@@ -77,10 +125,76 @@ func (client *DnsClient) GetDnsData(targetDomain string) *DomainData {
 	}
 
 	// Do lookups for other reocords if SOA, A or AAAA lookups found data
-	go client.getNS(targetDomain, nsDataChan, domainData)
-	go client.getMX(targetDomain, mxDataChan, domainData)
-	go client.getTXT(targetDomain, txtDataChan, domainData)
-	go client.getCNAME(targetDomain, cnameDataChan, domainData)
+	if opts.lookup(dns.TypeNS) {
+		go client.getNS(ctx, targetDomain, nsDataChan, domainData)
+	} else {
+		close(nsDataChan)
+	}
+	if opts.lookup(dns.TypeMX) {
+		go client.getMX(ctx, targetDomain, mxDataChan, domainData)
+	} else {
+		close(mxDataChan)
+	}
+	if opts.lookup(dns.TypeTXT) {
+		go client.getTXT(ctx, targetDomain, txtDataChan, domainData)
+	} else {
+		close(txtDataChan)
+	}
+	if opts.lookup(dns.TypeCNAME) {
+		go client.getCNAME(ctx, targetDomain, cnameDataChan, domainData)
+	} else {
+		close(cnameDataChan)
+	}
+	if opts.lookup(dns.TypeCAA) {
+		go client.getCAA(ctx, targetDomain, caaDataChan, domainData)
+	} else {
+		close(caaDataChan)
+	}
+	if opts.lookup(dns.TypeSRV) {
+		go client.getSRV(ctx, targetDomain, srvDataChan, domainData)
+	} else {
+		close(srvDataChan)
+	}
+	if opts.lookup(dns.TypeNAPTR) {
+		go client.getNAPTR(ctx, targetDomain, naptrDataChan, domainData)
+	} else {
+		close(naptrDataChan)
+	}
+	if opts.lookup(dns.TypeSSHFP) {
+		go client.getSSHFP(ctx, targetDomain, sshfpDataChan, domainData)
+	} else {
+		close(sshfpDataChan)
+	}
+	if opts.lookup(dns.TypeTLSA) {
+		go client.getTLSA(ctx, targetDomain, tlsaDataChan, domainData)
+	} else {
+		close(tlsaDataChan)
+	}
+	if opts.lookup(dns.TypeHTTPS) {
+		go client.getSVCB(ctx, targetDomain, dns.TypeHTTPS, httpsDataChan, domainData)
+	} else {
+		close(httpsDataChan)
+	}
+	if opts.lookup(dns.TypeSVCB) {
+		go client.getSVCB(ctx, targetDomain, dns.TypeSVCB, svcbDataChan, domainData)
+	} else {
+		close(svcbDataChan)
+	}
+	if opts.lookup(dns.TypeLOC) {
+		go client.getLOC(ctx, targetDomain, locDataChan, domainData)
+	} else {
+		close(locDataChan)
+	}
+	if opts.lookup(dns.TypeHINFO) {
+		go client.getHINFO(ctx, targetDomain, hinfoDataChan, domainData)
+	} else {
+		close(hinfoDataChan)
+	}
+	if opts.lookup(dns.TypeURI) {
+		go client.getURI(ctx, targetDomain, uriDataChan, domainData)
+	} else {
+		close(uriDataChan)
+	}
 
 	for nsResponse := range nsDataChan {
 
@@ -114,6 +228,114 @@ func (client *DnsClient) GetDnsData(targetDomain string) *DomainData {
 		domainData.Data.CName = cnameResponse.CName
 	}
 
+	for caaResponse := range caaDataChan {
+
+		if caaResponse.Error != nil {
+			domainData.Errors["caa"] = caaResponse.Error.Error()
+		}
+		domainData.Data.CAA = caaResponse.CAA
+	}
+
+	for srvResponse := range srvDataChan {
+
+		if srvResponse.Error != nil {
+			domainData.Errors["srv"] = srvResponse.Error.Error()
+		}
+		domainData.Data.SRV = srvResponse.SRV
+	}
+
+	for naptrResponse := range naptrDataChan {
+
+		if naptrResponse.Error != nil {
+			domainData.Errors["naptr"] = naptrResponse.Error.Error()
+		}
+		domainData.Data.NAPTR = naptrResponse.NAPTR
+	}
+
+	for sshfpResponse := range sshfpDataChan {
+
+		if sshfpResponse.Error != nil {
+			domainData.Errors["sshfp"] = sshfpResponse.Error.Error()
+		}
+		domainData.Data.SSHFP = sshfpResponse.SSHFP
+	}
+
+	for tlsaResponse := range tlsaDataChan {
+
+		if tlsaResponse.Error != nil {
+			domainData.Errors["tlsa"] = tlsaResponse.Error.Error()
+		}
+		domainData.Data.TLSA = tlsaResponse.TLSA
+	}
+
+	for httpsResponse := range httpsDataChan {
+
+		if httpsResponse.Error != nil {
+			domainData.Errors["https"] = httpsResponse.Error.Error()
+		}
+		domainData.Data.HTTPS = httpsResponse.SVCB
+	}
+
+	for svcbResponse := range svcbDataChan {
+
+		if svcbResponse.Error != nil {
+			domainData.Errors["svcb"] = svcbResponse.Error.Error()
+		}
+		domainData.Data.SVCB = svcbResponse.SVCB
+	}
+
+	for locResponse := range locDataChan {
+
+		if locResponse.Error != nil {
+			domainData.Errors["loc"] = locResponse.Error.Error()
+		}
+		domainData.Data.LOC = locResponse.LOC
+	}
+
+	for hinfoResponse := range hinfoDataChan {
+
+		if hinfoResponse.Error != nil {
+			domainData.Errors["hinfo"] = hinfoResponse.Error.Error()
+		}
+		domainData.Data.HINFO = hinfoResponse.HINFO
+	}
+
+	for uriResponse := range uriDataChan {
+
+		if uriResponse.Error != nil {
+			domainData.Errors["uri"] = uriResponse.Error.Error()
+		}
+		domainData.Data.URI = uriResponse.URI
+	}
+
+	if opts.DNSSEC && ctx.Err() == nil {
+		domainData.DNSSEC = client.validateDnssec(ctx, domainData)
+	}
+
+	if opts.ZoneTransfer && ctx.Err() == nil {
+		domainData.ZoneTransfers = client.getZoneTransfers(ctx, domainData, opts.IXFRSerial)
+	}
+
+	if (opts.SPF || opts.Email) && ctx.Err() == nil {
+		domainData.SPF = client.analyzeSPF(ctx, targetDomain)
+	}
+
+	if opts.Email && ctx.Err() == nil {
+		domainData.Email = client.checkEmailSecurity(ctx, targetDomain, domainData.SPF)
+	}
+
+	if opts.Delegation && ctx.Err() == nil {
+		domainData.Delegation = client.checkDelegation(ctx, domainData)
+	}
+
+	if opts.ReverseDNS && ctx.Err() == nil {
+		domainData.ReverseDNS = client.reverseLookups(ctx, domainData)
+	}
+
+	if ctx.Err() != nil {
+		domainData.Status = contextError(ctx).Error()
+	}
+
 	return domainData
 
 }
@@ -157,13 +379,49 @@ type DomainData struct {
 		A          []string                 `json:"a"`
 		AAAA       []string                 `json:"aaaa"`
 		CNamePaths map[string][][]string    `json:"cname_paths"`
+
+		// The record sets below are only resolved when they are requested in LookupOptions.Types
+		CAA   []CAARdata   `json:"caa,omitempty"`
+		SRV   []SRVData    `json:"srv,omitempty"`
+		NAPTR []NAPTRRdata `json:"naptr,omitempty"`
+		SSHFP []SSHFPRdata `json:"sshfp,omitempty"`
+		TLSA  []TLSAData   `json:"tlsa,omitempty"`
+		HTTPS []SVCBData   `json:"https,omitempty"`
+		SVCB  []SVCBData   `json:"svcb,omitempty"`
+		LOC   []LOCRdata   `json:"loc,omitempty"`
+		HINFO []HINFORdata `json:"hinfo,omitempty"`
+		URI   []URIRdata   `json:"uri,omitempty"`
 	} `json:"data"`
 
+	// DNSSEC is only set when validation was requested
+	DNSSEC *DnssecData `json:"dnssec,omitempty"`
+
+	// ZoneTransfers are only set when zone transfers were requested
+	ZoneTransfers []ZoneTransfer `json:"zone_transfers,omitempty"`
+
+	// SPF is only set when the SPF analysis was requested
+	SPF *SPFData `json:"spf,omitempty"`
+
+	// Email is only set when the email security check was requested
+	Email *EmailSecurity `json:"email,omitempty"`
+
+	// Delegation is only set when the delegation check was requested
+	Delegation *DelegationReport `json:"delegation,omitempty"`
+
+	// ReverseDNS is only set when reverse lookups were requested, it holds every address found keyed by address
+	ReverseDNS map[string]ReverseDNS `json:"reverse_dns,omitempty"`
+
+	// Provenance is only set when requested, the queries are keyed by record type like the record sets
+	Provenance map[string][]Provenance `json:"provenance,omitempty"`
+
 	Timestamp time.Time `json:"timestamp"`
 
 	Status string `json:"status"`
 
 	Errors map[string]string `json:"errors"`
+
+	// raw holds the unflattened records for the v2 schema
+	raw *rawRecords
 }
 
 // soaData, MXData, NSData and IpSet are used in the response from various goroutines
@@ -184,6 +442,9 @@ type NSData struct {
 type IpSet struct {
 	A    []string `json:"a"`
 	AAAA []string `json:"aaaa"`
+
+	// Reverse is only set when reverse lookups were requested, it's keyed by address
+	Reverse map[string]ReverseDNS `json:"reverse,omitempty"`
 }
 
 func NewDomainData() *DomainData {
@@ -201,7 +462,18 @@ func NewDomainData() *DomainData {
 	domainData.Data.A = make([]string, 0)
 	domainData.Data.AAAA = make([]string, 0)
 	domainData.Data.CNamePaths = make(map[string][][]string, 0)
+	domainData.Data.CAA = make([]CAARdata, 0)
+	domainData.Data.SRV = make([]SRVData, 0)
+	domainData.Data.NAPTR = make([]NAPTRRdata, 0)
+	domainData.Data.SSHFP = make([]SSHFPRdata, 0)
+	domainData.Data.TLSA = make([]TLSAData, 0)
+	domainData.Data.HTTPS = make([]SVCBData, 0)
+	domainData.Data.SVCB = make([]SVCBData, 0)
+	domainData.Data.LOC = make([]LOCRdata, 0)
+	domainData.Data.HINFO = make([]HINFORdata, 0)
+	domainData.Data.URI = make([]URIRdata, 0)
 	domainData.Errors = make(map[string]string, 0)
+	domainData.raw = newRawRecords()
 
 	return &domainData
 }
@@ -0,0 +1,598 @@
+package dnsrecon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DnssecSecure        = "secure"
+	DnssecInsecure      = "insecure"
+	DnssecBogus         = "bogus"
+	DnssecIndeterminate = "indeterminate"
+)
+
+// DefaultTrustAnchors are the DS records of the root zone KSKs
+var DefaultTrustAnchors = []string{
+	". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 86400 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// DnssecData reports the chain of trust from the root to the zone and the status of each RRset
+type DnssecData struct {
+	Status string        `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	Chain  []DnssecZone  `json:"chain"`
+	RRsets []DnssecRRset `json:"rrsets"`
+}
+
+// DnssecZone is one link in the chain of trust
+type DnssecZone struct {
+	Zone   string        `json:"zone"`
+	Status string        `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	DS     []DSRdata     `json:"ds"`
+	DNSKEY []DNSKEYRdata `json:"dnskey"`
+}
+
+type DnssecRRset struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ParseTrustAnchors parses DS records in zone file format
+func ParseTrustAnchors(anchors []string) ([]*dns.DS, error) {
+
+	var dsSet []*dns.DS
+
+	for _, anchor := range anchors {
+
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, fmt.Errorf("trust anchor %q: %v", anchor, err)
+		}
+
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor %q is not a DS record", anchor)
+		}
+
+		dsSet = append(dsSet, ds)
+	}
+
+	return dsSet, nil
+}
+
+// queryDnssec sets the DO bit to get the RRSIGs and the CD bit so bogus data is returned for validation
+func (client *DnsClient) queryDnssec(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+	m.CheckingDisabled = true
+	m.SetEdns0(4096, true)
+
+	r, err := client.DnsResolver(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, errors.New(ErrNoData)
+	}
+
+	return r, nil
+}
+
+// validateDnssec validates the chain of trust to the zone of the target domain and every RRset in domainData
+func (client *DnsClient) validateDnssec(ctx context.Context, domainData *DomainData) *DnssecData {
+
+	var data DnssecData
+
+	zone := domainData.Data.SOA.Name
+	if zone == "" {
+		zone = domainData.Name
+	}
+
+	keys, signer := client.validateChain(ctx, fqdn(zone), &data)
+
+	data.RRsets = client.validateRRsets(ctx, domainData, &data, signer, keys)
+
+	return &data
+}
+
+// validateChain walks from the trust anchors to the zone, it returns the zone keys when the chain is secure
+func (client *DnsClient) validateChain(ctx context.Context, zone string, data *DnssecData) ([]*dns.DNSKEY, string) {
+
+	data.Chain = make([]DnssecZone, 0)
+
+	if len(client.TrustAnchors) == 0 {
+		data.Status = DnssecIndeterminate
+		data.Reason = "no trust anchors configured"
+		return nil, ""
+	}
+
+	signer := "."
+	keys, link := client.validateKeys(ctx, signer, client.TrustAnchors)
+	data.Chain = append(data.Chain, link)
+
+	if link.Status != DnssecSecure {
+		data.Status = link.Status
+		data.Reason = fmt.Sprintf("%s: %s", link.Zone, link.Reason)
+		return nil, ""
+	}
+
+	labels := dns.SplitDomainName(zone)
+
+	for i := len(labels) - 1; i >= 0; i-- {
+
+		name := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		dsSet, link, cut := client.fetchDS(ctx, name, signer, keys)
+
+		// Names below a zone cut that aren't delegations are signed by the same keys
+		if !cut && link.Status == DnssecSecure {
+			continue
+		}
+
+		if link.Status == DnssecSecure {
+			keys, link = client.validateKeys(ctx, name, dsSet)
+			signer = name
+		}
+
+		data.Chain = append(data.Chain, link)
+
+		if link.Status != DnssecSecure {
+			data.Status = link.Status
+			data.Reason = fmt.Sprintf("%s: %s", link.Zone, link.Reason)
+			return nil, ""
+		}
+	}
+
+	data.Status = DnssecSecure
+
+	return keys, signer
+}
+
+// validateKeys fetches the DNSKEY RRset of zone and checks it is signed by a key matching the DS set
+func (client *DnsClient) validateKeys(ctx context.Context, zone string, dsSet []*dns.DS) ([]*dns.DNSKEY, DnssecZone) {
+
+	link := DnssecZone{
+		Zone:   normalizeDomain(zone),
+		DS:     make([]DSRdata, 0),
+		DNSKEY: make([]DNSKEYRdata, 0),
+	}
+	if link.Zone == "" {
+		link.Zone = "."
+	}
+
+	supported := false
+	for _, ds := range dsSet {
+		link.DS = append(link.DS, newDSRdata(ds))
+		if supportedAlgorithm(ds.Algorithm) {
+			supported = true
+		}
+	}
+
+	// RFC 4035 5.2, zones signed only with unknown algorithms are treated as unsigned
+	if !supported {
+		link.Status = DnssecInsecure
+		link.Reason = "unsupported DS algorithm"
+		return nil, link
+	}
+
+	r, err := client.queryDnssec(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		link.Status = DnssecIndeterminate
+		link.Reason = fmt.Sprintf("DNSKEY lookup failed: %v", err)
+		return nil, link
+	}
+
+	var keys []*dns.DNSKEY
+	for _, rr := range r.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok && strings.EqualFold(key.Header().Name, zone) {
+			keys = append(keys, key)
+			link.DNSKEY = append(link.DNSKEY, newDNSKEYRdata(key))
+		}
+	}
+
+	if len(keys) == 0 {
+		link.Status = DnssecBogus
+		link.Reason = "no DNSKEY records"
+		return nil, link
+	}
+
+	var trusted []*dns.DNSKEY
+	tagMatch := false
+
+	for _, ds := range dsSet {
+		for _, key := range keys {
+
+			if key.KeyTag() != ds.KeyTag {
+				continue
+			}
+			tagMatch = true
+
+			if key.Algorithm != ds.Algorithm {
+				continue
+			}
+
+			digest := key.ToDS(ds.DigestType)
+			if digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+				trusted = append(trusted, key)
+			}
+		}
+	}
+
+	if len(trusted) == 0 {
+		link.Status = DnssecBogus
+		if tagMatch {
+			link.Reason = "DS and DNSKEY algorithm or digest mismatch"
+		} else {
+			link.Reason = "no DNSKEY matches the DS records"
+		}
+		return nil, link
+	}
+
+	link.Status, link.Reason = verifyRRset(r.Answer, zone, dns.TypeDNSKEY, zone, trusted)
+	if link.Status != DnssecSecure {
+		link.Reason = "DNSKEY " + link.Reason
+		return nil, link
+	}
+
+	return keys, link
+}
+
+// supportedAlgorithm checks whether RRSIG.Verify can validate signatures made with the algorithm
+func supportedAlgorithm(algorithm uint8) bool {
+
+	switch algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512, dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		return true
+	}
+
+	return false
+}
+
+// fetchDS gets the DS set of name from the parent zone
+// cut is false when name is inside the parent zone rather than a delegation
+func (client *DnsClient) fetchDS(ctx context.Context, name string, signer string, keys []*dns.DNSKEY) ([]*dns.DS, DnssecZone, bool) {
+
+	link := DnssecZone{
+		Zone:   normalizeDomain(name),
+		DS:     make([]DSRdata, 0),
+		DNSKEY: make([]DNSKEYRdata, 0),
+	}
+
+	r, err := client.queryDnssec(ctx, name, dns.TypeDS)
+	if err != nil {
+		link.Status = DnssecIndeterminate
+		link.Reason = fmt.Sprintf("DS lookup failed: %v", err)
+		return nil, link, true
+	}
+
+	var dsSet []*dns.DS
+	for _, rr := range r.Answer {
+		if ds, ok := rr.(*dns.DS); ok && strings.EqualFold(ds.Header().Name, name) {
+			dsSet = append(dsSet, ds)
+		}
+	}
+
+	if len(dsSet) > 0 {
+		link.Status, link.Reason = verifyRRset(r.Answer, name, dns.TypeDS, signer, keys)
+		if link.Status != DnssecSecure {
+			link.Reason = "DS " + link.Reason
+		}
+		return dsSet, link, true
+	}
+
+	// No DS, the NSEC or NSEC3 records must prove whether name is an unsigned delegation
+	status, reason, cut := denyDS(r, name, signer, keys)
+	link.Status = status
+	link.Reason = reason
+
+	return nil, link, cut
+}
+
+// denyDS checks the authenticated denial of existence for a DS query
+func denyDS(r *dns.Msg, name string, signer string, keys []*dns.DNSKEY) (string, string, bool) {
+
+	for _, rr := range r.Ns {
+
+		switch v := rr.(type) {
+		case *dns.NSEC:
+			if !strings.EqualFold(v.Header().Name, name) && !nsecCovers(v, name) {
+				continue
+			}
+
+			if status, reason := verifyRRset(r.Ns, v.Header().Name, dns.TypeNSEC, signer, keys); status != DnssecSecure {
+				return status, "NSEC " + reason, true
+			}
+
+			// An empty non-terminal is covered rather than matched
+			if !strings.EqualFold(v.Header().Name, name) {
+				return DnssecSecure, "", false
+			}
+
+			if hasType(v.TypeBitMap, dns.TypeNS) && !hasType(v.TypeBitMap, dns.TypeSOA) {
+				return DnssecInsecure, "unsigned delegation", true
+			}
+			return DnssecSecure, "", false
+
+		case *dns.NSEC3:
+			matched := v.Match(name)
+			optOut := v.Flags&1 == 1 && v.Cover(name)
+
+			if !matched && !optOut {
+				continue
+			}
+
+			if status, reason := verifyRRset(r.Ns, v.Header().Name, dns.TypeNSEC3, signer, keys); status != DnssecSecure {
+				return status, "NSEC3 " + reason, true
+			}
+
+			if optOut && !matched {
+				return DnssecInsecure, "unsigned delegation in NSEC3 opt-out span", true
+			}
+
+			if hasType(v.TypeBitMap, dns.TypeNS) && !hasType(v.TypeBitMap, dns.TypeSOA) {
+				return DnssecInsecure, "unsigned delegation", true
+			}
+			return DnssecSecure, "", false
+		}
+	}
+
+	return DnssecBogus, "missing denial of existence for DS", true
+}
+
+// nsecCovers checks whether name sorts between the owner and next name of the NSEC record
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+
+	owner := canonicalName(nsec.Header().Name)
+	next := canonicalName(nsec.NextDomain)
+	n := canonicalName(name)
+
+	// The last NSEC in the zone wraps around to the apex
+	if next <= owner {
+		return n > owner || n < next
+	}
+
+	return n > owner && n < next
+}
+
+// canonicalName orders names by their reversed labels as in RFC 4034 6.1
+func canonicalName(name string) string {
+
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, "\x00")
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRRset checks the RRSIGs over the name/rrtype records in section with the zone keys
+func verifyRRset(section []dns.RR, name string, rrtype uint16, signer string, keys []*dns.DNSKEY) (string, string) {
+
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+
+	for _, rr := range section {
+
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == rrtype {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+
+		if rr.Header().Rrtype == rrtype {
+			rrset = append(rrset, rr)
+		}
+	}
+
+	if len(rrset) == 0 {
+		return DnssecIndeterminate, "no records to validate"
+	}
+
+	if len(sigs) == 0 {
+		return DnssecBogus, "missing signature"
+	}
+
+	status := DnssecBogus
+	reason := ""
+	now := time.Now()
+
+	for _, sig := range sigs {
+
+		if !strings.EqualFold(sig.SignerName, signer) {
+			// Signed by a zone that wasn't part of the validated chain
+			status = DnssecIndeterminate
+			reason = fmt.Sprintf("signed by %s", normalizeDomain(sig.SignerName))
+			continue
+		}
+
+		status = DnssecBogus
+
+		if !sig.ValidityPeriod(now) {
+			if int64(sig.Expiration) < now.Unix() {
+				reason = "signature expired"
+			} else {
+				reason = "signature not yet valid"
+			}
+			continue
+		}
+
+		var tagged []*dns.DNSKEY
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag {
+				tagged = append(tagged, key)
+			}
+		}
+
+		if len(tagged) == 0 {
+			reason = "no DNSKEY matches the signature key tag"
+			continue
+		}
+
+		for _, key := range tagged {
+
+			if key.Algorithm != sig.Algorithm {
+				reason = "algorithm mismatch between RRSIG and DNSKEY"
+				continue
+			}
+
+			if err := sig.Verify(key, rrset); err != nil {
+				reason = fmt.Sprintf("signature verification failed: %v", err)
+				continue
+			}
+
+			return DnssecSecure, ""
+		}
+	}
+
+	return status, reason
+}
+
+// wildcardLabels returns the labels field of the RRSIG when the RRset was expanded from a wildcard
+func wildcardLabels(section []dns.RR, name string, rrtype uint16) (uint8, bool) {
+
+	for _, rr := range section {
+		sig, ok := rr.(*dns.RRSIG)
+		if ok && sig.TypeCovered == rrtype && strings.EqualFold(sig.Header().Name, name) && int(sig.Labels) < dns.CountLabel(name) {
+			return sig.Labels, true
+		}
+	}
+
+	return 0, false
+}
+
+// proveNoCloserMatch checks the NSEC or NSEC3 record proving no name closer than the wildcard exists
+// NSEC has to cover the name itself (RFC 4035 5.3.4) and NSEC3 the next closer name (RFC 5155 8.8)
+func proveNoCloserMatch(r *dns.Msg, name string, labels uint8, signer string, keys []*dns.DNSKEY) (string, string) {
+
+	// The closest encloser has the RRSIG's labels and the next closer name has one more
+	indexes := dns.Split(name)
+	nextCloser := name[indexes[len(indexes)-int(labels)-1]:]
+
+	for _, rr := range r.Ns {
+
+		switch v := rr.(type) {
+		case *dns.NSEC:
+			if !nsecCovers(v, name) {
+				continue
+			}
+			if status, reason := verifyRRset(r.Ns, v.Header().Name, dns.TypeNSEC, signer, keys); status != DnssecSecure {
+				return status, "wildcard NSEC " + reason
+			}
+			return DnssecSecure, ""
+
+		case *dns.NSEC3:
+			if !v.Cover(nextCloser) {
+				continue
+			}
+			if status, reason := verifyRRset(r.Ns, v.Header().Name, dns.TypeNSEC3, signer, keys); status != DnssecSecure {
+				return status, "wildcard NSEC3 " + reason
+			}
+			return DnssecSecure, ""
+		}
+	}
+
+	return DnssecIndeterminate, "wildcard expansion without proof that no closer name exists"
+}
+
+// validateRRsets checks the signatures of every RRset collected in domainData
+func (client *DnsClient) validateRRsets(ctx context.Context, domainData *DomainData, data *DnssecData, signer string, keys []*dns.DNSKEY) []DnssecRRset {
+
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+
+	seen := make(map[rrsetKey]bool)
+	var rrsetKeys []rrsetKey
+
+	domainData.raw.mu.Lock()
+	for _, rrs := range domainData.raw.records {
+		for _, rr := range rrs {
+			key := rrsetKey{name: fqdn(rr.Header().Name), rrtype: rr.Header().Rrtype}
+			if !seen[key] {
+				seen[key] = true
+				rrsetKeys = append(rrsetKeys, key)
+			}
+		}
+	}
+	domainData.raw.mu.Unlock()
+
+	sort.Slice(rrsetKeys, func(i, j int) bool {
+		if rrsetKeys[i].name != rrsetKeys[j].name {
+			return rrsetKeys[i].name < rrsetKeys[j].name
+		}
+		return rrsetKeys[i].rrtype < rrsetKeys[j].rrtype
+	})
+
+	rrsets := make([]DnssecRRset, len(rrsetKeys))
+	done := make(chan struct{}, len(rrsetKeys))
+
+	for i, key := range rrsetKeys {
+
+		rrsets[i] = DnssecRRset{
+			Name:   normalizeDomain(key.name),
+			Type:   dns.TypeToString[key.rrtype],
+			Status: data.Status,
+			Reason: data.Reason,
+		}
+
+		// Every RRset below a broken or missing chain has the same status as the chain
+		if data.Status != DnssecSecure {
+			done <- struct{}{}
+			continue
+		}
+
+		go func(i int, name string, rrtype uint16) {
+
+			defer func() { done <- struct{}{} }()
+
+			r, err := client.queryDnssec(ctx, name, rrtype)
+			if err != nil {
+				rrsets[i].Status = DnssecIndeterminate
+				rrsets[i].Reason = fmt.Sprintf("lookup failed: %v", err)
+				return
+			}
+
+			rrsets[i].Status, rrsets[i].Reason = verifyRRset(r.Answer, name, rrtype, signer, keys)
+
+			// A valid signature over a wildcard expansion doesn't show the name has no records of its own
+			if labels, ok := wildcardLabels(r.Answer, name, rrtype); ok && rrsets[i].Status == DnssecSecure {
+				rrsets[i].Status, rrsets[i].Reason = proveNoCloserMatch(r, name, labels, signer, keys)
+			}
+
+		}(i, key.name, key.rrtype)
+	}
+
+	for range rrsetKeys {
+		<-done
+	}
+
+	return rrsets
+}
@@ -0,0 +1,474 @@
+package dnsrecon
+
+import (
+	"context"
+	"crypto"
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testZone is a zone signed with a single ECDSA key
+type testZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign returns the RRSIG over rrset valid until expiration
+func (z *testZone) sign(t *testing.T, rrset []dns.RR, expiration time.Time) *dns.RRSIG {
+
+	t.Helper()
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(expiration.Add(-48 * time.Hour).Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+
+	if err := sig.Sign(z.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// signedResolver answers like a recursive resolver with DNSSEC records from prebuilt responses
+// Queries without a response get NXDOMAIN
+type signedResolver struct {
+	t         *testing.T
+	responses map[string]*dns.Msg
+}
+
+func responseKey(name string, qtype uint16) string {
+	return strings.ToLower(dns.Fqdn(name)) + " " + dns.TypeToString[qtype]
+}
+
+// answer stores the records, all of one name and type, as a response signed by the zone
+func (s *signedResolver) answer(z *testZone, records ...string) *dns.Msg {
+	return s.answerUntil(z, time.Now().Add(24*time.Hour), records...)
+}
+
+// answerUntil stores the records with a signature valid until expiration
+func (s *signedResolver) answerUntil(z *testZone, expiration time.Time, records ...string) *dns.Msg {
+
+	m := new(dns.Msg)
+	for _, record := range records {
+		m.Answer = append(m.Answer, mustRR(s.t, record))
+	}
+	if z != nil {
+		m.Answer = append(m.Answer, z.sign(s.t, m.Answer, expiration))
+	}
+
+	h := m.Answer[0].Header()
+	s.responses[responseKey(h.Name, h.Rrtype)] = m
+
+	return m
+}
+
+// deny stores a response without answers for the name and type, the proof records are signed by the zone
+func (s *signedResolver) deny(name string, qtype uint16, z *testZone, proof ...dns.RR) *dns.Msg {
+
+	m := new(dns.Msg)
+	for _, rr := range proof {
+		m.Ns = append(m.Ns, rr, z.sign(s.t, []dns.RR{rr}, time.Now().Add(24*time.Hour)))
+	}
+
+	s.responses[responseKey(name, qtype)] = m
+
+	return m
+}
+
+func (s *signedResolver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+
+	if response, ok := s.responses[responseKey(r.Question[0].Name, r.Question[0].Qtype)]; ok {
+		m = response.Copy()
+	} else {
+		m.Rcode = dns.RcodeNameError
+	}
+
+	rcode := m.Rcode
+	m.SetReply(r)
+	m.Rcode = rcode
+	m.RecursionAvailable = true
+
+	w.WriteMsg(m)
+}
+
+// signedTree is the root, test. and example.test. zones linked by DS records
+type signedTree struct {
+	*signedResolver
+	root, tld, zone *testZone
+}
+
+func newSignedTree(t *testing.T) *signedTree {
+
+	s := &signedTree{
+		signedResolver: &signedResolver{t: t, responses: make(map[string]*dns.Msg)},
+		root:           newTestZone(t, "."),
+		tld:            newTestZone(t, "test."),
+		zone:           newTestZone(t, "example.test."),
+	}
+
+	for _, z := range []*testZone{s.root, s.tld, s.zone} {
+		s.answer(z, z.key.String())
+	}
+
+	s.answer(s.root, s.tld.key.ToDS(dns.SHA256).String())
+	s.answer(s.tld, s.zone.key.ToDS(dns.SHA256).String())
+	s.answer(s.zone, "example.test. 300 IN A 192.0.2.1")
+
+	return s
+}
+
+// client returns a client resolving through the tree with the root key as its trust anchor
+func (s *signedTree) client() *DnsClient {
+
+	client := newLocalClient(s.t, serveDNS(s.t, "127.0.0.1:0", s.ServeDNS))
+	client.TrustAnchors = []*dns.DS{s.root.key.ToDS(dns.SHA256)}
+
+	return client
+}
+
+// validate looks up the A records of the domain with DNSSEC validation
+func (s *signedTree) validate(domain string) *DnssecData {
+
+	s.t.Helper()
+
+	domainData := s.client().GetDnsDataWithOptions(context.Background(), domain, LookupOptions{Types: []uint16{dns.TypeA}, DNSSEC: true})
+	if domainData.DNSSEC == nil {
+		s.t.Fatalf("%s: no DNSSEC data, status %s, errors %v", domain, domainData.Status, domainData.Errors)
+	}
+
+	return domainData.DNSSEC
+}
+
+// rrset returns the status of the RRset of the type
+func rrset(t *testing.T, data *DnssecData, rrtype string) DnssecRRset {
+
+	t.Helper()
+
+	for _, rrset := range data.RRsets {
+		if rrset.Type == rrtype {
+			return rrset
+		}
+	}
+
+	t.Fatalf("no %s RRset in %+v", rrtype, data.RRsets)
+	return DnssecRRset{}
+}
+
+// nsec3Record is an NSEC3 record of the zone for the hashed owner, the hash is unsalted with no extra iterations
+func nsec3Record(zone string, owner string, next string, optOut bool, types ...uint16) *dns.NSEC3 {
+
+	nsec3 := &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: owner + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+	if optOut {
+		nsec3.Flags = 1
+	}
+
+	return nsec3
+}
+
+// An NSEC3 record from nsec3First to nsec3Last covers every other hash
+const (
+	nsec3First = "00000000000000000000000000000000"
+	nsec3Last  = "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV"
+)
+
+func TestDnssecSecure(t *testing.T) {
+
+	data := newSignedTree(t).validate("example.test")
+
+	if data.Status != DnssecSecure {
+		t.Fatalf("status = %s (%s), want secure", data.Status, data.Reason)
+	}
+
+	var zones []string
+	for _, link := range data.Chain {
+		zones = append(zones, link.Zone)
+	}
+	if strings.Join(zones, " ") != ". test example.test" {
+		t.Errorf("chain = %v, want . test example.test", zones)
+	}
+
+	if a := rrset(t, data, "A"); a.Status != DnssecSecure {
+		t.Errorf("A RRset = %s (%s), want secure", a.Status, a.Reason)
+	}
+}
+
+func TestDnssecInsecureDelegation(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		proof  func(s *signedTree) dns.RR
+		reason string
+	}{
+		{
+			name: "NSEC",
+			proof: func(s *signedTree) dns.RR {
+				return &dns.NSEC{
+					Hdr:        dns.RR_Header{Name: "unsigned.test.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+					NextDomain: "zz.test.",
+					TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+				}
+			},
+			reason: "unsigned delegation",
+		},
+		{
+			name: "NSEC3",
+			proof: func(s *signedTree) dns.RR {
+				return nsec3Record("test.", dns.HashName("unsigned.test.", dns.SHA1, 0, ""), nsec3Last, false, dns.TypeNS)
+			},
+			reason: "unsigned delegation",
+		},
+		{
+			name: "NSEC3 opt-out",
+			proof: func(s *signedTree) dns.RR {
+				return nsec3Record("test.", nsec3First, nsec3Last, true, dns.TypeNS)
+			},
+			reason: "opt-out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := newSignedTree(t)
+			s.answer(nil, "unsigned.test. 300 IN A 192.0.2.2")
+			s.deny("unsigned.test.", dns.TypeDS, s.tld, tt.proof(s))
+
+			data := s.validate("unsigned.test")
+
+			if data.Status != DnssecInsecure || !strings.Contains(data.Reason, tt.reason) {
+				t.Fatalf("status = %s (%s), want insecure (%s)", data.Status, data.Reason, tt.reason)
+			}
+			if a := rrset(t, data, "A"); a.Status != DnssecInsecure {
+				t.Errorf("A RRset = %s, want insecure", a.Status)
+			}
+		})
+	}
+}
+
+func TestDnssecDenialInsideZone(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		proof dns.RR
+	}{
+		{
+			name: "NSEC",
+			proof: &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: "www.example.test.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+				NextDomain: "example.test.",
+				TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+			},
+		},
+		{
+			name:  "NSEC3",
+			proof: nsec3Record("example.test.", dns.HashName("www.example.test.", dns.SHA1, 0, ""), nsec3Last, false, dns.TypeA, dns.TypeRRSIG),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// www isn't a delegation so the zone keys sign its records
+			s := newSignedTree(t)
+			s.answer(s.zone, "www.example.test. 300 IN A 192.0.2.3")
+			s.deny("www.example.test.", dns.TypeDS, s.zone, tt.proof)
+
+			data := s.validate("www.example.test")
+
+			if data.Status != DnssecSecure {
+				t.Fatalf("status = %s (%s), want secure", data.Status, data.Reason)
+			}
+			if len(data.Chain) != 3 {
+				t.Errorf("chain has %d zones, want 3", len(data.Chain))
+			}
+			if a := rrset(t, data, "A"); a.Status != DnssecSecure {
+				t.Errorf("A RRset = %s (%s), want secure", a.Status, a.Reason)
+			}
+		})
+	}
+}
+
+func TestDnssecBogus(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		setup  func(s *signedTree)
+		chain  string
+		rrset  string
+		reason string
+	}{
+		{
+			name: "bad signature",
+			setup: func(s *signedTree) {
+				m := s.answer(s.zone, "example.test. 300 IN A 192.0.2.1")
+				m.Answer[0].(*dns.A).A = []byte{192, 0, 2, 66}
+			},
+			chain:  DnssecSecure,
+			rrset:  DnssecBogus,
+			reason: "signature verification failed",
+		},
+		{
+			name: "expired signature",
+			setup: func(s *signedTree) {
+				s.answerUntil(s.zone, time.Now().Add(-time.Hour), "example.test. 300 IN A 192.0.2.1")
+			},
+			chain:  DnssecSecure,
+			rrset:  DnssecBogus,
+			reason: "signature expired",
+		},
+		{
+			name: "DS without a matching key",
+			setup: func(s *signedTree) {
+				s.answer(s.tld, newTestZone(t, "example.test.").key.ToDS(dns.SHA256).String())
+			},
+			chain:  DnssecBogus,
+			rrset:  DnssecBogus,
+			reason: "no DNSKEY matches the DS records",
+		},
+		{
+			name: "missing DS denial",
+			setup: func(s *signedTree) {
+				s.deny("example.test.", dns.TypeDS, s.tld)
+			},
+			chain:  DnssecBogus,
+			rrset:  DnssecBogus,
+			reason: "missing denial of existence for DS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := newSignedTree(t)
+			tt.setup(s)
+
+			data := s.validate("example.test")
+
+			if data.Status != tt.chain {
+				t.Fatalf("chain status = %s (%s), want %s", data.Status, data.Reason, tt.chain)
+			}
+
+			a := rrset(t, data, "A")
+			if a.Status != tt.rrset || !strings.Contains(a.Reason, tt.reason) {
+				t.Errorf("A RRset = %s (%s), want %s (%s)", a.Status, a.Reason, tt.rrset, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDnssecWildcard(t *testing.T) {
+
+	// NSEC from the apex to www covers host.example.test
+	covering := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "example.test.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+		NextDomain: "www.example.test.",
+		TypeBitMap: []uint16{dns.TypeA, dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY},
+	}
+
+	tests := []struct {
+		name   string
+		proof  func(s *signedTree) []dns.RR
+		status string
+		reason string
+	}{
+		{
+			name:   "without proof",
+			proof:  func(s *signedTree) []dns.RR { return nil },
+			status: DnssecIndeterminate,
+			reason: "no closer name",
+		},
+		{
+			name: "NSEC proof",
+			proof: func(s *signedTree) []dns.RR {
+				return []dns.RR{covering, s.zone.sign(t, []dns.RR{covering}, time.Now().Add(time.Hour))}
+			},
+			status: DnssecSecure,
+		},
+		{
+			name: "NSEC3 proof",
+			proof: func(s *signedTree) []dns.RR {
+				nsec3 := nsec3Record("example.test.", nsec3First, nsec3Last, false, dns.TypeA)
+				return []dns.RR{nsec3, s.zone.sign(t, []dns.RR{nsec3}, time.Now().Add(time.Hour))}
+			},
+			status: DnssecSecure,
+		},
+		{
+			name: "forged NSEC proof",
+			proof: func(s *signedTree) []dns.RR {
+				sig := s.zone.sign(t, []dns.RR{covering}, time.Now().Add(time.Hour))
+				forged := dns.Copy(covering).(*dns.NSEC)
+				forged.NextDomain = "zzz.example.test."
+				return []dns.RR{forged, sig}
+			},
+			status: DnssecBogus,
+			reason: "wildcard NSEC",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := newSignedTree(t)
+
+			// The resolver expands *.example.test for host.example.test, the RRSIG keeps the wildcard's labels
+			wildcard := s.answer(s.zone, "*.example.test. 300 IN A 192.0.2.4")
+			expanded := new(dns.Msg)
+			for _, rr := range wildcard.Answer {
+				rr = dns.Copy(rr)
+				rr.Header().Name = "host.example.test."
+				expanded.Answer = append(expanded.Answer, rr)
+			}
+			expanded.Ns = tt.proof(s)
+			s.responses[responseKey("host.example.test.", dns.TypeA)] = expanded
+
+			// host.example.test doesn't exist so it isn't a delegation either
+			s.deny("host.example.test.", dns.TypeDS, s.zone, covering)
+
+			data := s.validate("host.example.test")
+
+			if data.Status != DnssecSecure {
+				t.Fatalf("chain status = %s (%s), want secure", data.Status, data.Reason)
+			}
+
+			a := rrset(t, data, "A")
+			if a.Status != tt.status || !strings.Contains(a.Reason, tt.reason) {
+				t.Errorf("A RRset = %s (%s), want %s (%s)", a.Status, a.Reason, tt.status, tt.reason)
+			}
+		})
+	}
+}
@@ -1,14 +1,17 @@
 package dnsrecon
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/miekg/dns"
 	"strings"
-	"time"
 )
 
 const (
-	ErrNoData = "NODATA"
+	ErrNoData    = "NODATA"
+	ErrTimeout   = "TIMEOUT"
+	ErrCancelled = "CANCELLED"
 )
 
 func normalizeDomain(d string) string {
@@ -27,7 +30,7 @@ func newIpSet() IpSet {
 	return ipset
 }
 
-func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaResponse, domainData *DomainData) {
+func (client *DnsClient) getSOA(ctx context.Context, targetDomain string, soaDataChan chan<- soaResponse, domainData *DomainData) {
 
 	defer close(soaDataChan)
 
@@ -37,11 +40,12 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 	m.SetQuestion(fqdn(targetDomain), dns.TypeSOA)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
-	if err != nil {
-		// Retry in 500ms
-		time.Sleep(time.Millisecond * 500)
-		r, err = client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
+	if err != nil && ctx.Err() == nil {
+		// Retry after a short delay
+		if client.waitRetry(ctx) == nil {
+			r, err = client.DnsResolver(ctx, m)
+		}
 	}
 
 	if err != nil {
@@ -51,11 +55,13 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		soaDataChan <- response
 		return
 	}
 
+	domainData.addRecords("soa", dns.TypeSOA, r.Answer)
+
 	var cnamePaths []string
 	var ipv4DataChannels []chan []*dns.A
 	var ipv6DataChannels []chan []*dns.AAAA
@@ -85,8 +91,8 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 
 			ipv4DataChan := make(chan []*dns.A, 1)
 			ipv6DataChan := make(chan []*dns.AAAA, 1)
-			go client.getARecord(soa.Ns, ipv4DataChan)
-			go client.getAAAARecord(soa.Ns, ipv6DataChan)
+			go client.getARecord(ctx, soa.Ns, ipv4DataChan)
+			go client.getAAAARecord(ctx, soa.Ns, ipv6DataChan)
 			ipv4DataChannels = append(ipv4DataChannels, ipv4DataChan)
 			ipv6DataChannels = append(ipv6DataChannels, ipv6DataChan)
 
@@ -98,6 +104,8 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 	// Check DNS Authority Section if the CNAME path did not end in an SOA record
 	if soaData.Name == "" {
 
+		domainData.addRecords("soa", dns.TypeSOA, r.Ns)
+
 		for _, soaAns := range r.Ns {
 
 			if soa, ok := soaAns.(*dns.SOA); ok {
@@ -107,8 +115,8 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 
 				ipv4DataChan := make(chan []*dns.A, 1)
 				ipv6DataChan := make(chan []*dns.AAAA, 1)
-				go client.getARecord(soa.Ns, ipv4DataChan)
-				go client.getAAAARecord(soa.Ns, ipv6DataChan)
+				go client.getARecord(ctx, soa.Ns, ipv4DataChan)
+				go client.getAAAARecord(ctx, soa.Ns, ipv6DataChan)
 				ipv4DataChannels = append(ipv4DataChannels, ipv4DataChan)
 				ipv6DataChannels = append(ipv6DataChannels, ipv6DataChan)
 
@@ -122,6 +130,7 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 
 		for ipv4Ans := range ipv4DataChan {
 			for _, ipv4 := range ipv4Ans {
+				domainData.addHostRecord(ipv4)
 				primary_ns := normalizeDomain(ipv4.Header().Name)
 				soa, ok := soaData.Nameserver[primary_ns]
 				if !ok {
@@ -141,6 +150,7 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 
 		for ipv6Ans := range ipv6DataChan {
 			for _, ipv6 := range ipv6Ans {
+				domainData.addHostRecord(ipv6)
 				primary_ns := normalizeDomain(ipv6.Header().Name)
 				soa, ok := soaData.Nameserver[primary_ns]
 				if !ok {
@@ -177,7 +187,7 @@ func (client *DnsClient) getSOA(targetDomain string, soaDataChan chan<- soaRespo
 	soaDataChan <- response
 }
 
-func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse, domainData *DomainData) {
+func (client *DnsClient) getNS(ctx context.Context, targetDomain string, nsDataChan chan<- nsResponse, domainData *DomainData) {
 
 	// fmt.Println(targetDomain)
 	defer close(nsDataChan)
@@ -188,7 +198,7 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 	m.SetQuestion(fqdn(targetDomain), dns.TypeNS)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		nsDataChan <- response
@@ -196,11 +206,13 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		nsDataChan <- response
 		return
 	}
 
+	domainData.addRecords("ns", dns.TypeNS, r.Answer)
+
 	var nsSet = make(map[string]IpSet)
 	var ipv4DataChannels []chan []*dns.A
 	var ipv6DataChannels []chan []*dns.AAAA
@@ -226,8 +238,8 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 
 			ipv4DataChan := make(chan []*dns.A, 1)
 			ipv6DataChan := make(chan []*dns.AAAA, 1)
-			go client.getARecord(ns.Ns, ipv4DataChan)
-			go client.getAAAARecord(ns.Ns, ipv6DataChan)
+			go client.getARecord(ctx, ns.Ns, ipv4DataChan)
+			go client.getAAAARecord(ctx, ns.Ns, ipv6DataChan)
 			ipv4DataChannels = append(ipv4DataChannels, ipv4DataChan)
 			ipv6DataChannels = append(ipv6DataChannels, ipv6DataChan)
 
@@ -239,6 +251,7 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 	for _, ipv4DataChan := range ipv4DataChannels {
 		for ipv4Ans := range ipv4DataChan {
 			for _, ipv4 := range ipv4Ans {
+				domainData.addHostRecord(ipv4)
 				ns, ok := nsSet[normalizeDomain(ipv4.Header().Name)]
 				if !ok {
 					continue
@@ -257,6 +270,7 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 	for _, ipv6DataChan := range ipv6DataChannels {
 		for ipv6Ans := range ipv6DataChan {
 			for _, ipv6 := range ipv6Ans {
+				domainData.addHostRecord(ipv6)
 				ns, ok := nsSet[normalizeDomain(ipv6.Header().Name)]
 				if !ok {
 					continue
@@ -292,7 +306,7 @@ func (client *DnsClient) getNS(targetDomain string, nsDataChan chan<- nsResponse
 	nsDataChan <- response
 }
 
-func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse, domainData *DomainData) {
+func (client *DnsClient) getMX(ctx context.Context, targetDomain string, mxDataChan chan<- mxResponse, domainData *DomainData) {
 
 	defer close(mxDataChan)
 
@@ -302,7 +316,7 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 	m.SetQuestion(fqdn(targetDomain), dns.TypeMX)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		mxDataChan <- response
@@ -311,11 +325,13 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		mxDataChan <- response
 		return
 	}
 
+	domainData.addRecords("mx", dns.TypeMX, r.Answer)
+
 	var mxSet = make(map[int]map[string]IpSet)
 	var ipv4DataChannels []chan []*dns.A
 	var ipv6DataChannels []chan []*dns.AAAA
@@ -341,8 +357,8 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 
 			ipv4DataChan := make(chan []*dns.A, 1)
 			ipv6DataChan := make(chan []*dns.AAAA, 1)
-			go client.getARecord(mx.Mx, ipv4DataChan)
-			go client.getAAAARecord(mx.Mx, ipv6DataChan)
+			go client.getARecord(ctx, mx.Mx, ipv4DataChan)
+			go client.getAAAARecord(ctx, mx.Mx, ipv6DataChan)
 			ipv4DataChannels = append(ipv4DataChannels, ipv4DataChan)
 			ipv6DataChannels = append(ipv6DataChannels, ipv6DataChan)
 
@@ -358,6 +374,7 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 	for _, ipv4DataChan := range ipv4DataChannels {
 		for ipv4Ans := range ipv4DataChan {
 			for _, ipv4 := range ipv4Ans {
+				domainData.addHostRecord(ipv4)
 				for _, preference := range mxSet {
 
 					mxdata, ok := preference[normalizeDomain(ipv4.Header().Name)]
@@ -378,6 +395,7 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 	for _, ipv6DataChan := range ipv6DataChannels {
 		for ipv6Ans := range ipv6DataChan {
 			for _, ipv6 := range ipv6Ans {
+				domainData.addHostRecord(ipv6)
 				for _, preference := range mxSet {
 
 					mxdata, ok := preference[normalizeDomain(ipv6.Header().Name)]
@@ -416,7 +434,7 @@ func (client *DnsClient) getMX(targetDomain string, mxDataChan chan<- mxResponse
 	mxDataChan <- response
 }
 
-func (client *DnsClient) getTXT(targetDomain string, txtDataChan chan<- txtResponse, domainData *DomainData) {
+func (client *DnsClient) getTXT(ctx context.Context, targetDomain string, txtDataChan chan<- txtResponse, domainData *DomainData) {
 
 	// fmt.Println(targetDomain)
 	defer close(txtDataChan)
@@ -427,7 +445,7 @@ func (client *DnsClient) getTXT(targetDomain string, txtDataChan chan<- txtRespo
 	m.SetQuestion(fqdn(targetDomain), dns.TypeTXT)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		txtDataChan <- response
@@ -436,11 +454,13 @@ func (client *DnsClient) getTXT(targetDomain string, txtDataChan chan<- txtRespo
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		txtDataChan <- response
 		return
 	}
 
+	domainData.addRecords("txt", dns.TypeTXT, r.Answer)
+
 	var txtSet []string
 
 	for _, txtAns := range r.Answer {
@@ -456,7 +476,7 @@ func (client *DnsClient) getTXT(targetDomain string, txtDataChan chan<- txtRespo
 	txtDataChan <- response
 }
 
-func (client *DnsClient) getCNAME(targetDomain string, cnameDataChan chan<- cnameResponse, domainData *DomainData) {
+func (client *DnsClient) getCNAME(ctx context.Context, targetDomain string, cnameDataChan chan<- cnameResponse, domainData *DomainData) {
 
 	defer close(cnameDataChan)
 
@@ -466,7 +486,7 @@ func (client *DnsClient) getCNAME(targetDomain string, cnameDataChan chan<- cnam
 	m.SetQuestion(fqdn(targetDomain), dns.TypeCNAME)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		cnameDataChan <- response
@@ -475,11 +495,13 @@ func (client *DnsClient) getCNAME(targetDomain string, cnameDataChan chan<- cnam
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		cnameDataChan <- response
 		return
 	}
 
+	domainData.addRecords("cname", dns.TypeCNAME, r.Answer)
+
 	var cnameSet []string
 
 	for _, cnameAns := range r.Answer {
@@ -495,7 +517,7 @@ func (client *DnsClient) getCNAME(targetDomain string, cnameDataChan chan<- cnam
 	cnameDataChan <- response
 }
 
-func (client *DnsClient) getA(targetDomain string, aDataChan chan<- aResponse, domainData *DomainData) {
+func (client *DnsClient) getA(ctx context.Context, targetDomain string, aDataChan chan<- aResponse, domainData *DomainData) {
 
 	// fmt.Println(targetDomain)
 	defer close(aDataChan)
@@ -506,7 +528,7 @@ func (client *DnsClient) getA(targetDomain string, aDataChan chan<- aResponse, d
 	m.SetQuestion(fqdn(targetDomain), dns.TypeA)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		aDataChan <- response
@@ -514,11 +536,13 @@ func (client *DnsClient) getA(targetDomain string, aDataChan chan<- aResponse, d
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		aDataChan <- response
 		return
 	}
 
+	domainData.addRecords("a", dns.TypeA, r.Answer)
+
 	var aSet []string
 	var cnamePaths []string
 	finalCNameName := false
@@ -565,7 +589,7 @@ func (client *DnsClient) getA(targetDomain string, aDataChan chan<- aResponse, d
 	aDataChan <- response
 }
 
-func (client *DnsClient) getAAAA(targetDomain string, aaaaDataChan chan<- aaaaResponse, domainData *DomainData) {
+func (client *DnsClient) getAAAA(ctx context.Context, targetDomain string, aaaaDataChan chan<- aaaaResponse, domainData *DomainData) {
 
 	// fmt.Println(targetDomain)
 
@@ -577,7 +601,7 @@ func (client *DnsClient) getAAAA(targetDomain string, aaaaDataChan chan<- aaaaRe
 	m.SetQuestion(fqdn(targetDomain), dns.TypeAAAA)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
 		response.Error = err
 		aaaaDataChan <- response
@@ -585,11 +609,13 @@ func (client *DnsClient) getAAAA(targetDomain string, aaaaDataChan chan<- aaaaRe
 	}
 
 	if r == nil {
-		response.Error = fmt.Errorf(ErrNoData)
+		response.Error = errors.New(ErrNoData)
 		aaaaDataChan <- response
 		return
 	}
 
+	domainData.addRecords("aaaa", dns.TypeAAAA, r.Answer)
+
 	var aaaaSet []string
 
 	var cnamePaths []string
@@ -0,0 +1,681 @@
+package dnsrecon
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EmailRecordValid   = "valid"
+	EmailRecordMissing = "missing"
+	EmailRecordInvalid = "invalid"
+	EmailRecordError   = "error"
+
+	// minDKIMBits is the shortest RSA key RFC 8301 allows for signing
+	minDKIMBits = 1024
+)
+
+// DefaultDKIMSelectors are selectors used by common mail providers and software
+var DefaultDKIMSelectors = []string{
+	"default",
+	"dkim",
+	"mail",
+	"selector1",
+	"selector2",
+	"google",
+	"k1",
+	"k2",
+	"k3",
+	"s1",
+	"s2",
+	"smtp",
+	"mx",
+	"email",
+	"mandrill",
+	"everlytickey1",
+	"everlytickey2",
+	"mxvault",
+	"zoho",
+	"protonmail",
+	"protonmail2",
+	"protonmail3",
+	"fm1",
+	"fm2",
+	"fm3",
+	"sig1",
+	"amazonses",
+	"cm",
+	"pm",
+	"key1",
+}
+
+// EmailSecurity holds the email authentication records of a domain
+type EmailSecurity struct {
+	Summary EmailSummary `json:"summary"`
+	DMARC   DMARCRecord  `json:"dmarc"`
+	DKIM    []DKIMKey    `json:"dkim"`
+	MTASTS  MTASTSRecord `json:"mta_sts"`
+	TLSRPT  TLSRPTRecord `json:"tls_rpt"`
+	BIMI    BIMIRecord   `json:"bimi"`
+
+	// DKIMErrors has the lookup error of each selector that couldn't be probed
+	DKIMErrors map[string]string `json:"dkim_errors"`
+}
+
+// EmailSummary is the posture at a glance, Issues lists weaknesses worth fixing
+// Incomplete is set when a lookup failed, the records it was for may still be published
+type EmailSummary struct {
+	SPF         string   `json:"spf"`
+	DMARC       string   `json:"dmarc"`
+	DMARCPolicy string   `json:"dmarc_policy"`
+	DKIM        int      `json:"dkim_keys"`
+	MTASTS      bool     `json:"mta_sts"`
+	TLSRPT      bool     `json:"tls_rpt"`
+	BIMI        bool     `json:"bimi"`
+	Incomplete  bool     `json:"incomplete"`
+	Issues      []string `json:"issues"`
+}
+
+// DMARCRecord is the parsed _dmarc TXT record, defaults from RFC 7489 are filled in for missing tags
+type DMARCRecord struct {
+	Status          string   `json:"status"`
+	Record          string   `json:"record"`
+	Policy          string   `json:"p"`
+	SubdomainPolicy string   `json:"sp"`
+	Percentage      int      `json:"pct"`
+	ADKIM           string   `json:"adkim"`
+	ASPF            string   `json:"aspf"`
+	RUA             []string `json:"rua"`
+	RUF             []string `json:"ruf"`
+	FailureOptions  string   `json:"fo"`
+	ReportInterval  int      `json:"ri"`
+	Errors          []string `json:"errors"`
+}
+
+// DKIMKey is the key published for a selector, a key with an empty p tag is revoked
+type DKIMKey struct {
+	Selector string `json:"selector"`
+	Record   string `json:"record"`
+	KeyType  string `json:"key_type"`
+	Bits     int    `json:"bits"`
+	Revoked  bool   `json:"revoked"`
+	Testing  bool   `json:"testing"`
+	Error    string `json:"error,omitempty"`
+}
+
+// MTASTSRecord is the _mta-sts TXT record, the policy itself is served over HTTPS and isn't fetched
+type MTASTSRecord struct {
+	Status string   `json:"status"`
+	Record string   `json:"record"`
+	ID     string   `json:"id"`
+	Errors []string `json:"errors"`
+}
+
+// TLSRPTRecord is the _smtp._tls TXT record
+type TLSRPTRecord struct {
+	Status string   `json:"status"`
+	Record string   `json:"record"`
+	RUA    []string `json:"rua"`
+	Errors []string `json:"errors"`
+}
+
+// BIMIRecord is the default._bimi TXT record with the logo and the mark certificate URLs
+type BIMIRecord struct {
+	Status    string   `json:"status"`
+	Record    string   `json:"record"`
+	Logo      string   `json:"logo"`
+	Authority string   `json:"authority"`
+	Errors    []string `json:"errors"`
+}
+
+// checkEmailSecurity looks up the email authentication records of the domain
+// spf is the SPF analysis of the domain, it's only used for the summary
+func (client *DnsClient) checkEmailSecurity(ctx context.Context, domain string, spf *SPFData) *EmailSecurity {
+
+	domain = normalizeDomain(domain)
+
+	var email EmailSecurity
+	var wg sync.WaitGroup
+
+	wg.Add(5)
+
+	go func() {
+		defer wg.Done()
+		email.DMARC = client.getDMARC(ctx, domain)
+	}()
+	go func() {
+		defer wg.Done()
+		email.DKIM, email.DKIMErrors = client.probeDKIM(ctx, domain)
+	}()
+	go func() {
+		defer wg.Done()
+		email.MTASTS = client.getMTASTS(ctx, domain)
+	}()
+	go func() {
+		defer wg.Done()
+		email.TLSRPT = client.getTLSRPT(ctx, domain)
+	}()
+	go func() {
+		defer wg.Done()
+		email.BIMI = client.getBIMI(ctx, domain)
+	}()
+
+	wg.Wait()
+
+	email.Summary = summarizeEmail(&email, spf)
+
+	return &email
+}
+
+// emailRecords returns the TXT records of name whose tags match
+func (client *DnsClient) emailRecords(ctx context.Context, name string, match func([]tag) bool) ([]string, error) {
+
+	r, err := client.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		if err.Error() == "NXDOMAIN" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []string
+
+	for _, rr := range r.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		record := strings.Join(txt.Txt, "")
+		if match(parseTagList(record)) {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// hasVersion matches records starting with the version tag, e.g. "v=DMARC1"
+func hasVersion(version string) func([]tag) bool {
+	return func(tags []tag) bool {
+		return len(tags) > 0 && tags[0].name == "v" && strings.EqualFold(tags[0].value, version)
+	}
+}
+
+// isDKIMKey matches key records, the version tag is optional for DKIM but must come first when present
+func isDKIMKey(tags []tag) bool {
+
+	if len(tags) > 0 && tags[0].name == "v" {
+		return strings.EqualFold(tags[0].value, "DKIM1")
+	}
+
+	return hasTag(tags, "p")
+}
+
+// hasTag reports whether the tag list contains the tag, whatever its value
+func hasTag(tags []tag, name string) bool {
+	for _, t := range tags {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tag is a name=value pair of a DKIM style tag list
+type tag struct {
+	name  string
+	value string
+}
+
+// parseTagList splits "v=DMARC1; p=reject" into its tags, names are lower case
+func parseTagList(record string) []tag {
+
+	var tags []tag
+
+	for _, part := range strings.Split(record, ";") {
+
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		i := strings.Index(part, "=")
+		if i < 0 {
+			tags = append(tags, tag{name: strings.ToLower(part)})
+			continue
+		}
+
+		tags = append(tags, tag{
+			name:  strings.ToLower(strings.TrimSpace(part[:i])),
+			value: strings.TrimSpace(part[i+1:]),
+		})
+	}
+
+	return tags
+}
+
+// singleRecord picks the only record or reports why there isn't exactly one
+func singleRecord(records []string, err error, kind string) (string, string, []string) {
+
+	switch {
+	case err != nil:
+		return "", EmailRecordError, []string{fmt.Sprintf("%s lookup failed: %v", kind, err)}
+	case len(records) == 0:
+		return "", EmailRecordMissing, make([]string, 0)
+	case len(records) > 1:
+		return records[0], EmailRecordInvalid, []string{fmt.Sprintf("%d %s records, only one is allowed", len(records), kind)}
+	}
+
+	return records[0], EmailRecordValid, make([]string, 0)
+}
+
+func (client *DnsClient) getDMARC(ctx context.Context, domain string) DMARCRecord {
+
+	records, err := client.emailRecords(ctx, "_dmarc."+domain, hasVersion("DMARC1"))
+
+	var dmarc DMARCRecord
+	dmarc.Record, dmarc.Status, dmarc.Errors = singleRecord(records, err, "DMARC")
+	dmarc.RUA = make([]string, 0)
+	dmarc.RUF = make([]string, 0)
+
+	if dmarc.Record == "" {
+		return dmarc
+	}
+
+	// Defaults for the optional tags
+	dmarc.Percentage = 100
+	dmarc.ADKIM = "r"
+	dmarc.ASPF = "r"
+	dmarc.FailureOptions = "0"
+	dmarc.ReportInterval = 86400
+
+	invalid := func(t tag) {
+		dmarc.Errors = append(dmarc.Errors, fmt.Sprintf("invalid %s value %q", t.name, t.value))
+	}
+
+	tags := parseTagList(dmarc.Record)[1:]
+
+	for _, t := range tags {
+		switch t.name {
+		case "p", "sp":
+			policy := strings.ToLower(t.value)
+			if policy != "none" && policy != "quarantine" && policy != "reject" {
+				invalid(t)
+				continue
+			}
+			if t.name == "p" {
+				dmarc.Policy = policy
+			} else {
+				dmarc.SubdomainPolicy = policy
+			}
+		case "pct":
+			pct, err := strconv.Atoi(t.value)
+			if err != nil || pct < 0 || pct > 100 {
+				invalid(t)
+				continue
+			}
+			dmarc.Percentage = pct
+		case "adkim", "aspf":
+			mode := strings.ToLower(t.value)
+			if mode != "r" && mode != "s" {
+				invalid(t)
+				continue
+			}
+			if t.name == "adkim" {
+				dmarc.ADKIM = mode
+			} else {
+				dmarc.ASPF = mode
+			}
+		case "rua", "ruf":
+			var uris []string
+			for _, uri := range strings.Split(t.value, ",") {
+				uri = strings.TrimSpace(uri)
+				if !strings.HasPrefix(strings.ToLower(uri), "mailto:") && !strings.HasPrefix(strings.ToLower(uri), "https:") {
+					invalid(t)
+					continue
+				}
+				uris = append(uris, uri)
+			}
+			if t.name == "rua" {
+				dmarc.RUA = append(dmarc.RUA, uris...)
+			} else {
+				dmarc.RUF = append(dmarc.RUF, uris...)
+			}
+		case "fo":
+			dmarc.FailureOptions = t.value
+		case "ri":
+			ri, err := strconv.Atoi(t.value)
+			if err != nil || ri < 0 {
+				invalid(t)
+				continue
+			}
+			dmarc.ReportInterval = ri
+		}
+	}
+
+	if !hasTag(tags, "p") {
+		dmarc.Errors = append(dmarc.Errors, "missing the p tag")
+	}
+	if dmarc.SubdomainPolicy == "" {
+		dmarc.SubdomainPolicy = dmarc.Policy
+	}
+
+	if len(dmarc.Errors) > 0 {
+		dmarc.Status = EmailRecordInvalid
+	}
+
+	return dmarc
+}
+
+// probeDKIM queries every configured selector, only selectors with a key record are returned
+// Selectors whose lookup failed are returned with the error instead
+func (client *DnsClient) probeDKIM(ctx context.Context, domain string) ([]DKIMKey, map[string]string) {
+
+	selectors := client.DKIMSelectors
+	if len(selectors) == 0 {
+		selectors = DefaultDKIMSelectors
+	}
+
+	found := make([][]DKIMKey, len(selectors))
+	errs := make(map[string]string)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, selector := range selectors {
+
+		wg.Add(1)
+
+		go func(i int, selector string) {
+			defer wg.Done()
+
+			records, err := client.emailRecords(ctx, selector+"._domainkey."+domain, isDKIMKey)
+			if err != nil {
+				mu.Lock()
+				errs[selector] = err.Error()
+				mu.Unlock()
+				return
+			}
+
+			for _, record := range records {
+				found[i] = append(found[i], parseDKIMKey(selector, record))
+			}
+		}(i, selector)
+	}
+
+	wg.Wait()
+
+	keys := make([]DKIMKey, 0)
+	for _, selectorKeys := range found {
+		keys = append(keys, selectorKeys...)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Selector < keys[j].Selector
+	})
+
+	return keys, errs
+}
+
+// parseDKIMKey reads the key type and size of a DKIM key record
+func parseDKIMKey(selector string, record string) DKIMKey {
+
+	key := DKIMKey{
+		Selector: selector,
+		Record:   record,
+		KeyType:  "rsa",
+	}
+
+	publicKey := ""
+	hasKey := false
+
+	for _, t := range parseTagList(record) {
+		switch t.name {
+		case "k":
+			key.KeyType = strings.ToLower(t.value)
+		case "p":
+			hasKey = true
+			publicKey = strings.Join(strings.Fields(t.value), "")
+		case "t":
+			for _, flag := range strings.Split(t.value, ":") {
+				if strings.TrimSpace(flag) == "y" {
+					key.Testing = true
+				}
+			}
+		}
+	}
+
+	if !hasKey {
+		key.Error = "missing the p tag"
+		return key
+	}
+
+	if publicKey == "" {
+		key.Revoked = true
+		return key
+	}
+
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		key.Error = "invalid base64 in the p tag"
+		return key
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		// Keys are usually SubjectPublicKeyInfo but some publish the bare RSA key
+		if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+			if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+				key.Bits = rsaKey.N.BitLen()
+				return key
+			}
+			key.Error = "the p tag isn't an RSA key"
+			return key
+		}
+		if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+			key.Bits = rsaKey.N.BitLen()
+			return key
+		}
+		key.Error = "invalid RSA key"
+
+	case "ed25519":
+		if len(der) != 32 {
+			key.Error = fmt.Sprintf("ed25519 key is %d bytes, expected 32", len(der))
+			return key
+		}
+		key.Bits = 256
+
+	default:
+		key.Error = fmt.Sprintf("unknown key type %q", key.KeyType)
+	}
+
+	return key
+}
+
+func (client *DnsClient) getMTASTS(ctx context.Context, domain string) MTASTSRecord {
+
+	records, err := client.emailRecords(ctx, "_mta-sts."+domain, hasVersion("STSv1"))
+
+	var sts MTASTSRecord
+	sts.Record, sts.Status, sts.Errors = singleRecord(records, err, "MTA-STS")
+
+	if sts.Record == "" {
+		return sts
+	}
+
+	for _, t := range parseTagList(sts.Record)[1:] {
+		if t.name == "id" {
+			sts.ID = t.value
+		}
+	}
+
+	// The id is 1 to 32 letters and digits, it changes whenever the policy does
+	if sts.ID == "" || len(sts.ID) > 32 || strings.IndexFunc(sts.ID, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		sts.Errors = append(sts.Errors, fmt.Sprintf("invalid id %q", sts.ID))
+		sts.Status = EmailRecordInvalid
+	}
+
+	return sts
+}
+
+func (client *DnsClient) getTLSRPT(ctx context.Context, domain string) TLSRPTRecord {
+
+	records, err := client.emailRecords(ctx, "_smtp._tls."+domain, hasVersion("TLSRPTv1"))
+
+	var rpt TLSRPTRecord
+	rpt.Record, rpt.Status, rpt.Errors = singleRecord(records, err, "TLS-RPT")
+	rpt.RUA = make([]string, 0)
+
+	if rpt.Record == "" {
+		return rpt
+	}
+
+	for _, t := range parseTagList(rpt.Record)[1:] {
+		if t.name != "rua" {
+			continue
+		}
+		for _, uri := range strings.Split(t.value, ",") {
+			uri = strings.TrimSpace(uri)
+			if !strings.HasPrefix(strings.ToLower(uri), "mailto:") && !strings.HasPrefix(strings.ToLower(uri), "https:") {
+				rpt.Errors = append(rpt.Errors, fmt.Sprintf("invalid rua %q", uri))
+				continue
+			}
+			rpt.RUA = append(rpt.RUA, uri)
+		}
+	}
+
+	if len(rpt.RUA) == 0 {
+		rpt.Errors = append(rpt.Errors, "no rua reporting address")
+	}
+	if len(rpt.Errors) > 0 {
+		rpt.Status = EmailRecordInvalid
+	}
+
+	return rpt
+}
+
+func (client *DnsClient) getBIMI(ctx context.Context, domain string) BIMIRecord {
+
+	records, err := client.emailRecords(ctx, "default._bimi."+domain, hasVersion("BIMI1"))
+
+	var bimi BIMIRecord
+	bimi.Record, bimi.Status, bimi.Errors = singleRecord(records, err, "BIMI")
+
+	if bimi.Record == "" {
+		return bimi
+	}
+
+	for _, t := range parseTagList(bimi.Record)[1:] {
+		switch t.name {
+		case "l":
+			bimi.Logo = t.value
+		case "a":
+			bimi.Authority = t.value
+		}
+	}
+
+	if bimi.Logo != "" && !strings.HasPrefix(strings.ToLower(bimi.Logo), "https://") {
+		bimi.Errors = append(bimi.Errors, "the logo URL must use https")
+		bimi.Status = EmailRecordInvalid
+	}
+
+	return bimi
+}
+
+// summarizeEmail reports the status of each record and the weaknesses found
+func summarizeEmail(email *EmailSecurity, spf *SPFData) EmailSummary {
+
+	summary := EmailSummary{
+		DMARC:       email.DMARC.Status,
+		DMARCPolicy: email.DMARC.Policy,
+		MTASTS:      email.MTASTS.Status == EmailRecordValid,
+		TLSRPT:      email.TLSRPT.Status == EmailRecordValid,
+		BIMI:        email.BIMI.Status == EmailRecordValid,
+		Issues:      make([]string, 0),
+	}
+
+	issue := func(format string, args ...interface{}) {
+		summary.Issues = append(summary.Issues, fmt.Sprintf(format, args...))
+	}
+
+	if spf != nil {
+		summary.SPF = spf.Status
+		switch spf.Status {
+		case SPFNone:
+			issue("no SPF record")
+		case SPFPermError, SPFTempError:
+			issue("SPF record returns %s", spf.Status)
+		}
+	}
+
+	switch {
+	case email.DMARC.Status == EmailRecordMissing:
+		issue("no DMARC record")
+	case email.DMARC.Status == EmailRecordInvalid:
+		issue("invalid DMARC record")
+	case email.DMARC.Status == EmailRecordError:
+		summary.Incomplete = true
+		issue("DMARC lookup failed")
+	case email.DMARC.Policy == "none":
+		issue("DMARC policy is none, failing mail is still delivered")
+	}
+	if email.DMARC.Status == EmailRecordValid && email.DMARC.Percentage < 100 {
+		issue("DMARC policy only applies to %d%% of mail", email.DMARC.Percentage)
+	}
+	if email.DMARC.Status == EmailRecordValid && len(email.DMARC.RUA) == 0 {
+		issue("DMARC has no rua reporting address")
+	}
+
+	for _, key := range email.DKIM {
+		switch {
+		case key.Error != "":
+			issue("DKIM selector %s: %s", key.Selector, key.Error)
+		case key.Revoked:
+		case key.KeyType == "rsa" && key.Bits < minDKIMBits:
+			issue("DKIM selector %s has a %d bit RSA key, the minimum is %d", key.Selector, key.Bits, minDKIMBits)
+		default:
+			summary.DKIM++
+		}
+		if key.Testing {
+			issue("DKIM selector %s is in testing mode", key.Selector)
+		}
+	}
+	switch {
+	case len(email.DKIMErrors) > 0:
+		summary.Incomplete = true
+		issue("DKIM lookup failed for %d of the probed selectors, keys may be missing", len(email.DKIMErrors))
+	case len(email.DKIM) == 0:
+		issue("no DKIM key found for the probed selectors")
+	}
+
+	records := []struct {
+		kind   string
+		status string
+	}{
+		{kind: "MTA-STS", status: email.MTASTS.Status},
+		{kind: "TLS-RPT", status: email.TLSRPT.Status},
+		{kind: "BIMI", status: email.BIMI.Status},
+	}
+
+	for _, record := range records {
+		switch record.status {
+		case EmailRecordInvalid:
+			issue("invalid %s record", record.kind)
+		case EmailRecordError:
+			summary.Incomplete = true
+			issue("%s lookup failed", record.kind)
+		}
+	}
+
+	return summary
+}
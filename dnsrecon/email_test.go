@@ -0,0 +1,106 @@
+package dnsrecon
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+)
+
+func TestProbeDKIMErrors(t *testing.T) {
+
+	zone := authoritative(t, []string{"test."},
+		`good._domainkey.mail.test. 300 IN TXT "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="`,
+	)
+
+	// broken fails with SERVFAIL, missing doesn't exist
+	resolver := serveDNS(t, "127.0.0.1:0", func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == "broken._domainkey.mail.test." {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			w.WriteMsg(m)
+			return
+		}
+		zone(w, r)
+	})
+
+	client := newLocalClient(t, resolver)
+	client.DKIMSelectors = []string{"good", "broken", "missing"}
+
+	keys, errs := client.probeDKIM(context.Background(), "mail.test")
+
+	if len(keys) != 1 || keys[0].Selector != "good" {
+		t.Errorf("keys %+v, want the good selector", keys)
+	}
+	if len(errs) != 1 || errs["broken"] == "" {
+		t.Fatalf("errors %v, want the broken selector", errs)
+	}
+
+	email := &EmailSecurity{DKIM: keys, DKIMErrors: errs}
+	summary := summarizeEmail(email, nil)
+
+	if !summary.Incomplete || summary.DKIM != 1 {
+		t.Errorf("summary %+v, want incomplete with 1 key", summary)
+	}
+
+	// Failed lookups don't mean the domain has no keys
+	email.DKIM = nil
+	for _, issue := range summarizeEmail(email, nil).Issues {
+		if issue == "no DKIM key found for the probed selectors" {
+			t.Errorf("missing keys reported with failed lookups")
+		}
+	}
+}
+
+func TestEmailLookupErrors(t *testing.T) {
+
+	zone := authoritative(t, []string{"test."},
+		`_dmarc.mail.test. 300 IN TXT "v=DMARC1; p=reject; rua=mailto:dmarc@mail.test"`,
+		`_smtp._tls.mail.test. 300 IN TXT "v=TLSRPTv1; rua=mailto:tls@mail.test"`,
+	)
+
+	// Every other lookup fails with SERVFAIL
+	answered := map[string]bool{"_dmarc.mail.test.": true, "_smtp._tls.mail.test.": true}
+	resolver := serveDNS(t, "127.0.0.1:0", func(w dns.ResponseWriter, r *dns.Msg) {
+		if answered[r.Question[0].Name] {
+			zone(w, r)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+
+	client := newLocalClient(t, resolver)
+	client.DKIMSelectors = []string{"default"}
+
+	email := client.checkEmailSecurity(context.Background(), "mail.test", nil)
+
+	if email.DMARC.Status != EmailRecordValid || email.TLSRPT.Status != EmailRecordValid {
+		t.Errorf("DMARC %s, TLS-RPT %s, want both valid", email.DMARC.Status, email.TLSRPT.Status)
+	}
+	if email.MTASTS.Status != EmailRecordError || email.BIMI.Status != EmailRecordError {
+		t.Errorf("MTA-STS %s, BIMI %s, want both %s", email.MTASTS.Status, email.BIMI.Status, EmailRecordError)
+	}
+
+	if !email.Summary.Incomplete {
+		t.Error("summary isn't incomplete")
+	}
+
+	for _, want := range []string{"MTA-STS lookup failed", "BIMI lookup failed"} {
+		if !containsFold(email.Summary.Issues, want) {
+			t.Errorf("issues %v don't include %q", email.Summary.Issues, want)
+		}
+	}
+	for _, issue := range email.Summary.Issues {
+		if strings.HasPrefix(issue, "invalid") {
+			t.Errorf("failed lookup reported as %q", issue)
+		}
+	}
+
+	// A failed DMARC lookup isn't an invalid record either
+	summary := summarizeEmail(&EmailSecurity{DMARC: DMARCRecord{Status: EmailRecordError}}, nil)
+	if !summary.Incomplete || !containsFold(summary.Issues, "DMARC lookup failed") || containsFold(summary.Issues, "invalid DMARC record") {
+		t.Errorf("summary %+v", summary)
+	}
+}
@@ -0,0 +1,267 @@
+package dnsrecon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// enumerationWorkers is the most candidates resolved at the same time, each with its own client from the pool
+	// The rate limiter of the dns clients still applies to every query
+	enumerationWorkers = 10
+
+	// wildcardProbes is the number of random labels used to detect wildcard records
+	wildcardProbes = 3
+)
+
+// Wildcard describes the answers returned for names that don't exist in the zone
+type Wildcard struct {
+	Type     string   `json:"type"`
+	Domain   string   `json:"domain"`
+	Wildcard bool     `json:"wildcard"`
+	A        []string `json:"a"`
+	AAAA     []string `json:"aaaa"`
+	CName    []string `json:"cname"`
+}
+
+// Subdomain is a name found by EnumerateSubdomains
+type Subdomain struct {
+	Type  string   `json:"type"`
+	Name  string   `json:"name"`
+	A     []string `json:"a"`
+	AAAA  []string `json:"aaaa"`
+	CName []string `json:"cname"`
+}
+
+// LoadWordlist reads a wordlist file with one label per line
+func LoadWordlist(filename string) ([]string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseWordlist(f)
+}
+
+// ParseWordlist reads one label per line, blank lines and lines starting with # are skipped
+func ParseWordlist(r io.Reader) ([]string, error) {
+
+	var words []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+
+		word := strings.ToLower(strings.Trim(strings.TrimSpace(scanner.Text()), "."))
+		if word == "" || strings.HasPrefix(word, "#") || seen[word] {
+			continue
+		}
+
+		if _, ok := dns.IsDomainName(word); !ok {
+			return nil, fmt.Errorf("invalid label %q in wordlist", word)
+		}
+
+		seen[word] = true
+		words = append(words, word)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("wordlist is empty")
+	}
+
+	return words, nil
+}
+
+func randomLabel() string {
+
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// DetectWildcard resolves random labels under the domain, any answers come from wildcard records
+func (client *DnsClient) DetectWildcard(ctx context.Context, domain string) Wildcard {
+
+	wildcard := Wildcard{
+		Type:   "wildcard",
+		Domain: normalizeDomain(domain),
+		A:      make([]string, 0),
+		AAAA:   make([]string, 0),
+		CName:  make([]string, 0),
+	}
+
+	for i := 0; i < wildcardProbes; i++ {
+
+		subdomain, ok := client.resolveSubdomain(ctx, randomLabel()+"."+wildcard.Domain)
+		if !ok {
+			continue
+		}
+
+		wildcard.Wildcard = true
+		wildcard.A = appendUnique(wildcard.A, subdomain.A...)
+		wildcard.AAAA = appendUnique(wildcard.AAAA, subdomain.AAAA...)
+		wildcard.CName = appendUnique(wildcard.CName, subdomain.CName...)
+	}
+
+	return wildcard
+}
+
+// matches checks whether every answer for the subdomain could have come from the wildcard
+func (wildcard Wildcard) matches(subdomain Subdomain) bool {
+
+	if !wildcard.Wildcard {
+		return false
+	}
+
+	// The CNAME target is shared by every name matching the wildcard
+	if len(subdomain.CName) > 0 {
+		return containsAll(wildcard.CName, subdomain.CName)
+	}
+
+	return containsAll(wildcard.A, subdomain.A) && containsAll(wildcard.AAAA, subdomain.AAAA)
+}
+
+// EnumerateSubdomains resolves each word under the domain and sends the names that exist to results
+// Every candidate is resolved with a client taken from the pool and put back afterwards
+// Names that only match the wildcard are filtered out, results is closed when the enumeration finishes
+func (p *Pool) EnumerateSubdomains(ctx context.Context, domain string, words []string, wildcard Wildcard, results chan<- Subdomain) {
+
+	defer close(results)
+
+	domain = normalizeDomain(domain)
+	candidates := make(chan string)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.workers(enumerationWorkers); i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for candidate := range candidates {
+
+				var subdomain Subdomain
+				var ok bool
+
+				err := p.with(ctx, func(client *DnsClient) {
+					subdomain, ok = client.resolveSubdomain(ctx, candidate)
+				})
+				if err != nil {
+					return
+				}
+				if !ok || wildcard.matches(subdomain) {
+					continue
+				}
+
+				select {
+				case results <- subdomain:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, word := range words {
+		select {
+		case candidates <- word + "." + domain:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(candidates)
+	wg.Wait()
+}
+
+// resolveSubdomain gets the A, AAAA and CNAME records of name, ok is false when it has none
+func (client *DnsClient) resolveSubdomain(ctx context.Context, name string) (Subdomain, bool) {
+
+	subdomain := Subdomain{
+		Type:  "subdomain",
+		Name:  name,
+		A:     make([]string, 0),
+		AAAA:  make([]string, 0),
+		CName: make([]string, 0),
+	}
+
+	// Most candidates don't exist so AAAA is only checked after the A lookup didn't return NXDOMAIN
+	r, err := client.query(ctx, name, dns.TypeA)
+	if err != nil && err.Error() != ErrNoData {
+		return subdomain, false
+	}
+
+	if r != nil {
+		for _, rr := range r.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				subdomain.A = append(subdomain.A, v.A.String())
+			case *dns.CNAME:
+				subdomain.CName = append(subdomain.CName, normalizeDomain(v.Target))
+			}
+		}
+	}
+
+	r, err = client.query(ctx, name, dns.TypeAAAA)
+	if err == nil {
+		for _, rr := range r.Answer {
+			if aaaa, ok := rr.(*dns.AAAA); ok {
+				subdomain.AAAA = append(subdomain.AAAA, aaaa.AAAA.String())
+			}
+		}
+	}
+
+	found := len(subdomain.A) > 0 || len(subdomain.AAAA) > 0 || len(subdomain.CName) > 0
+
+	return subdomain, found
+}
+
+func appendUnique(set []string, values ...string) []string {
+
+	for _, value := range values {
+		if !contains(set, value) {
+			set = append(set, value)
+		}
+	}
+	return set
+}
+
+func contains(set []string, value string) bool {
+
+	for _, v := range set {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(set []string, values []string) bool {
+
+	for _, value := range values {
+		if !contains(set, value) {
+			return false
+		}
+	}
+	return true
+}
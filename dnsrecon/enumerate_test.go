@@ -0,0 +1,127 @@
+package dnsrecon
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wildcardZone answers A queries under wild.test with 192.0.2.99 unless the name has its own address
+// Names under plain.test without an address don't exist
+func wildcardZone(t *testing.T) dns.HandlerFunc {
+
+	names := map[string]string{
+		"www.wild.test.":   "192.0.2.10",
+		"mail.wild.test.":  "192.0.2.11",
+		"same.wild.test.":  "192.0.2.99",
+		"www.plain.test.":  "192.0.2.20",
+		"mail.plain.test.": "192.0.2.21",
+	}
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+
+		q := r.Question[0]
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		address, ok := names[q.Name]
+		if !ok && dns.IsSubDomain("wild.test.", q.Name) {
+			address, ok = "192.0.2.99", true
+		}
+
+		switch {
+		case !ok:
+			m.Rcode = dns.RcodeNameError
+		case q.Qtype == dns.TypeA:
+			m.Answer = append(m.Answer, mustRR(t, q.Name+" 300 IN A "+address))
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestEnumerateSubdomains(t *testing.T) {
+
+	server := serveDNS(t, "127.0.0.1:0", wildcardZone(t))
+
+	var clients []*DnsClient
+	for i := 0; i < 4; i++ {
+		clients = append(clients, newLocalClient(t, server))
+	}
+	pool := NewPool(clients)
+
+	words := []string{"www", "mail", "same", "missing", "ftp"}
+
+	tests := []struct {
+		domain   string
+		wildcard bool
+		want     string
+	}{
+		// same only returns the wildcard address so it can't be told apart from a missing name
+		{domain: "wild.test", wildcard: true, want: "mail.wild.test www.wild.test"},
+		{domain: "plain.test", wildcard: false, want: "mail.plain.test www.plain.test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+
+			wildcard := clients[0].DetectWildcard(context.Background(), tt.domain)
+			if wildcard.Wildcard != tt.wildcard {
+				t.Fatalf("wildcard %+v, want %v", wildcard, tt.wildcard)
+			}
+
+			results := make(chan Subdomain)
+			go pool.EnumerateSubdomains(context.Background(), tt.domain, words, wildcard, results)
+
+			var found []string
+			for subdomain := range results {
+				found = append(found, subdomain.Name)
+			}
+			sort.Strings(found)
+
+			if strings.Join(found, " ") != tt.want {
+				t.Errorf("found %v, want %s", found, tt.want)
+			}
+		})
+	}
+
+	// Every client is back in the pool
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for i := range clients {
+		if _, err := pool.Get(ctx); err != nil {
+			t.Fatalf("%d of %d clients returned to the pool", i, len(clients))
+		}
+	}
+}
+
+func TestWildcardMatches(t *testing.T) {
+
+	wildcard := Wildcard{Wildcard: true, A: []string{"192.0.2.99"}, CName: []string{"parked.example"}}
+
+	tests := []struct {
+		name      string
+		wildcard  Wildcard
+		subdomain Subdomain
+		want      bool
+	}{
+		{name: "wildcard address", wildcard: wildcard, subdomain: Subdomain{A: []string{"192.0.2.99"}}, want: true},
+		{name: "own address", wildcard: wildcard, subdomain: Subdomain{A: []string{"192.0.2.99", "192.0.2.1"}}},
+		{name: "own IPv6 address", wildcard: wildcard, subdomain: Subdomain{AAAA: []string{"2001:db8::1"}}},
+		{name: "wildcard CNAME", wildcard: wildcard, subdomain: Subdomain{CName: []string{"parked.example"}, A: []string{"192.0.2.1"}}, want: true},
+		{name: "own CNAME", wildcard: wildcard, subdomain: Subdomain{CName: []string{"www.example"}, A: []string{"192.0.2.99"}}},
+		{name: "no wildcard", subdomain: Subdomain{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.wildcard.matches(tt.subdomain); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package dnsrecon
+
+import (
+	"context"
+	"errors"
+	"github.com/miekg/dns"
+)
+
+var (
+	// srvServices are the SRV names probed under the target domain
+	srvServices = []string{
+		"_sip._tcp",
+		"_sip._udp",
+		"_sips._tcp",
+		"_xmpp-client._tcp",
+		"_xmpp-server._tcp",
+		"_ldap._tcp",
+		"_kerberos._tcp",
+		"_autodiscover._tcp",
+		"_caldavs._tcp",
+		"_carddavs._tcp",
+		"_imaps._tcp",
+		"_submission._tcp",
+	}
+
+	// tlsaServices are the TLSA names probed under the target domain
+	tlsaServices = []string{
+		"_443._tcp",
+		"_25._tcp",
+	}
+)
+
+// The following store dns sets and error codes seen during lookups
+type caaResponse struct {
+	CAA   []CAARdata
+	Error error
+}
+
+type srvResponse struct {
+	SRV   []SRVData
+	Error error
+}
+
+type naptrResponse struct {
+	NAPTR []NAPTRRdata
+	Error error
+}
+
+type sshfpResponse struct {
+	SSHFP []SSHFPRdata
+	Error error
+}
+
+type tlsaResponse struct {
+	TLSA  []TLSAData
+	Error error
+}
+
+type svcbResponse struct {
+	SVCB  []SVCBData
+	Error error
+}
+
+type locResponse struct {
+	LOC   []LOCRdata
+	Error error
+}
+
+type hinfoResponse struct {
+	HINFO []HINFORdata
+	Error error
+}
+
+type uriResponse struct {
+	URI   []URIRdata
+	Error error
+}
+
+// query sends a single recursive query for name
+func (client *DnsClient) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	r, err := client.DnsResolver(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, errors.New(ErrNoData)
+	}
+
+	return r, nil
+}
+
+// resolveTargets looks up the A and AAAA records of each target concurrently
+func (client *DnsClient) resolveTargets(ctx context.Context, targets []string, domainData *DomainData) map[string]IpSet {
+
+	ipSets := make(map[string]IpSet)
+	var ipv4DataChannels []chan []*dns.A
+	var ipv6DataChannels []chan []*dns.AAAA
+
+	for _, target := range targets {
+
+		target = normalizeDomain(target)
+		if _, ok := ipSets[target]; ok || target == "" {
+			continue
+		}
+
+		ipv4DataChan := make(chan []*dns.A, 1)
+		ipv6DataChan := make(chan []*dns.AAAA, 1)
+		go client.getARecord(ctx, target, ipv4DataChan)
+		go client.getAAAARecord(ctx, target, ipv6DataChan)
+		ipv4DataChannels = append(ipv4DataChannels, ipv4DataChan)
+		ipv6DataChannels = append(ipv6DataChannels, ipv6DataChan)
+
+		ipSets[target] = newIpSet()
+	}
+
+	for _, ipv4DataChan := range ipv4DataChannels {
+		for ipv4Ans := range ipv4DataChan {
+			for _, ipv4 := range ipv4Ans {
+				domainData.addHostRecord(ipv4)
+				ipset, ok := ipSets[normalizeDomain(ipv4.Header().Name)]
+				if !ok {
+					continue
+				}
+				ipset.A = append(ipset.A, ipv4.A.String())
+				ipSets[normalizeDomain(ipv4.Header().Name)] = ipset
+			}
+		}
+	}
+
+	for _, ipv6DataChan := range ipv6DataChannels {
+		for ipv6Ans := range ipv6DataChan {
+			for _, ipv6 := range ipv6Ans {
+				domainData.addHostRecord(ipv6)
+				ipset, ok := ipSets[normalizeDomain(ipv6.Header().Name)]
+				if !ok {
+					continue
+				}
+				ipset.AAAA = append(ipset.AAAA, ipv6.AAAA.String())
+				ipSets[normalizeDomain(ipv6.Header().Name)] = ipset
+			}
+		}
+	}
+
+	return ipSets
+}
+
+// probeNames queries every service label under targetDomain concurrently
+// Names that don't exist are expected, only other errors are returned
+func (client *DnsClient) probeNames(ctx context.Context, targetDomain string, services []string, qtype uint16) ([]*dns.Msg, error) {
+
+	type probeResponse struct {
+		r   *dns.Msg
+		err error
+	}
+
+	probeChannels := make([]chan probeResponse, 0, len(services))
+
+	for _, service := range services {
+
+		probeChan := make(chan probeResponse, 1)
+		probeChannels = append(probeChannels, probeChan)
+
+		go func(name string, probeChan chan<- probeResponse) {
+			r, err := client.query(ctx, name, qtype)
+			probeChan <- probeResponse{r: r, err: err}
+		}(service+"."+normalizeDomain(targetDomain), probeChan)
+	}
+
+	var responses []*dns.Msg
+	var err error
+
+	for _, probeChan := range probeChannels {
+
+		response := <-probeChan
+
+		if response.err != nil {
+			if response.err.Error() != "NXDOMAIN" && response.err.Error() != ErrNoData && err == nil {
+				err = response.err
+			}
+			continue
+		}
+
+		responses = append(responses, response.r)
+	}
+
+	return responses, err
+}
+
+func (client *DnsClient) getCAA(ctx context.Context, targetDomain string, caaDataChan chan<- caaResponse, domainData *DomainData) {
+
+	defer close(caaDataChan)
+
+	var response caaResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeCAA)
+	if err != nil {
+		response.Error = err
+		caaDataChan <- response
+		return
+	}
+
+	domainData.addRecords("caa", dns.TypeCAA, r.Answer)
+
+	caaSet := make([]CAARdata, 0)
+
+	for _, caaAns := range r.Answer {
+		if caa, ok := caaAns.(*dns.CAA); ok {
+			caaSet = append(caaSet, newCAARdata(caa))
+		}
+	}
+
+	response.CAA = caaSet
+
+	caaDataChan <- response
+}
+
+func (client *DnsClient) getSRV(ctx context.Context, targetDomain string, srvDataChan chan<- srvResponse, domainData *DomainData) {
+
+	defer close(srvDataChan)
+
+	var response srvResponse
+
+	responses, err := client.probeNames(ctx, targetDomain, srvServices, dns.TypeSRV)
+	if err != nil {
+		response.Error = err
+	}
+
+	var srvSet []SRVData
+	var targets []string
+
+	for _, r := range responses {
+
+		domainData.addRecords("srv", dns.TypeSRV, r.Answer)
+
+		for _, srvAns := range r.Answer {
+			if srv, ok := srvAns.(*dns.SRV); ok {
+				srvSet = append(srvSet, SRVData{Name: normalizeDomain(srv.Header().Name), SRVRdata: newSRVRdata(srv)})
+				targets = append(targets, srv.Target)
+			}
+		}
+	}
+
+	// A target of "." means the service is not available
+	ipSets := client.resolveTargets(ctx, targets, domainData)
+
+	response.SRV = make([]SRVData, 0, len(srvSet))
+	for _, srv := range srvSet {
+		srv.Addresses = newIpSet()
+		if ipset, ok := ipSets[srv.Target]; ok {
+			srv.Addresses = ipset
+		}
+		response.SRV = append(response.SRV, srv)
+	}
+
+	srvDataChan <- response
+}
+
+func (client *DnsClient) getNAPTR(ctx context.Context, targetDomain string, naptrDataChan chan<- naptrResponse, domainData *DomainData) {
+
+	defer close(naptrDataChan)
+
+	var response naptrResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeNAPTR)
+	if err != nil {
+		response.Error = err
+		naptrDataChan <- response
+		return
+	}
+
+	domainData.addRecords("naptr", dns.TypeNAPTR, r.Answer)
+
+	naptrSet := make([]NAPTRRdata, 0)
+
+	for _, naptrAns := range r.Answer {
+		if naptr, ok := naptrAns.(*dns.NAPTR); ok {
+			naptrSet = append(naptrSet, newNAPTRRdata(naptr))
+		}
+	}
+
+	response.NAPTR = naptrSet
+
+	naptrDataChan <- response
+}
+
+func (client *DnsClient) getSSHFP(ctx context.Context, targetDomain string, sshfpDataChan chan<- sshfpResponse, domainData *DomainData) {
+
+	defer close(sshfpDataChan)
+
+	var response sshfpResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeSSHFP)
+	if err != nil {
+		response.Error = err
+		sshfpDataChan <- response
+		return
+	}
+
+	domainData.addRecords("sshfp", dns.TypeSSHFP, r.Answer)
+
+	sshfpSet := make([]SSHFPRdata, 0)
+
+	for _, sshfpAns := range r.Answer {
+		if sshfp, ok := sshfpAns.(*dns.SSHFP); ok {
+			sshfpSet = append(sshfpSet, newSSHFPRdata(sshfp))
+		}
+	}
+
+	response.SSHFP = sshfpSet
+
+	sshfpDataChan <- response
+}
+
+func (client *DnsClient) getTLSA(ctx context.Context, targetDomain string, tlsaDataChan chan<- tlsaResponse, domainData *DomainData) {
+
+	defer close(tlsaDataChan)
+
+	var response tlsaResponse
+
+	responses, err := client.probeNames(ctx, targetDomain, tlsaServices, dns.TypeTLSA)
+	if err != nil {
+		response.Error = err
+	}
+
+	tlsaSet := make([]TLSAData, 0)
+
+	for _, r := range responses {
+
+		domainData.addRecords("tlsa", dns.TypeTLSA, r.Answer)
+
+		for _, tlsaAns := range r.Answer {
+			if tlsa, ok := tlsaAns.(*dns.TLSA); ok {
+				tlsaSet = append(tlsaSet, TLSAData{Name: normalizeDomain(tlsa.Header().Name), TLSARdata: newTLSARdata(tlsa)})
+			}
+		}
+	}
+
+	response.TLSA = tlsaSet
+
+	tlsaDataChan <- response
+}
+
+// getSVCB collects HTTPS or SVCB records, they share the same rdata
+func (client *DnsClient) getSVCB(ctx context.Context, targetDomain string, qtype uint16, svcbDataChan chan<- svcbResponse, domainData *DomainData) {
+
+	defer close(svcbDataChan)
+
+	var response svcbResponse
+
+	r, err := client.query(ctx, targetDomain, qtype)
+	if err != nil {
+		response.Error = err
+		svcbDataChan <- response
+		return
+	}
+
+	set := "svcb"
+	if qtype == dns.TypeHTTPS {
+		set = "https"
+	}
+	domainData.addRecords(set, qtype, r.Answer)
+
+	var svcbSet []SVCBData
+	var targets []string
+
+	for _, svcbAns := range r.Answer {
+
+		var svcb *dns.SVCB
+
+		switch v := svcbAns.(type) {
+		case *dns.SVCB:
+			svcb = v
+		case *dns.HTTPS:
+			svcb = &v.SVCB
+		default:
+			continue
+		}
+
+		rdata := newSVCBRdata(svcb)
+
+		// A target of "." in service mode means the owner name
+		if rdata.Target == "" && svcb.Priority != 0 {
+			rdata.Target = normalizeDomain(svcb.Header().Name)
+		}
+
+		svcbSet = append(svcbSet, SVCBData{SVCBRdata: rdata})
+		targets = append(targets, rdata.Target)
+	}
+
+	ipSets := client.resolveTargets(ctx, targets, domainData)
+
+	response.SVCB = make([]SVCBData, 0, len(svcbSet))
+	for _, svcb := range svcbSet {
+		svcb.Addresses = newIpSet()
+		if ipset, ok := ipSets[svcb.Target]; ok {
+			svcb.Addresses = ipset
+		}
+		response.SVCB = append(response.SVCB, svcb)
+	}
+
+	svcbDataChan <- response
+}
+
+func (client *DnsClient) getLOC(ctx context.Context, targetDomain string, locDataChan chan<- locResponse, domainData *DomainData) {
+
+	defer close(locDataChan)
+
+	var response locResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeLOC)
+	if err != nil {
+		response.Error = err
+		locDataChan <- response
+		return
+	}
+
+	domainData.addRecords("loc", dns.TypeLOC, r.Answer)
+
+	locSet := make([]LOCRdata, 0)
+
+	for _, locAns := range r.Answer {
+		if loc, ok := locAns.(*dns.LOC); ok {
+			locSet = append(locSet, newLOCRdata(loc))
+		}
+	}
+
+	response.LOC = locSet
+
+	locDataChan <- response
+}
+
+func (client *DnsClient) getHINFO(ctx context.Context, targetDomain string, hinfoDataChan chan<- hinfoResponse, domainData *DomainData) {
+
+	defer close(hinfoDataChan)
+
+	var response hinfoResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeHINFO)
+	if err != nil {
+		response.Error = err
+		hinfoDataChan <- response
+		return
+	}
+
+	domainData.addRecords("hinfo", dns.TypeHINFO, r.Answer)
+
+	hinfoSet := make([]HINFORdata, 0)
+
+	for _, hinfoAns := range r.Answer {
+		if hinfo, ok := hinfoAns.(*dns.HINFO); ok {
+			hinfoSet = append(hinfoSet, newHINFORdata(hinfo))
+		}
+	}
+
+	response.HINFO = hinfoSet
+
+	hinfoDataChan <- response
+}
+
+func (client *DnsClient) getURI(ctx context.Context, targetDomain string, uriDataChan chan<- uriResponse, domainData *DomainData) {
+
+	defer close(uriDataChan)
+
+	var response uriResponse
+
+	r, err := client.query(ctx, targetDomain, dns.TypeURI)
+	if err != nil {
+		response.Error = err
+		uriDataChan <- response
+		return
+	}
+
+	domainData.addRecords("uri", dns.TypeURI, r.Answer)
+
+	uriSet := make([]URIRdata, 0)
+
+	for _, uriAns := range r.Answer {
+		if uri, ok := uriAns.(*dns.URI); ok {
+			uriSet = append(uriSet, newURIRdata(uri))
+		}
+	}
+
+	response.URI = uriSet
+
+	uriDataChan <- response
+}
@@ -0,0 +1,392 @@
+package dnsrecon
+
+import (
+	"context"
+	"dnsrecon/resolvers"
+	"fmt"
+	"github.com/miekg/dns"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HealthUnknown   = "unknown"
+	HealthHealthy   = "healthy"
+	HealthSlow      = "slow"
+	HealthFailing   = "failing"
+	HealthHijacking = "nxdomain_hijacking"
+	HealthTampering = "tampering"
+
+	// nxdomainProbeZone has no wildcard so random names under it must return NXDOMAIN
+	nxdomainProbeZone = "example.com"
+
+	healthCheckTimeout = time.Second * 30
+)
+
+// DefaultKnownAnswers are records that rarely change, resolvers returning something else are tampering with answers
+var DefaultKnownAnswers = []string{
+	"a.root-servers.net. A 198.41.0.4",
+	"k.root-servers.net. A 193.0.14.129",
+	"a.root-servers.net. AAAA 2001:503:ba3e::2:30",
+}
+
+// KnownAnswer is a record every resolver must return unchanged
+type KnownAnswer struct {
+	Name  string
+	Qtype uint16
+	Value string
+}
+
+// ParseKnownAnswers converts "name type value" strings, e.g. "a.root-servers.net. A 198.41.0.4"
+func ParseKnownAnswers(answers []string) ([]KnownAnswer, error) {
+
+	var knownAnswers []KnownAnswer
+
+	for _, answer := range answers {
+
+		fields := strings.Fields(answer)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid known answer %q", answer)
+		}
+
+		qtype, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("unknown record type in known answer %q", answer)
+		}
+
+		knownAnswers = append(knownAnswers, KnownAnswer{
+			Name:  fqdn(fields[0]),
+			Qtype: qtype,
+			Value: strings.Join(fields[2:], " "),
+		})
+	}
+
+	return knownAnswers, nil
+}
+
+// ResolverHealth is the result of the latest probes of a resolver
+type ResolverHealth struct {
+	Nameserver string          `json:"nameserver"`
+	Status     string          `json:"status"`
+	Reason     string          `json:"reason,omitempty"`
+	Enabled    bool            `json:"enabled"`
+	Latency    float64         `json:"latency_ms"`
+	Failures   int             `json:"consecutive_failures"`
+	Successes  int             `json:"consecutive_successes"`
+	Checked    time.Time       `json:"checked"`
+	Addresses  []AddressHealth `json:"addresses"`
+}
+
+// AddressHealth is the result of probing one address of a resolver
+type AddressHealth struct {
+	Address string  `json:"address"`
+	Status  string  `json:"status"`
+	Reason  string  `json:"reason,omitempty"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// HealthMonitor probes every resolver and keeps the unhealthy ones out of the pool until they recover
+// Resolvers returning wrong answers are disabled at once, failing and slow ones after FailureThreshold probes
+type HealthMonitor struct {
+	Pool              *Pool
+	KnownAnswers      []KnownAnswer
+	Interval          time.Duration
+	MaxLatency        time.Duration
+	FailureThreshold  int
+	RecoveryThreshold int
+	Log               *slog.Logger
+
+	mu       sync.Mutex
+	health   map[*DnsClient]*ResolverHealth
+	disabled map[*DnsClient]bool
+}
+
+// Start probes every resolver in the background, first right away and then every Interval
+// Probes can take up to healthCheckTimeout so startup doesn't wait for them
+func (h *HealthMonitor) Start() {
+
+	h.health = make(map[*DnsClient]*ResolverHealth)
+	h.disabled = make(map[*DnsClient]bool)
+
+	go func() {
+		h.check()
+		for range time.Tick(h.Interval) {
+			h.check()
+		}
+	}()
+}
+
+// Status returns the health of every resolver
+func (h *HealthMonitor) Status() []ResolverHealth {
+
+	clients := h.Pool.Clients()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := make([]ResolverHealth, 0, len(clients))
+	for _, client := range clients {
+		if health, ok := h.health[client]; ok {
+			status = append(status, *health)
+		} else {
+			status = append(status, ResolverHealth{Nameserver: client.Resolver.Nameserver, Status: HealthUnknown, Enabled: true})
+		}
+	}
+
+	return status
+}
+
+// check probes every resolver at the same time and updates which ones are disabled
+func (h *HealthMonitor) check() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	clients := h.Pool.Clients()
+	results := make([][]AddressHealth, len(clients))
+
+	var wg sync.WaitGroup
+
+	for i, client := range clients {
+
+		wg.Add(1)
+
+		go func(i int, client *DnsClient) {
+			defer wg.Done()
+
+			for _, address := range client.Resolver.Ips {
+				results[i] = append(results[i], h.probeAddress(ctx, client, address))
+			}
+		}(i, client)
+	}
+
+	wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Forget clients removed from the pool
+	current := make(map[*DnsClient]bool)
+	for _, client := range clients {
+		current[client] = true
+	}
+	for client := range h.health {
+		if !current[client] {
+			delete(h.health, client)
+			delete(h.disabled, client)
+		}
+	}
+
+	for i, client := range clients {
+		h.update(client, results[i])
+	}
+}
+
+// update records the probe results of a client, h.mu must be held
+func (h *HealthMonitor) update(client *DnsClient, addresses []AddressHealth) {
+
+	health, ok := h.health[client]
+	if !ok {
+		health = &ResolverHealth{Nameserver: client.Resolver.Nameserver, Enabled: true}
+		h.health[client] = health
+	}
+
+	health.setAddresses(addresses)
+
+	if health.Status == HealthHealthy {
+		health.Successes++
+		health.Failures = 0
+	} else {
+		health.Failures++
+		health.Successes = 0
+	}
+
+	wrongAnswers := health.Status == HealthTampering || health.Status == HealthHijacking
+
+	switch {
+	case health.Status != HealthHealthy && !h.disabled[client]:
+
+		// The first probes count like any other so a resolver that's briefly slow at startup stays enabled
+		if !wrongAnswers && health.Failures < h.FailureThreshold {
+			return
+		}
+
+		// Keep the last resolver so lookups can still be answered
+		if h.Pool.Size() == 1 {
+			h.Log.Warn("keeping unhealthy resolver as no other resolver is enabled", "resolver", health.Nameserver, "status", health.Status, "reason", health.Reason)
+			return
+		}
+
+		h.Log.Warn("disabling resolver", "resolver", health.Nameserver, "status", health.Status, "reason", health.Reason)
+		h.disabled[client] = true
+		h.Pool.Disable(client)
+		health.Enabled = false
+
+	case health.Status == HealthHealthy && h.disabled[client] && health.Successes >= h.RecoveryThreshold:
+
+		h.Log.Info("re-enabling resolver", "resolver", health.Nameserver)
+		delete(h.disabled, client)
+		h.Pool.Enable(client)
+		health.Enabled = true
+	}
+}
+
+// setAddresses sets the probe results, the resolver gets the worst status of its addresses
+func (health *ResolverHealth) setAddresses(addresses []AddressHealth) {
+
+	health.Checked = time.Now().UTC()
+	health.Addresses = addresses
+	health.Status = HealthHealthy
+	health.Reason = ""
+	health.Latency = 0
+
+	for _, address := range addresses {
+		health.Latency += address.Latency / float64(len(addresses))
+		if healthSeverity(address.Status) > healthSeverity(health.Status) {
+			health.Status = address.Status
+			health.Reason = fmt.Sprintf("%s: %s", address.Address, address.Reason)
+		}
+	}
+}
+
+// ProbeResolver probes every address of a resolver that isn't in the pool, e.g. before it's added to resolvers.yaml
+// Each query waits up to timeout
+func (h *HealthMonitor) ProbeResolver(ctx context.Context, resolver *resolvers.Resolver, timeout time.Duration) ResolverHealth {
+
+	client := NewDnsClient()
+	client.Resolver = resolver
+	client.Log = h.Log
+	client.Timeout = timeout
+	client.Start()
+
+	var addresses []AddressHealth
+	for _, address := range resolver.Ips {
+		addresses = append(addresses, h.probeAddress(ctx, client, address))
+	}
+
+	health := ResolverHealth{Nameserver: resolver.Nameserver, Enabled: resolver.Enable}
+	health.setAddresses(addresses)
+
+	return health
+}
+
+// probeAddress checks the known answers and that a random name returns NXDOMAIN through one address
+func (h *HealthMonitor) probeAddress(ctx context.Context, client *DnsClient, address string) (health AddressHealth) {
+
+	health = AddressHealth{Address: address, Status: HealthHealthy}
+
+	var total time.Duration
+	var queries int
+
+	defer func() {
+		health.Latency = float64(total) / float64(queries) / float64(time.Millisecond)
+	}()
+
+	for _, known := range h.KnownAnswers {
+
+		r, rtt, err := h.probe(ctx, client, address, known.Name, known.Qtype)
+		total += rtt
+		queries++
+
+		if err != nil {
+			health.Status, health.Reason = HealthFailing, err.Error()
+			return health
+		}
+
+		var values []string
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == known.Qtype {
+				values = append(values, strings.TrimPrefix(rr.String(), rr.Header().String()))
+			}
+		}
+
+		if len(values) == 0 {
+			health.Status, health.Reason = HealthFailing, fmt.Sprintf("no %s records for %s", dns.TypeToString[known.Qtype], known.Name)
+			return health
+		}
+
+		if !containsFold(values, known.Value) {
+			health.Status = HealthTampering
+			health.Reason = fmt.Sprintf("%s %s returned %s, expected %s", known.Name, dns.TypeToString[known.Qtype], strings.Join(values, ", "), known.Value)
+			return health
+		}
+	}
+
+	name := randomLabel() + "." + nxdomainProbeZone
+	r, rtt, err := h.probe(ctx, client, address, name, dns.TypeA)
+	total += rtt
+	queries++
+
+	switch {
+	case err != nil:
+		health.Status, health.Reason = HealthFailing, err.Error()
+		return health
+
+	case r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0:
+		health.Status = HealthHijacking
+		health.Reason = fmt.Sprintf("%s returned %s instead of NXDOMAIN", name, strings.TrimPrefix(r.Answer[0].String(), r.Answer[0].Header().String()))
+		return health
+
+	case r.Rcode != dns.RcodeNameError:
+		health.Status, health.Reason = HealthFailing, fmt.Sprintf("%s returned %s instead of NXDOMAIN", name, dns.RcodeToString[r.Rcode])
+		return health
+	}
+
+	if average := total / time.Duration(queries); h.MaxLatency > 0 && average > h.MaxLatency {
+		health.Status, health.Reason = HealthSlow, fmt.Sprintf("average latency %dms", average.Milliseconds())
+	}
+
+	return health
+}
+
+// probe sends a query straight to the address, the cache is skipped
+// NXDOMAIN is returned as a response so hijacking can be told apart from failures
+func (h *HealthMonitor) probe(ctx context.Context, client *DnsClient, address string, name string, qtype uint16) (*dns.Msg, time.Duration, error) {
+
+	if err := client.RatelimitRequests(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	r, rtt, err := client.dns.ExchangeContext(ctx, m, address)
+	if err != nil {
+		return nil, rtt, err
+	}
+
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, rtt, fmt.Errorf("%s %s returned %s", name, dns.TypeToString[qtype], dns.RcodeToString[r.Rcode])
+	}
+
+	return r, rtt, nil
+}
+
+func healthSeverity(status string) int {
+
+	switch status {
+	case HealthSlow:
+		return 1
+	case HealthFailing:
+		return 2
+	case HealthHijacking:
+		return 3
+	case HealthTampering:
+		return 4
+	}
+
+	return 0
+}
+
+func containsFold(set []string, value string) bool {
+
+	for _, v := range set {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
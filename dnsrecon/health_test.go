@@ -0,0 +1,112 @@
+package dnsrecon
+
+import (
+	"context"
+	"dnsrecon/resolvers"
+	"github.com/miekg/dns"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestHealthUpdateThreshold(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		status   string
+		disabled []bool
+	}{
+		{name: "failing", status: HealthFailing, disabled: []bool{false, false, true}},
+		{name: "slow", status: HealthSlow, disabled: []bool{false, false, true}},
+		{name: "tampering", status: HealthTampering, disabled: []bool{true}},
+		{name: "hijacking", status: HealthHijacking, disabled: []bool{true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			client := newLocalClient(t, "127.0.0.1:53")
+			other := newLocalClient(t, "127.0.0.1:53")
+
+			h := &HealthMonitor{
+				Pool:              NewPool([]*DnsClient{client, other}),
+				FailureThreshold:  3,
+				RecoveryThreshold: 2,
+				Log:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+				health:            make(map[*DnsClient]*ResolverHealth),
+				disabled:          make(map[*DnsClient]bool),
+			}
+
+			// Every probe counts towards the threshold, the first one included
+			for i, disabled := range tt.disabled {
+				h.update(client, []AddressHealth{{Address: "127.0.0.1:53", Status: tt.status}})
+				if h.disabled[client] != disabled {
+					t.Fatalf("probe %d: disabled = %v, want %v", i+1, h.disabled[client], disabled)
+				}
+			}
+
+			for i := 0; i < h.RecoveryThreshold; i++ {
+				h.update(client, []AddressHealth{{Address: "127.0.0.1:53", Status: HealthHealthy}})
+			}
+			if h.disabled[client] || h.Pool.Size() != 2 {
+				t.Errorf("not re-enabled after %d healthy probes", h.RecoveryThreshold)
+			}
+		})
+	}
+}
+
+func TestProbeResolver(t *testing.T) {
+
+	// answerWith returns the A record for probe.test and NXDOMAIN for every other name
+	answerWith := func(value string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if r.Question[0].Name == "probe.test." {
+				m.Answer = append(m.Answer, mustRR(t, "probe.test. 300 IN A "+value))
+			} else {
+				m.Rcode = dns.RcodeNameError
+			}
+			w.WriteMsg(m)
+		}
+	}
+
+	hijack := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, mustRR(t, r.Question[0].Name+" 300 IN A 192.0.2.1"))
+		w.WriteMsg(m)
+	}
+
+	answers, err := ParseKnownAnswers([]string{"probe.test. A 192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HealthMonitor{KnownAnswers: answers, Log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	tests := []struct {
+		name    string
+		address string
+		status  string
+	}{
+		{name: "healthy", address: serveDNS(t, "127.0.0.1:0", answerWith("192.0.2.1")), status: HealthHealthy},
+		{name: "wrong answer", address: serveDNS(t, "127.0.0.1:0", answerWith("192.0.2.99")), status: HealthTampering},
+		{name: "hijacking", address: serveDNS(t, "127.0.0.1:0", hijack), status: HealthHijacking},
+		{name: "no response", address: closedAddress(t), status: HealthFailing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resolver := &resolvers.Resolver{Nameserver: tt.name, Ips: []string{tt.address}, Ratelimit: 100, Enable: true}
+
+			health := h.ProbeResolver(context.Background(), resolver, 200*time.Millisecond)
+
+			if health.Status != tt.status {
+				t.Errorf("status = %s (%s), want %s", health.Status, health.Reason, tt.status)
+			}
+		})
+	}
+}
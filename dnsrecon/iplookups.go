@@ -1,11 +1,11 @@
 package dnsrecon
 
 import (
+	"context"
 	"github.com/miekg/dns"
-	"time"
 )
 
-func (client *DnsClient) getARecord(domain string, ipv4DataChan chan<- []*dns.A) {
+func (client *DnsClient) getARecord(ctx context.Context, domain string, ipv4DataChan chan<- []*dns.A) {
 
 	defer close(ipv4DataChan)
 
@@ -13,11 +13,13 @@ func (client *DnsClient) getARecord(domain string, ipv4DataChan chan<- []*dns.A)
 	m.SetQuestion(fqdn(domain), dns.TypeA)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
-		// Retry in 500ms
-		time.Sleep(time.Millisecond * 500)
-		r, err = client.DnsResolver(m)
+		// Retry after a short delay
+		if client.waitRetry(ctx) != nil {
+			return
+		}
+		r, err = client.DnsResolver(ctx, m)
 		if err != nil {
 			return
 		}
@@ -39,7 +41,7 @@ func (client *DnsClient) getARecord(domain string, ipv4DataChan chan<- []*dns.A)
 	ipv4DataChan <- aset
 }
 
-func (client *DnsClient) getAAAARecord(domain string, ipv6DataChan chan<- []*dns.AAAA) {
+func (client *DnsClient) getAAAARecord(ctx context.Context, domain string, ipv6DataChan chan<- []*dns.AAAA) {
 
 	defer close(ipv6DataChan)
 
@@ -47,11 +49,13 @@ func (client *DnsClient) getAAAARecord(domain string, ipv6DataChan chan<- []*dns
 	m.SetQuestion(fqdn(domain), dns.TypeAAAA)
 	m.RecursionDesired = true
 
-	r, err := client.DnsResolver(m)
+	r, err := client.DnsResolver(ctx, m)
 	if err != nil {
-		// Retry in 500ms
-		time.Sleep(time.Millisecond * 500)
-		r, err = client.DnsResolver(m)
+		// Retry after a short delay
+		if client.waitRetry(ctx) != nil {
+			return
+		}
+		r, err = client.DnsResolver(ctx, m)
 		if err != nil {
 			return
 		}
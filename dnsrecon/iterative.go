@@ -0,0 +1,504 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuthoritativePort = "53"
+
+	// Limits that stop an iterative lookup looping through bad delegations
+	maxTraceQueries = 64
+	maxTraceCNAMEs  = 8
+	maxTraceDepth   = 4
+)
+
+// DefaultRootHints are the IPv4 addresses of the root servers
+var DefaultRootHints = []string{
+	"a.root-servers.net. 198.41.0.4",
+	"b.root-servers.net. 170.247.170.2",
+	"c.root-servers.net. 192.33.4.12",
+	"d.root-servers.net. 199.7.91.13",
+	"e.root-servers.net. 192.203.230.10",
+	"f.root-servers.net. 192.5.5.241",
+	"g.root-servers.net. 192.112.36.4",
+	"h.root-servers.net. 198.97.190.53",
+	"i.root-servers.net. 192.36.148.17",
+	"j.root-servers.net. 192.58.128.30",
+	"k.root-servers.net. 193.0.14.129",
+	"l.root-servers.net. 199.7.83.42",
+	"m.root-servers.net. 202.12.27.33",
+}
+
+// RootHint is a root server the iterative lookups start from
+type RootHint struct {
+	Name    string
+	Address string
+}
+
+// ParseRootHints converts "name address" strings, the address can include a port, e.g. "a.root-servers.net. 198.41.0.4"
+func ParseRootHints(hints []string) ([]RootHint, error) {
+
+	var rootHints []RootHint
+
+	for _, hint := range hints {
+
+		fields := strings.Fields(hint)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid root hint %q", hint)
+		}
+
+		host, port, err := net.SplitHostPort(fields[1])
+		if err != nil {
+			host, port = fields[1], defaultAuthoritativePort
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid address in root hint %q", hint)
+		}
+
+		rootHints = append(rootHints, RootHint{
+			Name:    fqdn(fields[0]),
+			Address: net.JoinHostPort(host, port),
+		})
+	}
+
+	return rootHints, nil
+}
+
+// Trace is the delegation path of an iterative lookup from the root hints to the authoritative answer, like dig +trace
+type Trace struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	Answer    []Record    `json:"answer"`
+	Steps     []TraceStep `json:"steps"`
+	Duration  float64     `json:"duration_ms"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// TraceStep is a query sent to one nameserver of a zone
+// Name differs from the traced name after following a CNAME
+type TraceStep struct {
+	Zone          string    `json:"zone"`
+	Name          string    `json:"name"`
+	Server        string    `json:"server"`
+	Address       string    `json:"address"`
+	Rcode         string    `json:"rcode"`
+	Error         string    `json:"error,omitempty"`
+	RTT           float64   `json:"rtt_ms"`
+	Authoritative bool      `json:"authoritative"`
+	Answer        []Record  `json:"answer"`
+	Referral      *Referral `json:"referral,omitempty"`
+}
+
+// Referral delegates the name to the nameservers of a child zone
+// Resolved has the addresses of nameservers without glue, found with a separate iterative lookup
+type Referral struct {
+	Zone        string              `json:"zone"`
+	Nameservers []string            `json:"nameservers"`
+	Glue        []Record            `json:"glue"`
+	Resolved    map[string][]string `json:"resolved,omitempty"`
+}
+
+// authServer is a nameserver address a zone was delegated to
+type authServer struct {
+	name    string
+	address string
+}
+
+// iteration holds the state shared by the lookups of a trace, including those of glueless nameservers
+type iteration struct {
+	client  *DnsClient
+	queries int
+}
+
+// Trace resolves the name from the root hints without recursion, following referrals and CNAMEs
+func (client *DnsClient) Trace(ctx context.Context, name string, qtype uint16) *Trace {
+
+	trace := &Trace{
+		Name:      normalizeDomain(name),
+		Type:      dns.TypeToString[qtype],
+		Answer:    make([]Record, 0),
+		Steps:     make([]TraceStep, 0),
+		Timestamp: time.Now().UTC(),
+	}
+
+	start := time.Now()
+	defer func() {
+		trace.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+	}()
+
+	if len(client.RootHints) == 0 {
+		trace.Status = "ERROR"
+		trace.Error = "no root hints configured"
+		return trace
+	}
+
+	it := &iteration{client: client}
+
+	answer, rcode, err := it.resolve(ctx, fqdn(name), qtype, 0, trace)
+
+	for _, rr := range answer {
+		trace.Answer = append(trace.Answer, newRecord(rr))
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		trace.Status = contextError(ctx).Error()
+	case err != nil:
+		trace.Status = "ERROR"
+		trace.Error = err.Error()
+	default:
+		trace.Status = dns.RcodeToString[rcode]
+	}
+
+	return trace
+}
+
+// resolve follows the CNAMEs of the name, every target is looked up again from the root
+// The steps are only added to trace when it's set
+func (it *iteration) resolve(ctx context.Context, qname string, qtype uint16, depth int, trace *Trace) ([]dns.RR, int, error) {
+
+	var answer []dns.RR
+
+	for cnames := 0; ; cnames++ {
+
+		rrs, rcode, target, err := it.walk(ctx, qname, qtype, depth, trace)
+		answer = append(answer, rrs...)
+
+		if err != nil || target == "" {
+			return answer, rcode, err
+		}
+
+		if cnames == maxTraceCNAMEs {
+			return answer, rcode, fmt.Errorf("more than %d CNAMEs", maxTraceCNAMEs)
+		}
+
+		qname = target
+	}
+}
+
+// walk queries the root hints and then the nameservers of every referral until one answers for the name
+// It returns the CNAME target when the answer ends in a CNAME pointing out of the response
+func (it *iteration) walk(ctx context.Context, qname string, qtype uint16, depth int, trace *Trace) ([]dns.RR, int, string, error) {
+
+	zone := "."
+
+	servers := make([]authServer, 0, len(it.client.RootHints))
+	for _, hint := range it.client.RootHints {
+		servers = append(servers, authServer{name: hint.Name, address: hint.Address})
+	}
+
+	for {
+
+		r, step, err := it.query(ctx, zone, servers, qname, qtype, trace)
+		if err != nil {
+			return nil, 0, "", err
+		}
+
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, r.Rcode, "", nil
+		}
+
+		answer, target := answerChain(r, qname, qtype)
+		if len(answer) > 0 {
+			return answer, r.Rcode, target, nil
+		}
+
+		if r.Authoritative {
+			return nil, r.Rcode, "", nil
+		}
+
+		referral, next := it.referral(ctx, r, zone, qname, depth)
+		if step != nil {
+			step.Referral = referral
+		}
+
+		if len(next) == 0 {
+			return nil, 0, "", fmt.Errorf("no addresses for the %s nameservers", normalizeDomain(referral.Zone))
+		}
+
+		zone = fqdn(referral.Zone)
+		servers = next
+	}
+}
+
+// query asks the servers of the zone in order until one returns an answer, a referral or NXDOMAIN
+// The step of the response that's used is returned so the referral can be added to it
+func (it *iteration) query(ctx context.Context, zone string, servers []authServer, qname string, qtype uint16, trace *Trace) (*dns.Msg, *TraceStep, error) {
+
+	rcode := ""
+
+	for _, server := range servers {
+
+		if it.queries == maxTraceQueries {
+			return nil, nil, fmt.Errorf("more than %d queries", maxTraceQueries)
+		}
+		it.queries++
+
+		r, rtt, err := it.client.queryAuthoritative(ctx, server.address, qname, qtype)
+		if ctx.Err() != nil {
+			return nil, nil, contextError(ctx)
+		}
+
+		step := TraceStep{
+			Zone:    zoneName(zone),
+			Name:    normalizeDomain(qname),
+			Server:  normalizeDomain(server.name),
+			Address: server.address,
+			Rcode:   queryRcode(r, err),
+			RTT:     float64(rtt) / float64(time.Millisecond),
+			Answer:  make([]Record, 0),
+		}
+		rcode = step.Rcode
+
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Authoritative = r.Authoritative
+			for _, rr := range r.Answer {
+				step.Answer = append(step.Answer, newRecord(rr))
+			}
+		}
+
+		usable := err == nil && usableResponse(r, zone, qname)
+		if err == nil && !usable && step.Rcode == dns.RcodeToString[dns.RcodeSuccess] {
+			step.Error = "lame response"
+		}
+
+		if trace == nil {
+			if usable {
+				return r, nil, nil
+			}
+			continue
+		}
+
+		trace.Steps = append(trace.Steps, step)
+		if usable {
+			return r, &trace.Steps[len(trace.Steps)-1], nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("no usable response from the %s nameservers, last %s", zoneName(zone), rcode)
+}
+
+// zoneName keeps the root zone as "."
+func zoneName(zone string) string {
+
+	if zone == "." {
+		return zone
+	}
+
+	return normalizeDomain(zone)
+}
+
+// usableResponse accepts NXDOMAIN, answers and referrals to a zone below the one that was asked
+func usableResponse(r *dns.Msg, zone string, qname string) bool {
+
+	switch r.Rcode {
+	case dns.RcodeNameError:
+		return true
+	case dns.RcodeSuccess:
+	default:
+		return false
+	}
+
+	if len(r.Answer) > 0 || r.Authoritative {
+		return true
+	}
+
+	return delegatedZone(r, zone, qname) != ""
+}
+
+// delegatedZone returns the child zone of the NS records in the authority section
+// Referrals to the same zone or outside it are lame and ignored
+func delegatedZone(r *dns.Msg, zone string, qname string) string {
+
+	for _, rr := range r.Ns {
+
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		child := fqdn(ns.Hdr.Name)
+		if child != zone && dns.IsSubDomain(zone, child) && dns.IsSubDomain(child, qname) {
+			return child
+		}
+	}
+
+	return ""
+}
+
+// referral collects the nameservers and glue of the child zone
+// Glue outside the zone that sent it isn't trusted, nameservers without glue are looked up from the root
+func (it *iteration) referral(ctx context.Context, r *dns.Msg, zone string, qname string, depth int) (*Referral, []authServer) {
+
+	child := delegatedZone(r, zone, qname)
+
+	referral := &Referral{
+		Zone:        normalizeDomain(child),
+		Nameservers: make([]string, 0),
+		Glue:        make([]Record, 0),
+	}
+
+	for _, rr := range r.Ns {
+		if ns, ok := rr.(*dns.NS); ok && fqdn(ns.Hdr.Name) == child {
+			referral.Nameservers = append(referral.Nameservers, fqdn(ns.Ns))
+		}
+	}
+
+	var servers, servers6 []authServer
+
+	for _, nameserver := range referral.Nameservers {
+
+		if !dns.IsSubDomain(zone, nameserver) {
+			continue
+		}
+
+		for _, rr := range r.Extra {
+
+			if fqdn(rr.Header().Name) != nameserver {
+				continue
+			}
+
+			switch v := rr.(type) {
+			case *dns.A:
+				servers = append(servers, authServer{name: nameserver, address: it.client.authoritativeAddress(v.A)})
+			case *dns.AAAA:
+				servers6 = append(servers6, authServer{name: nameserver, address: it.client.authoritativeAddress(v.AAAA)})
+			default:
+				continue
+			}
+
+			referral.Glue = append(referral.Glue, newRecord(rr))
+		}
+	}
+
+	// IPv6 is tried last as it's often unreachable
+	servers = append(servers, servers6...)
+
+	if len(servers) == 0 && depth < maxTraceDepth {
+
+		for _, nameserver := range referral.Nameservers {
+
+			answer, _, err := it.resolve(ctx, nameserver, dns.TypeA, depth+1, nil)
+			if err != nil {
+				continue
+			}
+
+			for _, rr := range answer {
+				if a, ok := rr.(*dns.A); ok {
+					if referral.Resolved == nil {
+						referral.Resolved = make(map[string][]string)
+					}
+					referral.Resolved[normalizeDomain(nameserver)] = append(referral.Resolved[normalizeDomain(nameserver)], a.A.String())
+					servers = append(servers, authServer{name: nameserver, address: it.client.authoritativeAddress(a.A)})
+				}
+			}
+
+			if len(servers) > 0 {
+				break
+			}
+		}
+	}
+
+	for i, nameserver := range referral.Nameservers {
+		referral.Nameservers[i] = normalizeDomain(nameserver)
+	}
+
+	return referral, servers
+}
+
+// answerChain returns the records of the type and the CNAMEs leading to them
+// The target is set when the last CNAME points to a name without records in the response
+func answerChain(r *dns.Msg, qname string, qtype uint16) ([]dns.RR, string) {
+
+	var answer []dns.RR
+
+	name := qname
+
+	for i := 0; i <= maxTraceCNAMEs; i++ {
+
+		var cname string
+		found := false
+
+		for _, rr := range r.Answer {
+
+			if !strings.EqualFold(rr.Header().Name, name) {
+				continue
+			}
+
+			switch {
+			case rr.Header().Rrtype == qtype:
+				answer = append(answer, rr)
+				found = true
+			case rr.Header().Rrtype == dns.TypeCNAME && cname == "":
+				answer = append(answer, rr)
+				cname = fqdn(rr.(*dns.CNAME).Target)
+			}
+		}
+
+		if found {
+			return answer, ""
+		}
+
+		if cname == "" {
+			if name == qname {
+				return nil, ""
+			}
+			return answer, name
+		}
+
+		name = cname
+	}
+
+	return answer, ""
+}
+
+// queryAuthoritative sends a non-recursive query to a nameserver, repeating it over TCP when the response is truncated
+func (client *DnsClient) queryAuthoritative(ctx context.Context, address string, qname string, qtype uint16) (*dns.Msg, time.Duration, error) {
+
+	if err := client.RatelimitRequests(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(4096, false)
+
+	start := time.Now()
+
+	r, _, err := client.dns.ExchangeContext(ctx, m, address)
+
+	tcp := err == nil && r.Truncated
+	if tcp {
+		r, _, err = client.tcp.ExchangeContext(ctx, m, address)
+	}
+
+	rtt := time.Since(start)
+
+	client.Log.DebugContext(ctx, "dns query",
+		"server", address,
+		"qname", qname,
+		"qtype", dns.TypeToString[qtype],
+		"rcode", queryRcode(r, err),
+		"latency_ms", float64(rtt)/float64(time.Millisecond),
+		"tcp", tcp,
+		"iterative", true,
+	)
+
+	return r, rtt, err
+}
+
+// authoritativeAddress joins the address of a nameserver found through a referral with AuthoritativePort
+func (client *DnsClient) authoritativeAddress(ip net.IP) string {
+	return net.JoinHostPort(ip.String(), client.AuthoritativePort)
+}
@@ -0,0 +1,254 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"testing"
+)
+
+// authoritative answers for the zones of its apexes from records, like a nameserver without recursion
+// NS records below an apex are delegations and get a referral with any glue in records
+func authoritative(t *testing.T, apexes []string, records ...string) dns.HandlerFunc {
+
+	var rrs []dns.RR
+	for _, record := range records {
+		rrs = append(rrs, mustRR(t, record))
+	}
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+
+		q := r.Question[0]
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		apex := ""
+		for _, a := range apexes {
+			if dns.IsSubDomain(a, q.Name) && len(a) > len(apex) {
+				apex = a
+			}
+		}
+
+		cut := ""
+		for _, rr := range rrs {
+			name := rr.Header().Name
+			if rr.Header().Rrtype == dns.TypeNS && name != apex && dns.IsSubDomain(apex, name) && dns.IsSubDomain(name, q.Name) && len(name) > len(cut) {
+				cut = name
+			}
+		}
+
+		if cut != "" {
+			for _, rr := range rrs {
+				if ns, ok := rr.(*dns.NS); ok && rr.Header().Name == cut {
+					m.Ns = append(m.Ns, rr)
+					for _, glue := range rrs {
+						if glue.Header().Name == ns.Ns && glue.Header().Rrtype == dns.TypeA {
+							m.Extra = append(m.Extra, glue)
+						}
+					}
+				}
+			}
+			w.WriteMsg(m)
+			return
+		}
+
+		m.Authoritative = true
+		exists := false
+		for _, rr := range rrs {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				exists = true
+				if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+		}
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+// lame answers without authority, records or a referral, like a server that isn't set up for the zone
+func lame(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	w.WriteMsg(m)
+}
+
+// newTraceClient serves the root at 127.0.0.1, test. at 127.0.0.2, the zones below it at 127.0.0.3 and a lame server at 127.0.0.4
+// Every server listens on the same port, which is the client's AuthoritativePort
+func newTraceClient(t *testing.T) *DnsClient {
+
+	root := serveDNS(t, "127.0.0.1:0", authoritative(t, []string{"."},
+		"test. 300 IN NS ns.nic.test.",
+		"ns.nic.test. 300 IN A 127.0.0.2",
+	))
+
+	_, port, _ := net.SplitHostPort(root)
+
+	serveDNS(t, "127.0.0.2:"+port, authoritative(t, []string{"test."},
+		"example.test. 300 IN NS ns1.example.test.",
+		"ns1.example.test. 300 IN A 127.0.0.3",
+		"hosting.test. 300 IN NS ns1.example.test.",
+		"glueless.test. 300 IN NS ns.hosting.test.",
+		"lame.test. 300 IN NS ns.lame.test.",
+		"lame.test. 300 IN NS ns1.example.test.",
+		"ns.lame.test. 300 IN A 127.0.0.4",
+		"loop1.test. 300 IN NS ns.loop2.test.",
+		"loop2.test. 300 IN NS ns.loop1.test.",
+	))
+
+	serveDNS(t, "127.0.0.3:"+port, authoritative(t, []string{"example.test.", "hosting.test.", "glueless.test.", "lame.test."},
+		"example.test. 300 IN A 192.0.2.1",
+		"www.example.test. 300 IN CNAME web.glueless.test.",
+		"a.example.test. 300 IN CNAME b.example.test.",
+		"b.example.test. 300 IN CNAME a.example.test.",
+		"ns.hosting.test. 300 IN A 127.0.0.3",
+		"glueless.test. 300 IN A 192.0.2.2",
+		"web.glueless.test. 300 IN A 192.0.2.3",
+		"lame.test. 300 IN A 192.0.2.4",
+	))
+
+	serveDNS(t, "127.0.0.4:"+port, lame)
+
+	hints, err := ParseRootHints([]string{"ns.root.test. " + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newLocalClient(t, root)
+	client.RootHints = hints
+	client.AuthoritativePort = port
+
+	return client
+}
+
+// traceAnswer returns the types and data of the answer records
+func traceAnswer(trace *Trace) string {
+
+	var data []string
+	for _, record := range trace.Answer {
+		data = append(data, fmt.Sprintf("%s %v", record.Type, record.Data))
+	}
+
+	return strings.Join(data, ", ")
+}
+
+func TestTraceReferrals(t *testing.T) {
+
+	trace := newTraceClient(t).Trace(context.Background(), "example.test", dns.TypeA)
+
+	if trace.Status != "NOERROR" || traceAnswer(trace) != "A {192.0.2.1}" {
+		t.Fatalf("status %s (%s), answer %s", trace.Status, trace.Error, traceAnswer(trace))
+	}
+
+	var zones []string
+	for _, step := range trace.Steps {
+		zones = append(zones, step.Zone)
+	}
+	if strings.Join(zones, " ") != ". test example.test" {
+		t.Fatalf("zones %v, want . test example.test", zones)
+	}
+
+	if referral := trace.Steps[0].Referral; referral == nil || referral.Zone != "test" || len(referral.Glue) != 1 {
+		t.Errorf("root referral %+v", referral)
+	}
+	if referral := trace.Steps[1].Referral; referral == nil || referral.Zone != "example.test" || referral.Nameservers[0] != "ns1.example.test" {
+		t.Errorf("test referral %+v", referral)
+	}
+	if last := trace.Steps[2]; !last.Authoritative || last.Referral != nil {
+		t.Errorf("last step %+v", last)
+	}
+}
+
+func TestTraceNXDOMAIN(t *testing.T) {
+
+	trace := newTraceClient(t).Trace(context.Background(), "missing.example.test", dns.TypeA)
+
+	if trace.Status != "NXDOMAIN" || len(trace.Answer) != 0 {
+		t.Errorf("status %s (%s), answer %s", trace.Status, trace.Error, traceAnswer(trace))
+	}
+}
+
+func TestTraceLameServer(t *testing.T) {
+
+	trace := newTraceClient(t).Trace(context.Background(), "lame.test", dns.TypeA)
+
+	if trace.Status != "NOERROR" || traceAnswer(trace) != "A {192.0.2.4}" {
+		t.Fatalf("status %s (%s), answer %s", trace.Status, trace.Error, traceAnswer(trace))
+	}
+
+	// The lame server is asked first and the next nameserver of the zone answers
+	steps := trace.Steps[len(trace.Steps)-2:]
+	if steps[0].Server != "ns.lame.test" || steps[0].Error != "lame response" {
+		t.Errorf("lame step %+v", steps[0])
+	}
+	if steps[1].Server != "ns1.example.test" || !steps[1].Authoritative {
+		t.Errorf("answer step %+v", steps[1])
+	}
+}
+
+func TestTraceGluelessReferral(t *testing.T) {
+
+	trace := newTraceClient(t).Trace(context.Background(), "glueless.test", dns.TypeA)
+
+	if trace.Status != "NOERROR" || traceAnswer(trace) != "A {192.0.2.2}" {
+		t.Fatalf("status %s (%s), answer %s", trace.Status, trace.Error, traceAnswer(trace))
+	}
+
+	referral := trace.Steps[1].Referral
+	if referral == nil || referral.Zone != "glueless.test" || len(referral.Glue) != 0 {
+		t.Fatalf("referral %+v", referral)
+	}
+	if resolved := referral.Resolved["ns.hosting.test"]; len(resolved) != 1 || resolved[0] != "127.0.0.3" {
+		t.Errorf("resolved nameservers %v", referral.Resolved)
+	}
+
+	// The lookup of the nameserver isn't part of the trace
+	if len(trace.Steps) != 3 {
+		t.Errorf("%d steps, want 3", len(trace.Steps))
+	}
+}
+
+func TestTraceCNAMEChain(t *testing.T) {
+
+	trace := newTraceClient(t).Trace(context.Background(), "www.example.test", dns.TypeA)
+
+	if trace.Status != "NOERROR" || traceAnswer(trace) != "CNAME {web.glueless.test}, A {192.0.2.3}" {
+		t.Fatalf("status %s (%s), answer %s", trace.Status, trace.Error, traceAnswer(trace))
+	}
+
+	// The target is traced again from the root
+	last := trace.Steps[len(trace.Steps)-1]
+	if last.Name != "web.glueless.test" || last.Zone != "glueless.test" {
+		t.Errorf("last step %+v", last)
+	}
+}
+
+func TestTraceLoops(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		error string
+	}{
+		{name: "a.example.test", error: "more than 8 CNAMEs"},
+		{name: "loop1.test", error: "no addresses for the loop1.test nameservers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			trace := newTraceClient(t).Trace(context.Background(), tt.name, dns.TypeA)
+
+			if trace.Status != "ERROR" || trace.Error != tt.error {
+				t.Errorf("status %s (%s), want ERROR (%s)", trace.Status, trace.Error, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package dnsrecon
+
+import (
+	"dnsrecon/metrics"
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+)
+
+var (
+	queriesTotal = metrics.NewCounterVec("dnsrecon_dns_queries_total", "DNS queries sent by resolver and response code, TIMEOUT and ERROR when no response was received", "resolver", "rcode")
+
+	queryRetriesTotal = metrics.NewCounterVec("dnsrecon_dns_query_retries_total", "DNS query attempts after the first one of a query by resolver", "resolver")
+
+	lookupRetriesTotal = metrics.NewCounterVec("dnsrecon_lookup_retries_total", "Lookups retried after a failure by resolver", "resolver")
+
+	ratelimitWait = metrics.NewHistogramVec("dnsrecon_ratelimit_wait_seconds", "Time queries waited for the rate limit of the resolver", metrics.DefBuckets, "resolver")
+)
+
+// RegisterCacheMetrics exposes the counters of the cache, the hit ratio covers every lookup since startup
+func RegisterCacheMetrics(cache *DnsCache) {
+
+	metrics.NewCounterFunc("dnsrecon_cache_hits_total", "DNS cache hits", func() float64 {
+		return float64(cache.Stats().Hits)
+	})
+	metrics.NewCounterFunc("dnsrecon_cache_misses_total", "DNS cache misses including expired entries", func() float64 {
+		return float64(cache.Stats().Misses)
+	})
+	metrics.NewCounterFunc("dnsrecon_cache_evictions_total", "DNS cache entries evicted before their TTL expired", func() float64 {
+		return float64(cache.Stats().Evictions)
+	})
+	metrics.NewGaugeFunc("dnsrecon_cache_entries", "DNS responses in the cache", func() float64 {
+		return float64(cache.Stats().Size)
+	})
+	metrics.NewGaugeFunc("dnsrecon_cache_hit_ratio", "DNS cache hits divided by lookups", func() float64 {
+		stats := cache.Stats()
+		if stats.Hits+stats.Misses == 0 {
+			return 0
+		}
+		return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	})
+}
+
+// queryRcode names the response code of a query, TIMEOUT and ERROR when there's no response
+func queryRcode(r *dns.Msg, err error) string {
+
+	rcode := "ERROR"
+
+	switch {
+	case err != nil:
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			rcode = "TIMEOUT"
+		}
+	case r != nil:
+		if s, ok := dns.RcodeToString[r.Rcode]; ok {
+			rcode = s
+		} else {
+			rcode = strconv.Itoa(r.Rcode)
+		}
+	}
+
+	return rcode
+}
@@ -0,0 +1,125 @@
+package dnsrecon
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+// SupportedTypes are the record sets GetDnsData can collect
+var SupportedTypes = []uint16{
+	dns.TypeSOA,
+	dns.TypeA,
+	dns.TypeAAAA,
+	dns.TypeNS,
+	dns.TypeMX,
+	dns.TypeTXT,
+	dns.TypeCNAME,
+	dns.TypeCAA,
+	dns.TypeSRV,
+	dns.TypeNAPTR,
+	dns.TypeSSHFP,
+	dns.TypeTLSA,
+	dns.TypeHTTPS,
+	dns.TypeSVCB,
+	dns.TypeLOC,
+	dns.TypeHINFO,
+	dns.TypeURI,
+}
+
+// DefaultTypes are the record sets resolved when no types are requested
+// The other supported types cost extra queries per lookup so they have to be asked for
+var DefaultTypes = []uint16{
+	dns.TypeSOA,
+	dns.TypeA,
+	dns.TypeAAAA,
+	dns.TypeNS,
+	dns.TypeMX,
+	dns.TypeTXT,
+	dns.TypeCNAME,
+}
+
+// LookupOptions changes what GetDnsDataWithOptions collects
+type LookupOptions struct {
+	// Types are the record sets to resolve, DefaultTypes are resolved when empty
+	Types []uint16
+
+	// DNSSEC validates the chain of trust and the signature of every RRset
+	DNSSEC bool
+
+	// ZoneTransfer tries an AXFR against every address of the SOA primary and the NS records
+	ZoneTransfer bool
+
+	// IXFRSerial also tries an IXFR from this serial when set
+	IXFRSerial uint32
+
+	// Email looks up DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records and summarizes them with the SPF analysis
+	Email bool
+
+	// Delegation compares the parent's NS records and glue with the zone's nameservers and checks each of them
+	Delegation bool
+
+	// SPF parses the SPF record and expands its includes and redirects into the authorized IP ranges
+	SPF bool
+
+	// ReverseDNS looks up the PTR records of every address found and checks the names resolve back to it
+	ReverseDNS bool
+
+	// Provenance records the resolver, attempts, timing and flags of every query
+	Provenance bool
+}
+
+// lookup checks whether the record set should be resolved
+func (opts LookupOptions) lookup(qtype uint16) bool {
+
+	types := opts.Types
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+
+	for _, t := range types {
+		if t == qtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseRecordTypes converts a comma separated list of record type names, e.g. "mx,txt"
+func ParseRecordTypes(names string) ([]uint16, error) {
+
+	var types []uint16
+
+	for _, name := range strings.Split(names, ",") {
+
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		t, ok := dns.StringToType[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown record type %q", name)
+		}
+
+		if !isSupportedType(t) {
+			return nil, fmt.Errorf("unsupported record type %q", name)
+		}
+
+		types = append(types, t)
+	}
+
+	return types, nil
+}
+
+func isSupportedType(qtype uint16) bool {
+
+	for _, t := range SupportedTypes {
+		if t == qtype {
+			return true
+		}
+	}
+
+	return false
+}
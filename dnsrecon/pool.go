@@ -0,0 +1,215 @@
+package dnsrecon
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool hands out dns clients, each client is used by one lookup at a time
+// Clients can be disabled, re-enabled and replaced while lookups are using them
+// A client that is removed or disabled while in use is dropped when it's put back
+type Pool struct {
+	mu sync.Mutex
+
+	// ch holds the idle clients, it's replaced when the clients change so it never fills up
+	ch      chan *DnsClient
+	changed chan struct{}
+
+	clients  []*DnsClient
+	members  map[*DnsClient]bool
+	disabled map[*DnsClient]bool
+	inUse    map[*DnsClient]bool
+	idle     map[*DnsClient]bool
+}
+
+// NewPool creates a pool with every client idle
+func NewPool(clients []*DnsClient) *Pool {
+
+	p := &Pool{
+		changed:  make(chan struct{}),
+		disabled: make(map[*DnsClient]bool),
+		inUse:    make(map[*DnsClient]bool),
+		idle:     make(map[*DnsClient]bool),
+	}
+
+	p.Replace(clients)
+
+	return p
+}
+
+// Get waits for an idle client until the context is done
+func (p *Pool) Get(ctx context.Context) (*DnsClient, error) {
+
+	for {
+		p.mu.Lock()
+		ch, changed := p.ch, p.changed
+		p.mu.Unlock()
+
+		select {
+		case client := <-ch:
+			p.mu.Lock()
+			delete(p.idle, client)
+			ok := p.members[client] && !p.disabled[client]
+			if ok {
+				p.inUse[client] = true
+			}
+			p.mu.Unlock()
+
+			if ok {
+				return client, nil
+			}
+
+		case <-changed:
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put returns a client to the pool
+func (p *Pool) Put(client *DnsClient) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inUse, client)
+	p.release(client)
+}
+
+// Disable keeps the client out of the pool until it's enabled again
+func (p *Pool) Disable(client *DnsClient) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.disabled[client] = true
+}
+
+// Enable puts a disabled client back in the pool
+func (p *Pool) Enable(client *DnsClient) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.disabled, client)
+
+	if !p.inUse[client] {
+		p.release(client)
+	}
+}
+
+// Replace swaps the clients of the pool, clients that are kept stay in use or idle
+func (p *Pool) Replace(clients []*DnsClient) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clients = append([]*DnsClient{}, clients...)
+	p.members = make(map[*DnsClient]bool)
+	for _, client := range clients {
+		p.members[client] = true
+	}
+
+	for client := range p.disabled {
+		if !p.members[client] {
+			delete(p.disabled, client)
+		}
+	}
+
+	// Idle clients move to a channel sized for the new clients
+	// Get may be receiving from the old channel at the same time so it's drained without blocking
+drain:
+	for {
+		select {
+		case client := <-p.ch:
+			delete(p.idle, client)
+		default:
+			break drain
+		}
+	}
+	p.ch = make(chan *DnsClient, len(clients))
+
+	for _, client := range p.clients {
+		if !p.inUse[client] {
+			p.release(client)
+		}
+	}
+
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Size is the number of clients that haven't been disabled
+func (p *Pool) Size() int {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.clients) - len(p.disabled)
+}
+
+// workers is how many clients a long running lookup takes at the same time, at most max
+// Half of the pool is left for other lookups
+func (p *Pool) workers(max int) int {
+
+	workers := p.Size() / 2
+	if workers > max {
+		workers = max
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	return workers
+}
+
+// with runs fn with an idle client and puts the client back, the error is from Get when the context is done first
+func (p *Pool) with(ctx context.Context, fn func(client *DnsClient)) error {
+
+	client, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(client)
+
+	fn(client)
+
+	return nil
+}
+
+// Clients returns every client of the pool including disabled ones
+func (p *Pool) Clients() []*DnsClient {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*DnsClient{}, p.clients...)
+}
+
+// Enabled returns the clients that haven't been disabled
+func (p *Pool) Enabled() []*DnsClient {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make([]*DnsClient, 0, len(p.clients))
+	for _, client := range p.clients {
+		if !p.disabled[client] {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
+
+// release makes the client idle unless it was disabled or removed, p.mu must be held
+func (p *Pool) release(client *DnsClient) {
+
+	if p.idle[client] || p.disabled[client] || !p.members[client] {
+		return
+	}
+
+	p.idle[client] = true
+	p.ch <- client
+}
@@ -0,0 +1,132 @@
+package dnsrecon
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provenance records which resolver answered a query and how
+type Provenance struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Resolver is the name in resolvers.yaml, IP the address the query was sent to
+	Resolver string `json:"resolver"`
+	IP       string `json:"ip"`
+
+	// Attempt counts the queries sent for the answer including retries, it's 0 for cached answers
+	Attempt int  `json:"attempt"`
+	Cached  bool `json:"cached"`
+
+	RTT   float64       `json:"rtt_ms"`
+	Rcode string        `json:"rcode"`
+	Flags ResponseFlags `json:"flags"`
+}
+
+// ResponseFlags are the header flags of the response
+// TC is set when the UDP response was truncated and the query was repeated over TCP
+type ResponseFlags struct {
+	AA bool `json:"aa"`
+	TC bool `json:"tc"`
+	RA bool `json:"ra"`
+	AD bool `json:"ad"`
+}
+
+// origin is the resolver that answered a cached response
+type origin struct {
+	resolver string
+	address  string
+}
+
+type provenanceKey struct{}
+
+// provenanceRecorder collects the provenance of every query of a lookup
+type provenanceRecorder struct {
+	mu      sync.Mutex
+	queries map[string]*Provenance
+}
+
+// withProvenance makes DnsResolver record the queries sent with the returned context
+func withProvenance(ctx context.Context) (context.Context, *provenanceRecorder) {
+
+	recorder := &provenanceRecorder{queries: make(map[string]*Provenance)}
+
+	return context.WithValue(ctx, provenanceKey{}, recorder), recorder
+}
+
+func provenanceFromContext(ctx context.Context) *provenanceRecorder {
+
+	recorder, _ := ctx.Value(provenanceKey{}).(*provenanceRecorder)
+
+	return recorder
+}
+
+// record keeps the latest answer to each question, the attempts of a retried lookup add up
+func (p *provenanceRecorder) record(q dns.Question, r *dns.Msg, o origin, attempt int, cached bool, rtt time.Duration, rcode string, truncated bool) {
+
+	prov := &Provenance{
+		Name:     normalizeDomain(q.Name),
+		Type:     dns.TypeToString[q.Qtype],
+		Resolver: o.resolver,
+		IP:       o.address,
+		Attempt:  attempt,
+		Cached:   cached,
+		RTT:      float64(rtt) / float64(time.Millisecond),
+		Rcode:    rcode,
+	}
+
+	if host, _, err := net.SplitHostPort(o.address); err == nil {
+		prov.IP = host
+	}
+
+	if r != nil {
+		prov.Flags = ResponseFlags{
+			AA: r.Authoritative,
+			TC: r.Truncated || truncated,
+			RA: r.RecursionAvailable,
+			AD: r.AuthenticatedData,
+		}
+	}
+
+	key := strings.ToLower(q.Name) + ":" + prov.Type
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if previous, ok := p.queries[key]; ok {
+		// The query sent for the question has more details than a later cache hit
+		if cached && !previous.Cached {
+			return
+		}
+		prov.Attempt += previous.Attempt
+	}
+
+	p.queries[key] = prov
+}
+
+// sets groups the queries by the lower case record type, the same keys as the record sets
+func (p *provenanceRecorder) sets() map[string][]Provenance {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sets := make(map[string][]Provenance)
+
+	for _, prov := range p.queries {
+		set := strings.ToLower(prov.Type)
+		sets[set] = append(sets[set], *prov)
+	}
+
+	for _, provs := range sets {
+		sort.Slice(provs, func(i, j int) bool {
+			return provs[i].Name < provs[j].Name
+		})
+	}
+
+	return sets
+}
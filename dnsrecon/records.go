@@ -0,0 +1,181 @@
+package dnsrecon
+
+import (
+	"github.com/miekg/dns"
+	"math"
+)
+
+// The following hold the structured rdata of the less common record types
+// They are used in both the v1 and v2 responses
+type CAARdata struct {
+	Flag  uint8  `json:"flag"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+type SRVRdata struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+type NAPTRRdata struct {
+	Order       uint16 `json:"order"`
+	Preference  uint16 `json:"preference"`
+	Flags       string `json:"flags"`
+	Service     string `json:"service"`
+	Regexp      string `json:"regexp"`
+	Replacement string `json:"replacement"`
+}
+
+type SSHFPRdata struct {
+	Algorithm   uint8  `json:"algorithm"`
+	Type        uint8  `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type TLSARdata struct {
+	Usage        uint8  `json:"usage"`
+	Selector     uint8  `json:"selector"`
+	MatchingType uint8  `json:"matching_type"`
+	Certificate  string `json:"certificate"`
+}
+
+type SVCBRdata struct {
+	Priority uint16            `json:"priority"`
+	Target   string            `json:"target"`
+	Params   map[string]string `json:"params"`
+}
+
+// LOCRdata holds the decoded location, distances are in meters
+type LOCRdata struct {
+	Latitude            float64 `json:"latitude"`
+	Longitude           float64 `json:"longitude"`
+	Altitude            float64 `json:"altitude"`
+	Size                float64 `json:"size"`
+	HorizontalPrecision float64 `json:"horizontal_precision"`
+	VerticalPrecision   float64 `json:"vertical_precision"`
+}
+
+type HINFORdata struct {
+	Cpu string `json:"cpu"`
+	Os  string `json:"os"`
+}
+
+type URIRdata struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Target   string `json:"target"`
+}
+
+type DSRdata struct {
+	KeyTag     uint16 `json:"key_tag"`
+	Algorithm  uint8  `json:"algorithm"`
+	DigestType uint8  `json:"digest_type"`
+	Digest     string `json:"digest"`
+}
+
+type DNSKEYRdata struct {
+	Flags     uint16 `json:"flags"`
+	Protocol  uint8  `json:"protocol"`
+	Algorithm uint8  `json:"algorithm"`
+	KeyTag    uint16 `json:"key_tag"`
+	PublicKey string `json:"public_key"`
+}
+
+// SRVData is a SRV record and the addresses of its target
+type SRVData struct {
+	Name string `json:"name"`
+	SRVRdata
+	Addresses IpSet `json:"addresses"`
+}
+
+// SVCBData is a HTTPS or SVCB record and the addresses of its target
+type SVCBData struct {
+	SVCBRdata
+	Addresses IpSet `json:"addresses"`
+}
+
+// TLSAData is a TLSA record and the name it was found at
+type TLSAData struct {
+	Name string `json:"name"`
+	TLSARdata
+}
+
+func newCAARdata(caa *dns.CAA) CAARdata {
+	return CAARdata{Flag: caa.Flag, Tag: caa.Tag, Value: caa.Value}
+}
+
+func newSRVRdata(srv *dns.SRV) SRVRdata {
+	return SRVRdata{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: normalizeDomain(srv.Target)}
+}
+
+func newNAPTRRdata(naptr *dns.NAPTR) NAPTRRdata {
+	return NAPTRRdata{
+		Order:       naptr.Order,
+		Preference:  naptr.Preference,
+		Flags:       naptr.Flags,
+		Service:     naptr.Service,
+		Regexp:      naptr.Regexp,
+		Replacement: normalizeDomain(naptr.Replacement),
+	}
+}
+
+func newSSHFPRdata(sshfp *dns.SSHFP) SSHFPRdata {
+	return SSHFPRdata{Algorithm: sshfp.Algorithm, Type: sshfp.Type, Fingerprint: sshfp.FingerPrint}
+}
+
+func newTLSARdata(tlsa *dns.TLSA) TLSARdata {
+	return TLSARdata{Usage: tlsa.Usage, Selector: tlsa.Selector, MatchingType: tlsa.MatchingType, Certificate: tlsa.Certificate}
+}
+
+func newSVCBRdata(svcb *dns.SVCB) SVCBRdata {
+
+	rdata := SVCBRdata{
+		Priority: svcb.Priority,
+		Target:   normalizeDomain(svcb.Target),
+		Params:   make(map[string]string),
+	}
+
+	for _, kv := range svcb.Value {
+		rdata.Params[kv.Key().String()] = kv.String()
+	}
+
+	return rdata
+}
+
+func newLOCRdata(loc *dns.LOC) LOCRdata {
+
+	// RFC 1876 stores the coordinates as thousandths of an arc second offset by 2^31
+	// and the altitude in centimeters above 100,000m below the WGS 84 spheroid
+	return LOCRdata{
+		Latitude:            float64(int64(loc.Latitude)-dns.LOC_EQUATOR) / 3600000,
+		Longitude:           float64(int64(loc.Longitude)-dns.LOC_PRIMEMERIDIAN) / 3600000,
+		Altitude:            float64(loc.Altitude)/100 - 100000,
+		Size:                locPrecision(loc.Size),
+		HorizontalPrecision: locPrecision(loc.HorizPre),
+		VerticalPrecision:   locPrecision(loc.VertPre),
+	}
+}
+
+// locPrecision decodes the base and exponent encoding of the LOC size fields to meters
+func locPrecision(v uint8) float64 {
+	return float64(v>>4) * math.Pow10(int(v&0x0f)) / 100
+}
+
+func newHINFORdata(hinfo *dns.HINFO) HINFORdata {
+	return HINFORdata{Cpu: hinfo.Cpu, Os: hinfo.Os}
+}
+
+func newURIRdata(uri *dns.URI) URIRdata {
+	return URIRdata{Priority: uri.Priority, Weight: uri.Weight, Target: uri.Target}
+}
+
+func newDSRdata(ds *dns.DS) DSRdata {
+	return DSRdata{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: ds.Digest}
+}
+
+func newDNSKEYRdata(key *dns.DNSKEY) DNSKEYRdata {
+	return DNSKEYRdata{Flags: key.Flags, Protocol: key.Protocol, Algorithm: key.Algorithm, KeyTag: key.KeyTag(), PublicKey: key.PublicKey}
+}
@@ -1,44 +1,92 @@
 package dnsrecon
 
 import (
+	"context"
 	"fmt"
 	"github.com/miekg/dns"
 	"net"
+	"time"
 )
 
-func (client *DnsClient) DnsResolver(m *dns.Msg) (*dns.Msg, error) {
+func (client *DnsClient) DnsResolver(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
 
 	r := new(dns.Msg)
 	var err error
 
-	key := fmt.Sprintf("%s:%d", m.Question[0].Name, m.Question[0].Qtype)
+	recorder := provenanceFromContext(ctx)
 
-	client.Cache.Mu.Lock()
-	rFromCache, ok := client.Cache.Lru.Get(key)
-	client.Cache.Mu.Unlock()
+	if rFromCache, o, ok := client.Cache.get(m); ok {
+		client.Log.DebugContext(ctx, "dns query", "server", "cache", "qname", m.Question[0].Name, "qtype", dns.TypeToString[m.Question[0].Qtype], "rcode", dns.RcodeToString[rFromCache.Rcode])
+		if recorder != nil {
+			recorder.record(m.Question[0], rFromCache, o, 0, true, 0, queryRcode(rFromCache, nil), false)
+		}
 
-	if ok && rFromCache != nil {
-		return rFromCache.(*dns.Msg), nil
+		// Cached NXDOMAIN responses are returned as errors like fresh ones
+		if rFromCache.Rcode == dns.RcodeNameError {
+			return nil, fmt.Errorf("NXDOMAIN")
+		}
+		return rFromCache, nil
 	}
 
-	var dnsserver string
+	var dnsserver, nameserver string
 
-	for i := 0; i != 3; i++ {
+	for i := 0; i != client.Attempts; i++ {
 
 		err = nil
 
-		client.RatelimitRequests()
+		if i > 0 {
+			queryRetriesTotal.With(client.Resolver.Nameserver).Inc()
+		}
+
+		if err := client.RatelimitRequests(ctx); err != nil {
+			return nil, err
+		}
 
-		// get a new dns server if the first retry failed
-		if i == 2 {
+		// get a new dns server for the last attempt
+		nameserver = client.Resolver.Nameserver
+		retryServer := i > 0 && i == client.Attempts-1
+		if retryServer {
 			dnsserver = client.GetRetryDnsServer()
+			nameserver = client.RetryResolvers.Nameserver
 		} else {
 			dnsserver = client.GetNameserver()
 		}
 
 		// Check whether the error is retryable
-		r, _, err = client.dns.Exchange(m, dnsserver)
+		start := time.Now()
+		r, _, err = client.dns.ExchangeContext(ctx, m, dnsserver)
+
+		// Retry over TCP if the response didn't fit in a UDP packet
+		tcp := err == nil && r != nil && r.Truncated
+		if tcp {
+			r, _, err = client.tcp.ExchangeContext(ctx, m, dnsserver)
+		}
+
+		rtt := time.Since(start)
+		rcode := queryRcode(r, err)
+		queriesTotal.With(nameserver, rcode).Inc()
+
+		if recorder != nil {
+			recorder.record(m.Question[0], r, origin{nameserver, dnsserver}, 1, false, rtt, rcode, tcp)
+		}
+
+		client.Log.DebugContext(ctx, "dns query",
+			"server", dnsserver,
+			"qname", m.Question[0].Name,
+			"qtype", dns.TypeToString[m.Question[0].Qtype],
+			"rcode", rcode,
+			"latency_ms", float64(rtt)/float64(time.Millisecond),
+			"attempt", i+1,
+			"tcp", tcp,
+			"retry_server", retryServer,
+		)
+
 		if err != nil {
+			// Stop retrying once the caller has gone away
+			if ctx.Err() != nil {
+				return nil, contextError(ctx)
+			}
+
 			if te, ok := err.(interface{ Temporary() bool }); ok {
 				if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 					err = fmt.Errorf("TIMEOUT")
@@ -54,10 +102,16 @@ func (client *DnsClient) DnsResolver(m *dns.Msg) (*dns.Msg, error) {
 			}
 		}
 
+		if r == nil {
+			err = fmt.Errorf("ERROR")
+			continue
+		}
+
 		switch r.Rcode {
 		case dns.RcodeSuccess:
 			err = nil
 		case dns.RcodeNameError:
+			client.Cache.set(m, r, origin{nameserver, dnsserver})
 			return nil, fmt.Errorf("NXDOMAIN")
 		case dns.RcodeNotImplemented:
 			return nil, fmt.Errorf("NOTIMP")
@@ -91,13 +145,11 @@ func (client *DnsClient) DnsResolver(m *dns.Msg) (*dns.Msg, error) {
 	}
 
 	if r != nil && r.Rcode == dns.RcodeSuccess {
-		client.Cache.Mu.Lock()
-		client.Cache.Lru.Add(key, r)
-		client.Cache.Mu.Unlock()
+		client.Cache.set(m, r, origin{nameserver, dnsserver})
 	}
 
 	if err != nil {
-		client.Log.Printf("dns resolver error: %v", err)
+		client.Log.WarnContext(ctx, "dns lookup failed", "qname", m.Question[0].Name, "qtype", dns.TypeToString[m.Question[0].Qtype], "error", err)
 	}
 
 	return r, err
@@ -0,0 +1,285 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"sync"
+)
+
+const (
+	ReverseConfirmed   = "confirmed"
+	ReverseUnconfirmed = "unconfirmed"
+	ReverseNoPTR       = "no_ptr"
+	ReverseError       = "error"
+)
+
+// maxReverseNames limits the PTR names checked for each address, some hosting addresses have hundreds
+const maxReverseNames = 10
+
+// ReverseDNS is the PTR lookup of an address and whether the names resolve back to it
+type ReverseDNS struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+
+	// PTR are the names the address maps back to
+	PTR []string `json:"ptr"`
+
+	// Confirmed are the PTR names whose A or AAAA records include the address
+	Confirmed []string `json:"confirmed"`
+
+	// Hosts are the names in the lookup that resolve to the address, more than one is usually shared hosting
+	Hosts []string `json:"hosts"`
+
+	// HostMatch is true when a confirmed PTR name is one of the hosts, mail servers are expected to match
+	HostMatch bool `json:"host_match"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// reverseLookups looks up the PTR records of every address in domainData and attaches them to the IP sets
+// The results for all the addresses are returned keyed by address
+func (client *DnsClient) reverseLookups(ctx context.Context, domainData *DomainData) map[string]ReverseDNS {
+
+	hosts := make(map[string][]string)
+	var addresses []string
+
+	add := func(host string, ipset IpSet) {
+		host = normalizeDomain(host)
+		for _, address := range append(append([]string{}, ipset.A...), ipset.AAAA...) {
+			if _, ok := hosts[address]; !ok {
+				addresses = append(addresses, address)
+			}
+			if !containsFold(hosts[address], host) {
+				hosts[address] = append(hosts[address], host)
+			}
+		}
+	}
+
+	data := &domainData.Data
+
+	add(domainData.Name, IpSet{A: data.A, AAAA: data.AAAA})
+	for host, ipset := range data.SOA.Nameserver {
+		add(host, ipset)
+	}
+	for host, ipset := range data.NS {
+		add(host, ipset)
+	}
+	for _, mx := range data.MX {
+		for host, ipset := range mx {
+			add(host, ipset)
+		}
+	}
+	for _, srv := range data.SRV {
+		add(srv.Target, srv.Addresses)
+	}
+	for _, svcb := range append(append([]SVCBData{}, data.HTTPS...), data.SVCB...) {
+		add(svcb.Target, svcb.Addresses)
+	}
+
+	for _, names := range hosts {
+		sort.Strings(names)
+	}
+
+	results := make([]ReverseDNS, len(addresses))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+
+	// As many addresses are looked up at the same time as in a sweep
+	for i := 0; i < sweepWorkers && i < len(addresses); i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = client.reverseLookup(ctx, addresses[i], hosts[addresses[i]])
+			}
+		}()
+	}
+
+	for i := range addresses {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	reverse := make(map[string]ReverseDNS, len(results))
+	for _, result := range results {
+		reverse[result.Address] = result
+	}
+
+	attach := func(ipset IpSet) IpSet {
+		for _, address := range append(append([]string{}, ipset.A...), ipset.AAAA...) {
+			if result, ok := reverse[address]; ok {
+				if ipset.Reverse == nil {
+					ipset.Reverse = make(map[string]ReverseDNS)
+				}
+				ipset.Reverse[address] = result
+			}
+		}
+		return ipset
+	}
+
+	for host, ipset := range data.SOA.Nameserver {
+		data.SOA.Nameserver[host] = attach(ipset)
+	}
+	for host, ipset := range data.NS {
+		data.NS[host] = attach(ipset)
+	}
+	for _, mx := range data.MX {
+		for host, ipset := range mx {
+			mx[host] = attach(ipset)
+		}
+	}
+	for i := range data.SRV {
+		data.SRV[i].Addresses = attach(data.SRV[i].Addresses)
+	}
+	for i := range data.HTTPS {
+		data.HTTPS[i].Addresses = attach(data.HTTPS[i].Addresses)
+	}
+	for i := range data.SVCB {
+		data.SVCB[i].Addresses = attach(data.SVCB[i].Addresses)
+	}
+
+	return reverse
+}
+
+// reverseLookup looks up the PTR records of the address and resolves each name forward to confirm it
+func (client *DnsClient) reverseLookup(ctx context.Context, address string, hosts []string) ReverseDNS {
+
+	result := ReverseDNS{
+		Address:   address,
+		PTR:       make([]string, 0),
+		Confirmed: make([]string, 0),
+		Hosts:     hosts,
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		result.Status = ReverseError
+		result.Error = fmt.Sprintf("invalid address %q", address)
+		return result
+	}
+
+	names, err := client.lookupPTR(ctx, ip)
+	if err != nil {
+		result.Status = ReverseError
+		result.Error = err.Error()
+		return result
+	}
+
+	if len(names) == 0 {
+		result.Status = ReverseNoPTR
+		return result
+	}
+	result.PTR = names
+
+	confirmed, err := client.confirmPTR(ctx, ip, names)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	for _, name := range confirmed {
+		result.Confirmed = append(result.Confirmed, name)
+		if containsFold(hosts, name) {
+			result.HostMatch = true
+		}
+	}
+
+	result.Status = ReverseUnconfirmed
+	if len(result.Confirmed) > 0 {
+		result.Status = ReverseConfirmed
+	}
+
+	return result
+}
+
+// lookupPTR returns the sorted PTR names of the address, NXDOMAIN isn't an error
+func (client *DnsClient) lookupPTR(ctx context.Context, ip net.IP) ([]string, error) {
+
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := client.query(ctx, arpa, dns.TypePTR)
+	if err != nil {
+		if err.Error() == "NXDOMAIN" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, rr := range r.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok && !containsFold(names, normalizeDomain(ptr.Ptr)) {
+			names = append(names, normalizeDomain(ptr.Ptr))
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// confirmPTR returns the names that resolve back to the address
+// The error reports the first failed lookup or names past maxReverseNames, the names confirmed are still returned
+func (client *DnsClient) confirmPTR(ctx context.Context, ip net.IP, names []string) ([]string, error) {
+
+	qtype := dns.TypeAAAA
+	if ip.To4() != nil {
+		qtype = dns.TypeA
+	}
+
+	var confirmed []string
+	var failed error
+
+	for i, name := range names {
+
+		if i == maxReverseNames {
+			failed = fmt.Errorf("only the first %d PTR names were resolved", maxReverseNames)
+			break
+		}
+
+		ok, err := client.forwardConfirm(ctx, name, qtype, ip)
+		if err != nil && failed == nil {
+			failed = fmt.Errorf("%s %s lookup failed: %v", name, dns.TypeToString[qtype], err)
+		}
+		if ok {
+			confirmed = append(confirmed, name)
+		}
+	}
+
+	return confirmed, failed
+}
+
+// forwardConfirm checks whether the name resolves to the address, NXDOMAIN isn't an error
+func (client *DnsClient) forwardConfirm(ctx context.Context, name string, qtype uint16, ip net.IP) (bool, error) {
+
+	r, err := client.query(ctx, name, qtype)
+	if err != nil {
+		if err.Error() == "NXDOMAIN" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, rr := range r.Answer {
+		switch v := rr.(type) {
+		case *dns.A:
+			if v.A.Equal(ip) {
+				return true, nil
+			}
+		case *dns.AAAA:
+			if v.AAAA.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
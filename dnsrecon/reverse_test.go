@@ -0,0 +1,75 @@
+package dnsrecon
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReverseLookups(t *testing.T) {
+
+	resolver := serveDNS(t, "127.0.0.1:0", authoritative(t, []string{"test.", "2.0.192.in-addr.arpa."},
+		"1.2.0.192.in-addr.arpa. 300 IN PTR example.test.",
+		"example.test. 300 IN A 192.0.2.1",
+		"2.2.0.192.in-addr.arpa. 300 IN PTR moved.example.test.",
+		"moved.example.test. 300 IN A 192.0.2.99",
+		"4.2.0.192.in-addr.arpa. 300 IN PTR shared.example.test.",
+		"shared.example.test. 300 IN A 192.0.2.4",
+	))
+
+	domainData := &DomainData{Name: "example.test"}
+	domainData.Data.A = []string{"192.0.2.1"}
+	domainData.Data.NS = map[string]IpSet{
+		"ns1.example.test": {A: []string{"192.0.2.2"}},
+		"ns2.example.test": {A: []string{"192.0.2.3"}},
+	}
+	domainData.Data.MX = map[int]map[string]IpSet{
+		10: {"mail.example.test": {A: []string{"192.0.2.4", "192.0.2.1"}}},
+	}
+
+	reverse := newLocalClient(t, resolver).reverseLookups(context.Background(), domainData)
+
+	tests := []struct {
+		address   string
+		status    string
+		ptr       string
+		hosts     string
+		hostMatch bool
+	}{
+		{address: "192.0.2.1", status: ReverseConfirmed, ptr: "example.test", hosts: "example.test mail.example.test", hostMatch: true},
+		{address: "192.0.2.2", status: ReverseUnconfirmed, ptr: "moved.example.test", hosts: "ns1.example.test"},
+		{address: "192.0.2.3", status: ReverseNoPTR, hosts: "ns2.example.test"},
+		// The PTR name resolves back but isn't the name of the mail server
+		{address: "192.0.2.4", status: ReverseConfirmed, ptr: "shared.example.test", hosts: "mail.example.test"},
+	}
+
+	if len(reverse) != len(tests) {
+		t.Errorf("%d results, want %d", len(reverse), len(tests))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+
+			result := reverse[tt.address]
+
+			if result.Status != tt.status || strings.Join(result.PTR, " ") != tt.ptr || result.Error != "" {
+				t.Errorf("status %s with PTR %v (%s), want %s with %s", result.Status, result.PTR, result.Error, tt.status, tt.ptr)
+			}
+			if strings.Join(result.Hosts, " ") != tt.hosts || result.HostMatch != tt.hostMatch {
+				t.Errorf("hosts %v matching %v, want %s matching %v", result.Hosts, result.HostMatch, tt.hosts, tt.hostMatch)
+			}
+		})
+	}
+
+	// The results are attached to the IP sets of the nameservers and mail servers
+	if r := domainData.Data.NS["ns1.example.test"].Reverse["192.0.2.2"]; r.Status != ReverseUnconfirmed {
+		t.Errorf("ns1 reverse %+v", domainData.Data.NS["ns1.example.test"].Reverse)
+	}
+	if r := domainData.Data.NS["ns2.example.test"].Reverse["192.0.2.3"]; r.Status != ReverseNoPTR {
+		t.Errorf("ns2 reverse %+v", domainData.Data.NS["ns2.example.test"].Reverse)
+	}
+	mx := domainData.Data.MX[10]["mail.example.test"].Reverse
+	if len(mx) != 2 || mx["192.0.2.1"].Status != ReverseConfirmed || mx["192.0.2.4"].Status != ReverseConfirmed {
+		t.Errorf("mx reverse %+v", mx)
+	}
+}
@@ -0,0 +1,63 @@
+package dnsrecon
+
+import (
+	"dnsrecon/resolvers"
+	"github.com/miekg/dns"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveDNS answers UDP queries on addr with handler until the test ends and returns the address it listens on
+// addr can be "127.0.0.1:0" for any free port
+func serveDNS(t *testing.T, addr string, handler dns.HandlerFunc) string {
+
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+
+	go server.ActivateAndServe()
+	<-started
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+// newLocalClient returns a started client sending every query to the server at addr
+func newLocalClient(t *testing.T, addr string) *DnsClient {
+
+	t.Helper()
+
+	client := NewDnsClient()
+	client.Resolver = &resolvers.Resolver{Nameserver: "local", Ips: []string{addr}, Ratelimit: 10000}
+	client.RetryResolvers = &resolvers.RetryResolver{Ips: []string{addr, addr}}
+	client.Cache = NewDnsCache(1000, 0, time.Hour)
+	client.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	client.Timeout = time.Second
+	client.RetryDelay = time.Millisecond
+	client.Start()
+
+	return client
+}
+
+// mustRR parses a record in zone file format
+func mustRR(t *testing.T, s string) dns.RR {
+
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+
+	return rr
+}
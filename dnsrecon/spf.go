@@ -0,0 +1,675 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SPFValid     = "valid"
+	SPFNone      = "none"
+	SPFPermError = "permerror"
+	SPFTempError = "temperror"
+
+	// Limits from RFC 7208 section 4.6.4
+	spfLookupLimit     = 10
+	spfVoidLookupLimit = 2
+	spfMXLimit         = 10
+)
+
+// SPFData is the SPF policy of a domain with every include and redirect expanded
+// IP4 and IP6 are the ranges the policy passes, exists and ptr mechanisms can't be flattened into them
+type SPFData struct {
+	Domain      string     `json:"domain"`
+	Status      string     `json:"status"`
+	Record      string     `json:"record"`
+	Lookups     int        `json:"dns_lookups"`
+	VoidLookups int        `json:"void_lookups"`
+	Policy      *SPFPolicy `json:"policy,omitempty"`
+	IP4         []string   `json:"ip4"`
+	IP6         []string   `json:"ip6"`
+	Errors      []string   `json:"errors"`
+	Warnings    []string   `json:"warnings"`
+}
+
+// SPFPolicy is the SPF record of one domain
+type SPFPolicy struct {
+	Domain string    `json:"domain"`
+	Record string    `json:"record"`
+	Terms  []SPFTerm `json:"terms"`
+}
+
+// SPFTerm is a mechanism or a modifier, modifiers have no qualifier
+// Addresses are the ranges an a or mx mechanism resolved to and Policy the record an include or redirect points to
+type SPFTerm struct {
+	Qualifier string     `json:"qualifier,omitempty"`
+	Mechanism string     `json:"mechanism"`
+	Value     string     `json:"value,omitempty"`
+	Addresses []string   `json:"addresses,omitempty"`
+	Policy    *SPFPolicy `json:"policy,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// spfCheck holds the state of one SPF evaluation
+type spfCheck struct {
+	client *DnsClient
+	data   *SPFData
+
+	// chain are the domains being evaluated, an include or redirect back to one of them is a loop
+	chain []string
+
+	temporary bool
+
+	// nxdomain is set when the last lookup returned NXDOMAIN
+	nxdomain bool
+
+	ip4 map[string]bool
+	ip6 map[string]bool
+}
+
+// analyzeSPF finds the SPF record of the domain and evaluates it without a sender address
+func (client *DnsClient) analyzeSPF(ctx context.Context, domain string) *SPFData {
+
+	data := &SPFData{
+		Domain:   normalizeDomain(domain),
+		IP4:      make([]string, 0),
+		IP6:      make([]string, 0),
+		Errors:   make([]string, 0),
+		Warnings: make([]string, 0),
+	}
+
+	check := &spfCheck{
+		client: client,
+		data:   data,
+		ip4:    make(map[string]bool),
+		ip6:    make(map[string]bool),
+	}
+
+	data.Policy = check.evaluate(ctx, data.Domain, true)
+	if data.Policy != nil {
+		data.Record = data.Policy.Record
+	}
+
+	for prefix := range check.ip4 {
+		data.IP4 = append(data.IP4, prefix)
+	}
+	for prefix := range check.ip6 {
+		data.IP6 = append(data.IP6, prefix)
+	}
+	sort.Strings(data.IP4)
+	sort.Strings(data.IP6)
+
+	switch {
+	case data.Policy == nil && len(data.Errors) == 0 && !check.temporary:
+		data.Status = SPFNone
+	case len(data.Errors) > 0:
+		data.Status = SPFPermError
+	case check.temporary:
+		data.Status = SPFTempError
+	default:
+		data.Status = SPFValid
+	}
+
+	if ctx.Err() != nil {
+		data.Status = SPFTempError
+	}
+
+	return data
+}
+
+// evaluate fetches and parses the SPF record of the domain and expands its terms
+// The ranges of pass terms are only added when authorize is set, they don't pass mail through a -include
+func (c *spfCheck) evaluate(ctx context.Context, domain string, authorize bool) *SPFPolicy {
+
+	record, err := c.record(ctx, domain)
+	if err != nil {
+		c.warnf("%v", err)
+		return nil
+	}
+	if record == "" {
+		return nil
+	}
+
+	policy := &SPFPolicy{
+		Domain: domain,
+		Record: record,
+		Terms:  make([]SPFTerm, 0),
+	}
+
+	c.chain = append(c.chain, domain)
+	defer func() {
+		c.chain = c.chain[:len(c.chain)-1]
+	}()
+
+	redirect := -1
+	all := false
+
+	for _, field := range strings.Fields(record)[1:] {
+
+		term := parseSPFTerm(field)
+
+		switch {
+		case term.Error != "":
+			c.errorf("%s: %s", domain, term.Error)
+
+		case term.Qualifier == "":
+			// Modifiers
+			if term.Mechanism == "redirect" {
+				if redirect >= 0 {
+					term.Error = "more than one redirect modifier"
+					c.errorf("%s: %s", domain, term.Error)
+				} else {
+					redirect = len(policy.Terms)
+				}
+			}
+
+		default:
+			if term.Mechanism == "all" {
+				all = true
+			}
+			c.expand(ctx, domain, &term, authorize && term.Qualifier == "+")
+		}
+
+		policy.Terms = append(policy.Terms, term)
+	}
+
+	// redirect is ignored when the record has an all mechanism
+	if redirect >= 0 {
+		if all {
+			c.warnf("%s: redirect is ignored because the record has an all mechanism", domain)
+		} else {
+			term := &policy.Terms[redirect]
+			term.Policy = c.include(ctx, domain, term, authorize)
+		}
+	}
+
+	// Included records usually end without one
+	if !all && redirect < 0 && len(c.chain) == 1 {
+		c.warnf("%s: no all mechanism or redirect, mail from other servers gets a neutral result", domain)
+	}
+
+	return policy
+}
+
+// record returns the SPF record of the domain, an empty record when it has none
+func (c *spfCheck) record(ctx context.Context, domain string) (string, error) {
+
+	answers, err := c.lookup(ctx, domain, dns.TypeTXT)
+	if err != nil {
+		return "", err
+	}
+
+	var records []string
+
+	for _, rr := range answers {
+		if txt, ok := rr.(*dns.TXT); ok {
+			// Long records are split into several strings without spaces
+			record := strings.Join(txt.Txt, "")
+			if isSPFRecord(record) {
+				records = append(records, record)
+			}
+		}
+	}
+
+	if len(records) > 1 {
+		c.errorf("%s: %d SPF records, a domain must have only one", domain, len(records))
+		return "", nil
+	}
+
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	return records[0], nil
+}
+
+func isSPFRecord(record string) bool {
+
+	record = strings.ToLower(record)
+
+	return record == "v=spf1" || strings.HasPrefix(record, "v=spf1 ")
+}
+
+// parseSPFTerm splits a term into its qualifier, name and value
+func parseSPFTerm(field string) SPFTerm {
+
+	var term SPFTerm
+
+	// Modifiers are name=value, the name can't contain a colon or slash
+	if i := strings.Index(field, "="); i > 0 && !strings.ContainsAny(field[:i], ":/") {
+		term.Mechanism = strings.ToLower(field[:i])
+		term.Value = field[i+1:]
+		if (term.Mechanism == "redirect" || term.Mechanism == "exp") && term.Value == "" {
+			term.Error = fmt.Sprintf("%s modifier without a domain", term.Mechanism)
+		}
+		return term
+	}
+
+	term.Qualifier = "+"
+	if strings.ContainsAny(field[:1], "+-~?") {
+		term.Qualifier = field[:1]
+		field = field[1:]
+	}
+
+	name := field
+	if i := strings.IndexAny(field, ":/"); i >= 0 {
+		name = field[:i]
+		term.Value = strings.TrimPrefix(field[i:], ":")
+	}
+	term.Mechanism = strings.ToLower(name)
+
+	switch term.Mechanism {
+	case "all":
+		if term.Value != "" {
+			term.Error = "all mechanism with a value"
+		}
+	case "include", "exists", "ip4", "ip6":
+		if term.Value == "" {
+			term.Error = fmt.Sprintf("%s mechanism without a value", term.Mechanism)
+		}
+	case "a", "mx", "ptr":
+	default:
+		term.Error = fmt.Sprintf("unknown mechanism %q", field)
+	}
+
+	return term
+}
+
+// expand resolves a mechanism, counting the DNS lookups it needs
+func (c *spfCheck) expand(ctx context.Context, domain string, term *SPFTerm, authorize bool) {
+
+	switch term.Mechanism {
+	case "ip4", "ip6":
+		prefix, err := parseSPFNetwork(term.Mechanism, term.Value)
+		if err != nil {
+			term.Error = err.Error()
+			c.errorf("%s: %s", domain, term.Error)
+			return
+		}
+		if authorize {
+			c.authorize(prefix)
+		}
+
+	case "include":
+		term.Policy = c.include(ctx, domain, term, authorize)
+
+	case "a", "mx":
+		if !c.countLookup(domain, term) {
+			return
+		}
+
+		target, v4, v6, err := splitSPFCIDR(term.Value, domain)
+		if err != nil {
+			term.Error = err.Error()
+			c.errorf("%s: %s", domain, term.Error)
+			return
+		}
+
+		hosts := []string{target}
+
+		if term.Mechanism == "mx" {
+			answers, err := c.lookup(ctx, target, dns.TypeMX)
+			if err != nil {
+				term.Error = err.Error()
+				return
+			}
+			hosts = hosts[:0]
+			for _, rr := range answers {
+				if mx, ok := rr.(*dns.MX); ok {
+					hosts = append(hosts, normalizeDomain(mx.Mx))
+				}
+			}
+			if len(hosts) == 0 {
+				c.countVoid(domain, term)
+				return
+			}
+			if len(hosts) > spfMXLimit {
+				term.Error = fmt.Sprintf("%d MX hosts, the limit is %d", len(hosts), spfMXLimit)
+				c.errorf("%s: %s", domain, term.Error)
+				return
+			}
+		}
+
+		term.Addresses = make([]string, 0)
+
+		for _, host := range hosts {
+			addresses, err := c.hostAddresses(ctx, host, v4, v6)
+			if err != nil {
+				term.Error = err.Error()
+				continue
+			}
+			term.Addresses = append(term.Addresses, addresses...)
+		}
+
+		if len(term.Addresses) == 0 && term.Error == "" {
+			c.countVoid(domain, term)
+		}
+
+		if authorize {
+			for _, address := range term.Addresses {
+				c.authorize(address)
+			}
+		}
+
+	case "exists":
+		if !c.countLookup(domain, term) {
+			return
+		}
+
+		target, ok := expandSPFMacros(term.Value, domain)
+		if !ok {
+			c.warnf("%s: exists:%s depends on the sender and can't be checked", domain, term.Value)
+			return
+		}
+
+		answers, err := c.lookup(ctx, target, dns.TypeA)
+		if err != nil {
+			term.Error = err.Error()
+			return
+		}
+		if len(answers) == 0 {
+			c.countVoid(domain, term)
+		}
+
+	case "ptr":
+		if !c.countLookup(domain, term) {
+			return
+		}
+		c.warnf("%s: the ptr mechanism is deprecated and can't be flattened", domain)
+	}
+}
+
+// include evaluates the domain of an include mechanism or redirect modifier
+func (c *spfCheck) include(ctx context.Context, domain string, term *SPFTerm, authorize bool) *SPFPolicy {
+
+	if !c.countLookup(domain, term) {
+		return nil
+	}
+
+	target, ok := expandSPFMacros(term.Value, domain)
+	if !ok {
+		c.warnf("%s: %s:%s depends on the sender and can't be checked", domain, term.Mechanism, term.Value)
+		return nil
+	}
+	target = normalizeDomain(target)
+
+	for _, d := range c.chain {
+		if d == target {
+			term.Error = fmt.Sprintf("loop back to %s", target)
+			c.errorf("%s: %s %s", domain, term.Mechanism, term.Error)
+			return nil
+		}
+	}
+
+	errors := len(c.data.Errors)
+	temporary := c.temporary
+
+	policy := c.evaluate(ctx, target, authorize)
+
+	if policy == nil && len(c.data.Errors) == errors && c.temporary == temporary {
+		term.Error = fmt.Sprintf("%s has no SPF record", target)
+		if c.nxdomain {
+			term.Error = fmt.Sprintf("%s doesn't exist", target)
+			c.countVoid(domain, term)
+		}
+		c.errorf("%s: %s", domain, term.Error)
+	}
+
+	return policy
+}
+
+// hostAddresses resolves the A and AAAA records of a host into ranges with the mechanism's prefix lengths
+func (c *spfCheck) hostAddresses(ctx context.Context, host string, v4 int, v6 int) ([]string, error) {
+
+	var addresses []string
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+
+		answers, err := c.lookup(ctx, host, qtype)
+		if err != nil {
+			return addresses, err
+		}
+
+		for _, rr := range answers {
+			switch v := rr.(type) {
+			case *dns.A:
+				addresses = append(addresses, (&net.IPNet{IP: v.A.Mask(net.CIDRMask(v4, 32)), Mask: net.CIDRMask(v4, 32)}).String())
+			case *dns.AAAA:
+				addresses = append(addresses, (&net.IPNet{IP: v.AAAA.Mask(net.CIDRMask(v6, 128)), Mask: net.CIDRMask(v6, 128)}).String())
+			}
+		}
+	}
+
+	return addresses, nil
+}
+
+// lookup returns the answers of the type, NXDOMAIN returns no answers
+// Other errors make the result a temperror
+func (c *spfCheck) lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+
+	r, err := c.client.query(ctx, name, qtype)
+
+	c.nxdomain = err != nil && err.Error() == "NXDOMAIN"
+
+	if err != nil {
+		if c.nxdomain {
+			return nil, nil
+		}
+		c.temporary = true
+		return nil, fmt.Errorf("%s %s lookup failed: %v", normalizeDomain(name), dns.TypeToString[qtype], err)
+	}
+
+	var answers []dns.RR
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == qtype {
+			answers = append(answers, rr)
+		}
+	}
+
+	return answers, nil
+}
+
+// countLookup counts a term that needs DNS lookups, terms past the limit aren't resolved
+func (c *spfCheck) countLookup(domain string, term *SPFTerm) bool {
+
+	c.data.Lookups++
+
+	if c.data.Lookups > spfLookupLimit {
+		term.Error = fmt.Sprintf("exceeds the limit of %d DNS lookups", spfLookupLimit)
+		if c.data.Lookups == spfLookupLimit+1 {
+			c.errorf("%s: %s %s", domain, term.Mechanism, term.Error)
+		}
+		return false
+	}
+
+	return true
+}
+
+// countVoid counts a lookup that returned no records
+func (c *spfCheck) countVoid(domain string, term *SPFTerm) {
+
+	c.data.VoidLookups++
+
+	if c.data.VoidLookups == spfVoidLookupLimit+1 {
+		c.errorf("%s: %s returned no records, more than %d void lookups", domain, term.Mechanism, spfVoidLookupLimit)
+	}
+}
+
+// authorize adds a range to the flattened ranges
+func (c *spfCheck) authorize(prefix string) {
+
+	if strings.Contains(prefix, ":") {
+		c.ip6[prefix] = true
+	} else {
+		c.ip4[prefix] = true
+	}
+}
+
+func (c *spfCheck) errorf(format string, args ...interface{}) {
+	c.data.Errors = append(c.data.Errors, fmt.Sprintf(format, args...))
+}
+
+func (c *spfCheck) warnf(format string, args ...interface{}) {
+	c.data.Warnings = append(c.data.Warnings, fmt.Sprintf(format, args...))
+}
+
+// parseSPFNetwork validates the address or network of an ip4 or ip6 mechanism
+func parseSPFNetwork(mechanism string, value string) (string, error) {
+
+	if !strings.Contains(value, "/") {
+		if mechanism == "ip4" {
+			value += "/32"
+		} else {
+			value += "/128"
+		}
+	}
+
+	ip, prefix, err := net.ParseCIDR(value)
+	if err != nil || (ip.To4() != nil) != (mechanism == "ip4") {
+		return "", fmt.Errorf("invalid %s network %q", mechanism, value)
+	}
+
+	return prefix.String(), nil
+}
+
+// splitSPFCIDR splits the domain and the dual prefix lengths of an a or mx mechanism, e.g. "example.com/24//64"
+func splitSPFCIDR(value string, domain string) (string, int, int, error) {
+
+	v4, v6 := 32, 128
+
+	target := value
+	cidr := ""
+	if i := strings.Index(value, "/"); i >= 0 {
+		target, cidr = value[:i], value[i:]
+	}
+
+	if cidr != "" {
+		lengths := strings.SplitN(cidr, "//", 2)
+		var err error
+		if lengths[0] != "" {
+			v4, err = strconv.Atoi(strings.TrimPrefix(lengths[0], "/"))
+			if err != nil || v4 < 0 || v4 > 32 {
+				return "", 0, 0, fmt.Errorf("invalid ip4 prefix length in %q", value)
+			}
+		}
+		if len(lengths) == 2 {
+			v6, err = strconv.Atoi(lengths[1])
+			if err != nil || v6 < 0 || v6 > 128 {
+				return "", 0, 0, fmt.Errorf("invalid ip6 prefix length in %q", value)
+			}
+		}
+	}
+
+	if target == "" {
+		return domain, v4, v6, nil
+	}
+
+	expanded, ok := expandSPFMacros(target, domain)
+	if !ok {
+		return "", 0, 0, fmt.Errorf("%q depends on the sender", target)
+	}
+
+	return expanded, v4, v6, nil
+}
+
+// expandSPFMacros replaces the macros that only depend on the domain
+// It returns false for macros that need the sender, its address or the HELO name
+func expandSPFMacros(spec string, domain string) (string, bool) {
+
+	var b strings.Builder
+
+	for i := 0; i < len(spec); i++ {
+
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+
+		if i+1 == len(spec) {
+			return "", false
+		}
+		i++
+
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", false
+			}
+			value, ok := expandSPFMacro(spec[i+1:i+end], domain)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", false
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "."), true
+}
+
+// expandSPFMacro applies the transformers of RFC 7208 section 7.3 to the domain of a d or o macro
+// The value is split on the delimiters, reversed with r and cut to the number of rightmost parts
+func expandSPFMacro(macro string, domain string) (string, bool) {
+
+	if macro == "" || !strings.ContainsRune("dDoO", rune(macro[0])) {
+		return "", false
+	}
+	macro = macro[1:]
+
+	digits := 0
+	for digits < len(macro) && macro[digits] >= '0' && macro[digits] <= '9' {
+		digits++
+	}
+
+	keep := 0
+	if digits > 0 {
+		n, err := strconv.Atoi(macro[:digits])
+		if err != nil || n == 0 {
+			return "", false
+		}
+		keep = n
+	}
+	macro = macro[digits:]
+
+	reverse := false
+	if macro != "" && (macro[0] == 'r' || macro[0] == 'R') {
+		reverse = true
+		macro = macro[1:]
+	}
+
+	delimiters := "."
+	if macro != "" {
+		if strings.Trim(macro, ".-+,/_=") != "" {
+			return "", false
+		}
+		delimiters = macro
+	}
+
+	parts := strings.FieldsFunc(domain, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+
+	return strings.Join(parts, "."), true
+}
@@ -0,0 +1,68 @@
+package dnsrecon
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandSPFMacros(t *testing.T) {
+
+	// Examples from RFC 7208 section 7.4 with the domain as d
+	tests := []struct {
+		spec   string
+		domain string
+		want   string
+		ok     bool
+	}{
+		{spec: "%{d}", domain: "email.example.com", want: "email.example.com", ok: true},
+		{spec: "%{d4}", domain: "email.example.com", want: "email.example.com", ok: true},
+		{spec: "%{d3}", domain: "email.example.com", want: "email.example.com", ok: true},
+		{spec: "%{d2}", domain: "email.example.com", want: "example.com", ok: true},
+		{spec: "%{d1}", domain: "email.example.com", want: "com", ok: true},
+		{spec: "%{dr}", domain: "email.example.com", want: "com.example.email", ok: true},
+		{spec: "%{d2r}", domain: "email.example.com", want: "example.email", ok: true},
+		{spec: "%{o-}", domain: "strong-bad.example", want: "strong.bad.example", ok: true},
+		{spec: "%{Or-.}", domain: "strong-bad.example", want: "example.bad.strong", ok: true},
+		{spec: "_spf.%{d2}", domain: "mail.example.com", want: "_spf.example.com", ok: true},
+		{spec: "%{d0}", domain: "example.com"},
+		{spec: "%{d2x}", domain: "example.com"},
+		{spec: "%{ir}.%{v}._spf.%{d2}", domain: "example.com"},
+		{spec: "%{d", domain: "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+
+			got, ok := expandSPFMacros(tt.spec, tt.domain)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSPFVoidIncludes(t *testing.T) {
+
+	resolver := serveDNS(t, "127.0.0.1:0", authoritative(t, []string{"test."},
+		`void.test. 300 IN TXT "v=spf1 include:missing1.test include:missing2.test include:missing3.test -all"`,
+	))
+
+	data := newLocalClient(t, resolver).analyzeSPF(context.Background(), "void.test")
+
+	if data.Status != SPFPermError || data.VoidLookups != 3 {
+		t.Fatalf("status %s with %d void lookups, want %s with 3", data.Status, data.VoidLookups, SPFPermError)
+	}
+
+	if term := data.Policy.Terms[0]; term.Error != "missing1.test doesn't exist" {
+		t.Errorf("include error %q", term.Error)
+	}
+
+	found := false
+	for _, e := range data.Errors {
+		found = found || strings.Contains(e, "more than 2 void lookups")
+	}
+	if !found {
+		t.Errorf("errors %v don't report the void lookup limit", data.Errors)
+	}
+}
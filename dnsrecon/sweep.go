@@ -0,0 +1,191 @@
+package dnsrecon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	// sweepWorkers is the most addresses looked up at the same time, each with its own client from the pool
+	// The rate limiter of the dns clients still applies to every query
+	sweepWorkers = 10
+)
+
+// SweepRange describes the addresses of a reverse sweep, it's the first result of the stream
+type SweepRange struct {
+	Type      string `json:"type"`
+	Network   string `json:"network"`
+	Addresses int    `json:"addresses"`
+
+	network *net.IPNet
+}
+
+// SweepHost is an address with PTR records found by SweepPTR
+// Status and Confirmed are only set when forward confirmation was requested or the lookup failed
+type SweepHost struct {
+	Type      string   `json:"type"`
+	Address   string   `json:"address"`
+	PTR       []string `json:"ptr"`
+	Status    string   `json:"status,omitempty"`
+	Confirmed []string `json:"confirmed,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ParseSweepRange reads an IPv4 or IPv6 address or CIDR, an address is swept as a /32 or /128
+// Ranges with more than max addresses are rejected
+func ParseSweepRange(s string, max int) (SweepRange, error) {
+
+	var sweep SweepRange
+
+	s = strings.TrimSpace(s)
+
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return sweep, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		s = fmt.Sprintf("%s/%d", ip, bits)
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return sweep, fmt.Errorf("invalid address or CIDR %q", s)
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones >= 31 || 1<<(bits-ones) > max {
+		return sweep, fmt.Errorf("%s has more than %d addresses", network, max)
+	}
+
+	sweep.Type = "range"
+	sweep.Network = network.String()
+	sweep.Addresses = 1 << (bits - ones)
+	sweep.network = network
+
+	return sweep, nil
+}
+
+// SweepPTR looks up the PTR records of every address in the range and sends the addresses that have them to results
+// Every address is looked up with a client taken from the pool and put back afterwards
+// Addresses whose lookup failed are sent with the error, results is closed when the sweep finishes
+func (p *Pool) SweepPTR(ctx context.Context, sweep SweepRange, confirm bool, results chan<- SweepHost) {
+
+	defer close(results)
+
+	addresses := make(chan net.IP)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.workers(sweepWorkers); i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ip := range addresses {
+
+				var host SweepHost
+				var ok bool
+
+				err := p.with(ctx, func(client *DnsClient) {
+					host, ok = client.sweepAddress(ctx, ip, confirm)
+				})
+				if err != nil {
+					return
+				}
+				if !ok {
+					continue
+				}
+
+				select {
+				case results <- host:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	ip := make(net.IP, len(sweep.network.IP))
+	copy(ip, sweep.network.IP)
+
+feed:
+	for i := 0; i < sweep.Addresses; i++ {
+
+		address := make(net.IP, len(ip))
+		copy(address, ip)
+
+		select {
+		case addresses <- address:
+		case <-ctx.Done():
+			break feed
+		}
+
+		nextIP(ip)
+	}
+
+	close(addresses)
+	wg.Wait()
+}
+
+// sweepAddress looks up the PTR records of the address, ok is false when it has none
+func (client *DnsClient) sweepAddress(ctx context.Context, ip net.IP, confirm bool) (SweepHost, bool) {
+
+	host := SweepHost{
+		Type:    "host",
+		Address: ip.String(),
+		PTR:     make([]string, 0),
+	}
+
+	names, err := client.lookupPTR(ctx, ip)
+	if err != nil {
+		// Lookups cut short by the end of the sweep aren't failures
+		if ctx.Err() != nil {
+			return host, false
+		}
+		host.Status = ReverseError
+		host.Error = err.Error()
+		return host, true
+	}
+
+	if len(names) == 0 {
+		return host, false
+	}
+	host.PTR = names
+
+	if !confirm {
+		return host, true
+	}
+
+	confirmed, err := client.confirmPTR(ctx, ip, names)
+	if err != nil {
+		host.Error = err.Error()
+	}
+
+	host.Confirmed = confirmed
+	host.Status = ReverseUnconfirmed
+	if len(confirmed) > 0 {
+		host.Status = ReverseConfirmed
+	}
+
+	return host, true
+}
+
+// nextIP increments the address in place
+func nextIP(ip net.IP) {
+
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
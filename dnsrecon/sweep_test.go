@@ -0,0 +1,115 @@
+package dnsrecon
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseSweepRange(t *testing.T) {
+
+	tests := []struct {
+		input     string
+		max       int
+		network   string
+		addresses int
+		error     string
+	}{
+		{input: "192.0.2.1", max: 1, network: "192.0.2.1/32", addresses: 1},
+		{input: " 192.0.2.1/32 ", max: 1, network: "192.0.2.1/32", addresses: 1},
+		{input: "2001:db8::1", max: 1, network: "2001:db8::1/128", addresses: 1},
+		{input: "2001:db8::1/128", max: 1, network: "2001:db8::1/128", addresses: 1},
+		{input: "192.0.2.77/24", max: 256, network: "192.0.2.0/24", addresses: 256},
+		{input: "2001:db8::/120", max: 256, network: "2001:db8::/120", addresses: 256},
+		{input: "192.0.2.0/24", max: 255, error: "192.0.2.0/24 has more than 255 addresses"},
+		// 1<<31 and larger would overflow on 32 bit platforms whatever the maximum is
+		{input: "0.0.0.0/1", max: 1 << 30, error: "0.0.0.0/1 has more than"},
+		{input: "2001:db8::/64", max: 1 << 30, error: "2001:db8::/64 has more than"},
+		{input: "example.com", max: 1, error: `invalid address "example.com"`},
+		{input: "192.0.2.0/33", max: 1, error: `invalid address or CIDR "192.0.2.0/33"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+
+			sweep, err := ParseSweepRange(tt.input, tt.max)
+
+			if tt.error != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.error) {
+					t.Fatalf("error %v, want %s", err, tt.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sweep.Network != tt.network || sweep.Addresses != tt.addresses {
+				t.Errorf("got %s with %d addresses, want %s with %d", sweep.Network, sweep.Addresses, tt.network, tt.addresses)
+			}
+		})
+	}
+}
+
+func TestNextIP(t *testing.T) {
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "192.0.2.1", want: "192.0.2.2"},
+		{ip: "192.0.2.255", want: "192.0.3.0"},
+		{ip: "192.255.255.255", want: "193.0.0.0"},
+		{ip: "255.255.255.255", want: "0.0.0.0"},
+		{ip: "2001:db8::ffff", want: "2001:db8::1:0"},
+		{ip: "2001:db8:0:ffff:ffff:ffff:ffff:ffff", want: "2001:db8:1::"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+
+			ip := net.ParseIP(tt.ip)
+			if ip.To4() != nil {
+				ip = ip.To4()
+			}
+
+			nextIP(ip)
+
+			if ip.String() != tt.want {
+				t.Errorf("got %s, want %s", ip, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepPTR(t *testing.T) {
+
+	server := serveDNS(t, "127.0.0.1:0", authoritative(t, []string{"2.0.192.in-addr.arpa."},
+		"1.2.0.192.in-addr.arpa. 300 IN PTR one.example.test.",
+		"6.2.0.192.in-addr.arpa. 300 IN PTR six.example.test.",
+	))
+
+	var clients []*DnsClient
+	for i := 0; i < 4; i++ {
+		clients = append(clients, newLocalClient(t, server))
+	}
+
+	sweep, err := ParseSweepRange("192.0.2.0/29", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan SweepHost)
+	go NewPool(clients).SweepPTR(context.Background(), sweep, false, results)
+
+	var hosts []string
+	for host := range results {
+		hosts = append(hosts, host.Address+" "+strings.Join(host.PTR, ","))
+	}
+	sort.Strings(hosts)
+
+	if strings.Join(hosts, "; ") != "192.0.2.1 one.example.test; 192.0.2.6 six.example.test" {
+		t.Errorf("hosts %v", hosts)
+	}
+}
@@ -0,0 +1,273 @@
+package dnsrecon
+
+import (
+	"github.com/miekg/dns"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemaVersion2 = "2"
+)
+
+// DomainDataV2 is the versioned response served at /v2/domain/{domain}
+// Every record keeps its owner name, type, class and TTL and the rdata is split into typed fields
+type DomainDataV2 struct {
+	Version string `json:"version"`
+
+	Name string `json:"name"`
+
+	// Records are keyed by the lower case record type, e.g. "mx"
+	Records map[string][]Record `json:"records"`
+
+	// Hosts holds the addresses of the SOA, NS, MX, SRV and HTTPS/SVCB targets
+	Hosts map[string]HostRecords `json:"hosts"`
+
+	CNamePaths map[string][][]string `json:"cname_paths"`
+
+	DNSSEC *DnssecData `json:"dnssec,omitempty"`
+
+	ZoneTransfers []ZoneTransfer `json:"zone_transfers,omitempty"`
+
+	SPF *SPFData `json:"spf,omitempty"`
+
+	Email *EmailSecurity `json:"email,omitempty"`
+
+	Delegation *DelegationReport `json:"delegation,omitempty"`
+
+	ReverseDNS map[string]ReverseDNS `json:"reverse_dns,omitempty"`
+
+	Provenance map[string][]Provenance `json:"provenance,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+
+	Status string `json:"status"`
+
+	Errors map[string]string `json:"errors"`
+}
+
+// Record is a single resource record
+type Record struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Class string      `json:"class"`
+	TTL   uint32      `json:"ttl"`
+	Data  interface{} `json:"rdata"`
+}
+
+type HostRecords struct {
+	A    []Record `json:"a"`
+	AAAA []Record `json:"aaaa"`
+}
+
+// The following hold the rdata of each record type
+type SOARdata struct {
+	Mname   string `json:"mname"`
+	Rname   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"`
+}
+
+type NSRdata struct {
+	Host string `json:"host"`
+}
+
+type MXRdata struct {
+	Preference uint16 `json:"preference"`
+	Exchange   string `json:"exchange"`
+}
+
+type TXTRdata struct {
+	Txt []string `json:"txt"`
+}
+
+type CNAMERdata struct {
+	Target string `json:"target"`
+}
+
+type AddressRdata struct {
+	Address string `json:"address"`
+}
+
+// GenericRdata is used for record types without typed fields
+type GenericRdata struct {
+	Rdata string `json:"rdata"`
+}
+
+// rawRecords keeps the records seen while building DomainData so the v2 schema can be produced
+type rawRecords struct {
+	mu      sync.Mutex
+	records map[string][]dns.RR
+	hosts   map[string][]dns.RR
+}
+
+func newRawRecords() *rawRecords {
+
+	var raw rawRecords
+	raw.records = make(map[string][]dns.RR)
+	raw.hosts = make(map[string][]dns.RR)
+	return &raw
+}
+
+// addRecords stores the records in rrs matching rrtype under the record set name
+func (d *DomainData) addRecords(set string, rrtype uint16, rrs []dns.RR) {
+
+	d.raw.mu.Lock()
+	defer d.raw.mu.Unlock()
+
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			d.raw.records[set] = append(d.raw.records[set], rr)
+		}
+	}
+}
+
+// addHostRecord stores an A or AAAA record for a SOA, NS or MX target
+func (d *DomainData) addHostRecord(rr dns.RR) {
+
+	d.raw.mu.Lock()
+	defer d.raw.mu.Unlock()
+
+	host := normalizeDomain(rr.Header().Name)
+
+	// The same host is often both the SOA primary and a NS
+	for _, seen := range d.raw.hosts[host] {
+		if dns.IsDuplicate(seen, rr) {
+			return
+		}
+	}
+
+	d.raw.hosts[host] = append(d.raw.hosts[host], rr)
+}
+
+// V2 converts DomainData to the v2 schema
+func (d *DomainData) V2() *DomainDataV2 {
+
+	var v2 DomainDataV2
+
+	v2.Version = SchemaVersion2
+	v2.Name = d.Name
+	v2.Timestamp = d.Timestamp
+	v2.Status = d.Status
+	v2.Errors = d.Errors
+	v2.CNamePaths = d.Data.CNamePaths
+	v2.DNSSEC = d.DNSSEC
+	v2.ZoneTransfers = d.ZoneTransfers
+	v2.SPF = d.SPF
+	v2.Email = d.Email
+	v2.Delegation = d.Delegation
+	v2.ReverseDNS = d.ReverseDNS
+	v2.Provenance = d.Provenance
+	v2.Records = make(map[string][]Record)
+	v2.Hosts = make(map[string]HostRecords)
+
+	d.raw.mu.Lock()
+	defer d.raw.mu.Unlock()
+
+	for set, rrs := range d.raw.records {
+		records := make([]Record, 0, len(rrs))
+		for _, rr := range rrs {
+			records = append(records, newRecord(rr))
+		}
+		v2.Records[set] = records
+	}
+
+	for host, rrs := range d.raw.hosts {
+		hostRecords := HostRecords{A: make([]Record, 0), AAAA: make([]Record, 0)}
+		for _, rr := range rrs {
+			switch rr.(type) {
+			case *dns.A:
+				hostRecords.A = append(hostRecords.A, newRecord(rr))
+			case *dns.AAAA:
+				hostRecords.AAAA = append(hostRecords.AAAA, newRecord(rr))
+			}
+		}
+		v2.Hosts[host] = hostRecords
+	}
+
+	return &v2
+}
+
+// Records returns the records of every record set in the order of SupportedTypes
+func (d *DomainData) Records() []dns.RR {
+
+	d.raw.mu.Lock()
+	defer d.raw.mu.Unlock()
+
+	var rrs []dns.RR
+
+	for _, t := range SupportedTypes {
+		rrs = append(rrs, d.raw.records[strings.ToLower(dns.TypeToString[t])]...)
+	}
+
+	return rrs
+}
+
+func newRecord(rr dns.RR) Record {
+
+	h := rr.Header()
+
+	record := Record{
+		Name:  normalizeDomain(h.Name),
+		Type:  dns.TypeToString[h.Rrtype],
+		Class: dns.ClassToString[h.Class],
+		TTL:   h.Ttl,
+	}
+
+	switch v := rr.(type) {
+	case *dns.SOA:
+		record.Data = SOARdata{
+			Mname:   normalizeDomain(v.Ns),
+			Rname:   normalizeDomain(v.Mbox),
+			Serial:  v.Serial,
+			Refresh: v.Refresh,
+			Retry:   v.Retry,
+			Expire:  v.Expire,
+			Minimum: v.Minttl,
+		}
+	case *dns.NS:
+		record.Data = NSRdata{Host: normalizeDomain(v.Ns)}
+	case *dns.MX:
+		record.Data = MXRdata{Preference: v.Preference, Exchange: normalizeDomain(v.Mx)}
+	case *dns.TXT:
+		record.Data = TXTRdata{Txt: v.Txt}
+	case *dns.CNAME:
+		record.Data = CNAMERdata{Target: normalizeDomain(v.Target)}
+	case *dns.A:
+		record.Data = AddressRdata{Address: v.A.String()}
+	case *dns.AAAA:
+		record.Data = AddressRdata{Address: v.AAAA.String()}
+	case *dns.CAA:
+		record.Data = newCAARdata(v)
+	case *dns.SRV:
+		record.Data = newSRVRdata(v)
+	case *dns.NAPTR:
+		record.Data = newNAPTRRdata(v)
+	case *dns.SSHFP:
+		record.Data = newSSHFPRdata(v)
+	case *dns.TLSA:
+		record.Data = newTLSARdata(v)
+	case *dns.HTTPS:
+		record.Data = newSVCBRdata(&v.SVCB)
+	case *dns.SVCB:
+		record.Data = newSVCBRdata(v)
+	case *dns.LOC:
+		record.Data = newLOCRdata(v)
+	case *dns.HINFO:
+		record.Data = newHINFORdata(v)
+	case *dns.URI:
+		record.Data = newURIRdata(v)
+	case *dns.DS:
+		record.Data = newDSRdata(v)
+	case *dns.DNSKEY:
+		record.Data = newDNSKEYRdata(v)
+	default:
+		record.Data = GenericRdata{Rdata: strings.TrimPrefix(rr.String(), h.String())}
+	}
+
+	return record
+}
@@ -0,0 +1,23 @@
+package dnsrecon
+
+// DefaultWordlist holds common subdomain labels used when no wordlist is configured
+var DefaultWordlist = []string{
+	"www", "mail", "ftp", "localhost", "webmail", "smtp", "pop", "ns1", "ns2", "ns3", "ns4",
+	"webdisk", "cpanel", "whm", "autodiscover", "autoconfig", "m", "imap", "test", "dev", "admin",
+	"blog", "forum", "vpn", "mx", "mx1", "mx2", "shop", "api", "app", "apps", "beta", "stage",
+	"staging", "demo", "portal", "secure", "support", "help", "static", "cdn", "media", "img",
+	"images", "assets", "download", "downloads", "remote", "server", "gateway", "router", "firewall",
+	"proxy", "cloud", "git", "gitlab", "jenkins", "ci", "jira", "confluence", "wiki", "docs",
+	"intranet", "extranet", "internal", "corp", "office", "exchange", "owa", "lync", "sip", "voip",
+	"crm", "erp", "hr", "payroll", "billing", "pay", "payment", "store", "sso", "auth", "login", "id",
+	"accounts", "account", "my", "dashboard", "panel", "monitor", "status", "grafana", "kibana",
+	"elastic", "search", "db", "mysql", "sql", "postgres", "redis", "mongo", "backup", "backups",
+	"files", "file", "upload", "uploads", "share", "sharepoint", "drive", "mobile", "new", "old",
+	"legacy", "web", "web1", "web2", "www1", "www2", "www3", "ns", "dns", "dns1", "dns2", "mail1",
+	"mail2", "smtp1", "smtp2", "pop3", "relay", "email", "news", "video", "live", "stream", "chat",
+	"calendar", "events", "jobs", "careers", "partners", "partner", "clients", "client", "host",
+	"hosting", "vps", "node1", "node2", "cluster", "k8s", "kubernetes", "docker", "registry", "repo",
+	"svn", "ldap", "ad", "dc", "radius", "time", "ntp", "sandbox", "uat", "qa", "preprod", "prod",
+	"production", "development", "lab", "labs", "research", "edu", "int", "ext", "test1", "test2",
+	"origin", "lb", "go", "link", "links", "track", "analytics", "stats", "metrics", "logs",
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"dnsrecon/dnsrecon"
+	"encoding/json"
+	"github.com/miekg/dns"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDomainListSize limits the size of posted domain lists
+	maxDomainListSize = 10 << 20
+)
+
+// BulkError is streamed instead of the dns data when a domain couldn't be looked up
+type BulkError struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// BulkHandler looks up every posted domain and streams the dns data as NDJSON as each lookup completes
+// The body is a JSON array or one domain per line, the lookup options of /domain apply to every domain
+func (s *Server) BulkHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := withRequestID(w, r)
+
+	opts, err := lookupOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	domains, err := dnsrecon.ParseDomainList(http.MaxBytesReader(w, r.Body, maxDomainListSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Large batches take longer than the server write timeout, the client disconnecting still cancels them
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	// Bulk lookups use at most half of the pool so other requests can still get a dns client
+	// Each worker holds one client at a time and returns it after every domain
+	workers := s.Pool.Size() / 2
+	if workers > len(domains) {
+		workers = len(domains)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Nobody reads the results once a write fails so the remaining lookups are stopped
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	candidates := make(chan string)
+	results := make(chan interface{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for domain := range candidates {
+				select {
+				case results <- s.bulkLookup(ctx, domain, opts):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(results)
+
+	feed:
+		for _, domain := range domains {
+			select {
+			case candidates <- domain:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+
+		close(candidates)
+		wg.Wait()
+	}()
+
+	var writeErr error
+
+	for result := range results {
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = encoder.Encode(result); writeErr != nil {
+			cancel()
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if writeErr != nil {
+		s.Log.ErrorContext(ctx, "request handler failed", "error", writeErr)
+	}
+}
+
+// bulkLookup returns the dns data of the domain or a BulkError
+func (s *Server) bulkLookup(ctx context.Context, domain string, opts dnsrecon.LookupOptions) interface{} {
+
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return BulkError{Name: domain, Status: "ERROR", Error: "invalid domain name"}
+	}
+
+	start := time.Now()
+	getCtx, cancel := context.WithTimeout(ctx, s.poolTimeout())
+	dnsClient, err := s.Pool.Get(getCtx)
+	cancel()
+	poolWait.With().Observe(time.Since(start).Seconds())
+	if err != nil {
+		if ctx.Err() != nil {
+			return BulkError{Name: domain, Status: "ERROR", Error: ctx.Err().Error()}
+		}
+		return BulkError{Name: domain, Status: "ERROR", Error: "get dns client timeout"}
+	}
+
+	ctx, err = DnsClientToContext(ctx, dnsClient)
+	if err != nil {
+		s.Pool.Put(dnsClient)
+		return BulkError{Name: domain, Status: "ERROR", Error: err.Error()}
+	}
+
+	defer s.releaseDnsClient(ctx)
+
+	domainData, err := s.lookupDomain(ctx, domain, opts)
+	if err != nil {
+		return BulkError{Name: domain, Status: "ERROR", Error: err.Error()}
+	}
+
+	return domainData
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"dnsrecon/dnsrecon"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"net/http"
+	"strconv"
+)
+
+// ConsensusHandler resolves the domain through several resolvers and compares their answers
+// types defaults to a,aaaa and resolvers limits how many of the enabled resolvers are asked
+func (s *Server) ConsensusHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := withRequestID(w, r)
+
+	domain := mux.Vars(r)["domain"]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		http.Error(w, fmt.Sprintf("invalid domain name %q", domain), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+
+	types := query.Get("types")
+	if types == "" {
+		types = "a,aaaa"
+	}
+	qtypes, err := dnsrecon.ParseRecordTypes(types)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clients := s.Pool.Enabled()
+
+	if n := query.Get("resolvers"); n != "" {
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("invalid resolvers value %q", n), http.StatusBadRequest)
+			return
+		}
+		if limit < len(clients) {
+			clients = clients[:limit]
+		}
+	}
+
+	if len(clients) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	report := dnsrecon.Compare(ctx, clients, domain, qtypes, s.Sinkholes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
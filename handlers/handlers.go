@@ -5,40 +5,258 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"dnsrecon/dnsrecon"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+const (
+	// maxWordlistSize limits the size of posted wordlists
+	maxWordlistSize = 10 << 20
+)
+
+// TargetDomainHandler returns the v1 response for the domain
 func (s *Server) TargetDomainHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
 
 	vars := mux.Vars(r)
 	domain := vars["domain"]
 
-	dnsClient, err := DnsClientFromContext(ctx)
+	opts, err := lookupOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ctx, nil
+	}
+
+	domainData, err := s.lookupDomain(ctx, domain, opts)
 	if err != nil {
 		return ctx, err
 	}
 
-	domainData := dnsClient.GetDnsData(domain)
+	// TODO validate domainData /errors
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domainData)
+
+	return ctx, nil
+}
+
+// TargetDomainV2Handler returns the typed v2 response for the domain
+func (s *Server) TargetDomainV2Handler(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+
+	vars := mux.Vars(r)
+	domain := vars["domain"]
+
+	opts, err := lookupOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ctx, nil
+	}
+
+	domainData, err := s.lookupDomain(ctx, domain, opts)
+	if err != nil {
+		return ctx, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domainData.V2())
+
+	return ctx, nil
+}
+
+// lookupDomain gets the dns data with the client in the context
+// If the first lookup failed the client goes back to the pool and the request retries with the next free one
+func (s *Server) lookupDomain(ctx context.Context, domain string, opts dnsrecon.LookupOptions) (*dnsrecon.DomainData, error) {
+
+	dnsClient, err := DnsClientFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domainData := dnsClient.GetDnsDataWithOptions(ctx, domain, opts)
 
 	// Retry a different DNS server if there was an error
+	// The first client is released before waiting so requests never hold two
 	if domainData.Status == "ERROR" {
-		select {
-		case newDnsClient := <-s.DnsClientChan:
 
-			defer s.dnsClientToChannel(newDnsClient)
+		newDnsClient, err := s.swapDnsClient(ctx, time.Second*5)
+		if err != nil {
+			return nil, err
+		}
+
+		domainData = newDnsClient.GetDnsDataWithOptions(ctx, domain, opts)
+	}
 
-			domainData = newDnsClient.GetDnsData(domain)
+	// Nobody is waiting for the response
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return domainData, nil
+}
 
-		case <-time.After(time.Second * 5):
-			return ctx, fmt.Errorf("get dns client timeout")
+// lookupOptions reads the lookup options from the query string
+// types selects the record sets to resolve, e.g. ?types=mx,txt
+// dnssec=true validates the chain of trust
+// axfr=true tries zone transfers against the nameservers, ixfr_serial=n also tries an IXFR
+// spf=true analyzes the SPF record
+// email=true checks DMARC, DKIM, MTA-STS, TLS-RPT and BIMI and also analyzes the SPF record
+// delegation=true compares the parent's delegation with the zone's nameservers
+// reverse=true looks up the PTR records of every address found and confirms them
+func lookupOptions(r *http.Request) (dnsrecon.LookupOptions, error) {
+
+	var opts dnsrecon.LookupOptions
+	var err error
+
+	query := r.URL.Query()
+
+	if types := query.Get("types"); types != "" {
+		opts.Types, err = dnsrecon.ParseRecordTypes(types)
+		if err != nil {
+			return opts, err
 		}
 	}
 
-	// TODO validate domainData /errors
+	if dnssec := query.Get("dnssec"); dnssec != "" {
+		opts.DNSSEC, err = strconv.ParseBool(dnssec)
+		if err != nil {
+			return opts, fmt.Errorf("invalid dnssec value %q", dnssec)
+		}
+	}
+
+	if axfr := query.Get("axfr"); axfr != "" {
+		opts.ZoneTransfer, err = strconv.ParseBool(axfr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid axfr value %q", axfr)
+		}
+	}
+
+	if serial := query.Get("ixfr_serial"); serial != "" {
+		ixfrSerial, err := strconv.ParseUint(serial, 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("invalid ixfr_serial value %q", serial)
+		}
+		opts.IXFRSerial = uint32(ixfrSerial)
+		opts.ZoneTransfer = true
+	}
+
+	if spf := query.Get("spf"); spf != "" {
+		opts.SPF, err = strconv.ParseBool(spf)
+		if err != nil {
+			return opts, fmt.Errorf("invalid spf value %q", spf)
+		}
+	}
+
+	if email := query.Get("email"); email != "" {
+		opts.Email, err = strconv.ParseBool(email)
+		if err != nil {
+			return opts, fmt.Errorf("invalid email value %q", email)
+		}
+	}
+
+	if delegation := query.Get("delegation"); delegation != "" {
+		opts.Delegation, err = strconv.ParseBool(delegation)
+		if err != nil {
+			return opts, fmt.Errorf("invalid delegation value %q", delegation)
+		}
+	}
+
+	if reverse := query.Get("reverse"); reverse != "" {
+		opts.ReverseDNS, err = strconv.ParseBool(reverse)
+		if err != nil {
+			return opts, fmt.Errorf("invalid reverse value %q", reverse)
+		}
+	}
+
+	if provenance := query.Get("provenance"); provenance != "" {
+		opts.Provenance, err = strconv.ParseBool(provenance)
+		if err != nil {
+			return opts, fmt.Errorf("invalid provenance value %q", provenance)
+		}
+	}
+
+	return opts, nil
+}
+
+// CacheStatsHandler returns the dns cache counters
+func (s *Server) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(domainData)
+	json.NewEncoder(w).Encode(s.Cache.Stats())
+}
 
-	return ctx, nil
+// ResolversHandler returns the health of every resolver and whether it is in the pool
+func (s *Server) ResolversHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Health.Status())
+}
+
+// EnumerateHandler brute forces subdomains with the configured wordlist and streams them as NDJSON
+// A wordlist with one label per line can be posted to use it instead
+// The first line describes any wildcard records found for the domain
+func (s *Server) EnumerateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+
+	vars := mux.Vars(r)
+	domain := vars["domain"]
+
+	words := s.Wordlist
+
+	if r.Method == http.MethodPost {
+		var err error
+		words, err = dnsrecon.ParseWordlist(http.MaxBytesReader(w, r.Body, maxWordlistSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return ctx, nil
+		}
+	}
+
+	dnsClient, err := DnsClientFromContext(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	// Large wordlists take longer than the server write timeout, the client disconnecting still cancels them
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	wildcard := dnsClient.DetectWildcard(ctx, domain)
+	if err := encoder.Encode(wildcard); err != nil {
+		return ctx, err
+	}
+
+	// Nobody reads the results once a write fails so the enumeration is stopped
+	enumCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The candidates are spread over the pool, the request's own client isn't needed anymore
+	s.releaseDnsClient(ctx)
+
+	results := make(chan dnsrecon.Subdomain)
+	go s.Pool.EnumerateSubdomains(enumCtx, domain, words, wildcard, results)
+
+	var writeErr error
+
+	for subdomain := range results {
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = encoder.Encode(subdomain); writeErr != nil {
+			cancel()
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if writeErr != nil {
+		return ctx, writeErr
+	}
+
+	return ctx, ctx.Err()
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"dnsrecon/dnsrecon"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// IPHandler looks up the PTR records of an address or every address of a CIDR and streams the hosts found as NDJSON
+// confirm=true also checks the PTR names resolve back to the address
+// The first line describes the range, ranges larger than sweep_max_addresses are rejected
+func (s *Server) IPHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+
+	sweep, err := dnsrecon.ParseSweepRange(mux.Vars(r)["range"], s.Config.SweepMaxAddresses)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ctx, nil
+	}
+
+	var confirm bool
+	if value := r.URL.Query().Get("confirm"); value != "" {
+		confirm, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid confirm value %q", value), http.StatusBadRequest)
+			return ctx, nil
+		}
+	}
+
+	// Large ranges take longer than the server write timeout, the client disconnecting still cancels them
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	if err := encoder.Encode(sweep); err != nil {
+		return ctx, err
+	}
+
+	// Nobody reads the results once a write fails so the sweep is stopped
+	sweepCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The addresses are spread over the pool, the request's own client isn't needed
+	s.releaseDnsClient(ctx)
+
+	results := make(chan dnsrecon.SweepHost)
+	go s.Pool.SweepPTR(sweepCtx, sweep, confirm, results)
+
+	var writeErr error
+
+	for host := range results {
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = encoder.Encode(host); writeErr != nil {
+			cancel()
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if writeErr != nil {
+		return ctx, writeErr
+	}
+
+	return ctx, ctx.Err()
+}
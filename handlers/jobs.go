@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"dnsrecon/jobs"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// CreateJobHandler queues a domain scan or subdomain enumeration and returns the job id
+func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+
+	var request jobs.Request
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWordlistSize)).Decode(&request); err != nil {
+		http.Error(w, "invalid job request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := s.Jobs.Submit(request)
+	if err == jobs.ErrQueueFull {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+status.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetJobHandler returns the progress of a job and its result once it has finished
+func (s *Server) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+
+	status, ok := s.Jobs.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// CancelJobHandler stops a queued or running job
+func (s *Server) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+
+	status, ok := s.Jobs.Cancel(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
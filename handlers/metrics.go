@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"dnsrecon/metrics"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("dnsrecon_http_requests_total", "HTTP requests by route and status code", "handler", "code")
+
+	requestDuration = metrics.NewHistogramVec("dnsrecon_http_request_duration_seconds", "Time taken to answer HTTP requests by route", metrics.DefBuckets, "handler")
+
+	poolWait = metrics.NewHistogramVec("dnsrecon_pool_wait_seconds", "Time requests waited for a free dns client", metrics.DefBuckets)
+)
+
+// Metrics counts the requests of every route and how long they took
+// Routes are labelled with their path template so every domain is counted under /domain/{domain}
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		handler := "other"
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				handler = template
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		requestDuration.With(handler).Observe(time.Since(start).Seconds())
+		requestsTotal.With(handler, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// statusWriter keeps the status code, streaming handlers still get Flush and the ResponseController
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
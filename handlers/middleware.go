@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"dnsrecon/dnsrecon"
+	"dnsrecon/logging"
 	"net/http"
 	"time"
 )
@@ -13,67 +14,136 @@ type HandlerFunc func(context.Context, http.ResponseWriter, *http.Request) (cont
 func (s *Server) HandleFunc(handler func(context.Context, http.ResponseWriter, *http.Request) (context.Context, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		ctx := context.Background()
-		var err error
+		// Lookups are cancelled when the client disconnects
+		ctx := withRequestID(w, r)
 
-		select {
-		case dnsClient := <-s.DnsClientChan:
-
-			ctx, err = DnsClientToContext(ctx, dnsClient)
-			if err != nil {
-				s.Log.Printf("dns client to context: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		start := time.Now()
+		getCtx, cancel := context.WithTimeout(ctx, s.poolTimeout())
+		dnsClient, err := s.Pool.Get(getCtx)
+		cancel()
+		poolWait.With().Observe(time.Since(start).Seconds())
+		if err != nil {
+			if ctx.Err() == nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
 			}
+			return
+		}
 
-		case <-time.After(time.Second * 40):
-			w.WriteHeader(http.StatusServiceUnavailable)
+		ctx, err = DnsClientToContext(ctx, dnsClient)
+		if err != nil {
+			s.Pool.Put(dnsClient)
+			s.Log.ErrorContext(ctx, "dns client to context", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		// Always return the dns client to the pool, even if the handler fails
+		// The handler may have swapped it for another one
+		defer s.releaseDnsClient(ctx)
+
 		defer func() {
 			if err, ok := recover().(error); ok {
-				s.Log.Printf("request failed: %v", err)
+				s.Log.ErrorContext(ctx, "request failed", "error", err)
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 		}()
 
 		if _, err := handler(ctx, w, r); err != nil {
-			s.Log.Printf("request handler failed: %v", err)
+			s.Log.ErrorContext(ctx, "request handler failed", "error", err)
 			return
 		}
 
-		dnsClient, err := DnsClientFromContext(ctx)
-		if err != nil {
-			s.Log.Printf("failed to get dns client from context: %v", err)
-			return
-		}
+		return
+	}
+}
 
-		s.DnsClientChan <- dnsClient
+// withRequestID adds the X-Request-ID of the request, or a new ID, to the context and the response
+// Every log line of the lookups carries it
+func withRequestID(w http.ResponseWriter, r *http.Request) context.Context {
 
-		return
+	id := r.Header.Get("X-Request-ID")
+	if !validRequestID(id) {
+		id = logging.NewRequestID()
 	}
+
+	w.Header().Set("X-Request-ID", id)
+
+	return logging.WithRequestID(r.Context(), id)
 }
 
-func (s *Server) dnsClientToChannel(c *dnsrecon.DnsClient) {
-	s.DnsClientChan <- c
+// validRequestID accepts IDs from proxies that are safe to log
+func validRequestID(id string) bool {
+
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// poolTimeout is how long a request waits for a free dns client
+func (s *Server) poolTimeout() time.Duration {
+	return time.Duration(s.Config.PoolTimeout) * time.Second
+}
+
+// clientLease is the dns client a request holds, lookups swap it when they retry with another client
+type clientLease struct {
+	client *dnsrecon.DnsClient
 }
 
 func DnsClientToContext(ctx context.Context, c *dnsrecon.DnsClient) (context.Context, error) {
 
-	ctx = context.WithValue(ctx, "dnsClient", c)
+	ctx = context.WithValue(ctx, "dnsClient", &clientLease{client: c})
 
 	return ctx, nil
 }
 
 func DnsClientFromContext(ctx context.Context) (*dnsrecon.DnsClient, error) {
 
-	c := ctx.Value("dnsClient")
+	lease, ok := ctx.Value("dnsClient").(*clientLease)
+	if !ok || lease.client == nil {
+		return nil, fmt.Errorf("dns client not in context")
+	}
+
+	return lease.client, nil
+}
+
+// releaseDnsClient returns the dns client held by the request to the pool
+func (s *Server) releaseDnsClient(ctx context.Context) {
 
-	dnsClient, ok := c.(*dnsrecon.DnsClient)
+	if lease, ok := ctx.Value("dnsClient").(*clientLease); ok && lease.client != nil {
+		s.Pool.Put(lease.client)
+		lease.client = nil
+	}
+}
+
+// swapDnsClient returns the dns client held by the request to the pool and waits up to timeout for the next free one
+func (s *Server) swapDnsClient(ctx context.Context, timeout time.Duration) (*dnsrecon.DnsClient, error) {
+
+	lease, ok := ctx.Value("dnsClient").(*clientLease)
 	if !ok {
-		return dnsClient, fmt.Errorf("dns client not in context")
+		return nil, fmt.Errorf("dns client not in context")
 	}
 
+	s.releaseDnsClient(ctx)
+
+	getCtx, cancel := context.WithTimeout(ctx, timeout)
+	dnsClient, err := s.Pool.Get(getCtx)
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("get dns client timeout")
+	}
+
+	lease.client = dnsClient
+
 	return dnsClient, nil
 }
@@ -3,11 +3,17 @@ package handlers
 import (
 	"dnsrecon/config"
 	"dnsrecon/dnsrecon"
-	"log"
+	"dnsrecon/jobs"
+	"log/slog"
 )
 
 type Server struct {
-	DnsClientChan chan *dnsrecon.DnsClient
-	Config        *config.Config
-	Log           *log.Logger
+	Pool      *dnsrecon.Pool
+	Config    *config.Config
+	Cache     *dnsrecon.DnsCache
+	Health    *dnsrecon.HealthMonitor
+	Sinkholes *dnsrecon.Sinkholes
+	Wordlist  []string
+	Jobs      *jobs.Manager
+	Log       *slog.Logger
 }
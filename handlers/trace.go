@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"net/http"
+	"strings"
+)
+
+// TraceHandler resolves the domain iteratively from the root hints and returns the delegation path
+// type selects the record type, e.g. ?type=mx, A is used by default
+func (s *Server) TraceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+
+	domain := mux.Vars(r)["domain"]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		http.Error(w, fmt.Sprintf("invalid domain name %q", domain), http.StatusBadRequest)
+		return ctx, nil
+	}
+
+	qtype := dns.TypeA
+	if name := r.URL.Query().Get("type"); name != "" {
+		t, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown record type %q", name), http.StatusBadRequest)
+			return ctx, nil
+		}
+		qtype = t
+	}
+
+	dnsClient, err := DnsClientFromContext(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	trace := dnsClient.Trace(ctx, domain, qtype)
+
+	// Nobody is waiting for the response
+	if ctx.Err() != nil {
+		return ctx, ctx.Err()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trace)
+
+	return ctx, nil
+}
@@ -0,0 +1,385 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"dnsrecon/dnsrecon"
+	"dnsrecon/logging"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+
+	TypeDomain    = "domain"
+	TypeEnumerate = "enumerate"
+
+	// enumerateBatch is the number of words resolved between progress updates
+	enumerateBatch = 50
+
+	reapInterval = time.Minute
+)
+
+// ErrQueueFull is returned by Submit when no more jobs can be queued
+var ErrQueueFull = errors.New("job queue is full")
+
+// Request describes the scan to run
+type Request struct {
+	Type       string   `json:"type"`
+	Domain     string   `json:"domain"`
+	Types      string   `json:"types"`
+	DNSSEC     bool     `json:"dnssec"`
+	AXFR       bool     `json:"axfr"`
+	IXFRSerial uint32   `json:"ixfr_serial"`
+	SPF        bool     `json:"spf"`
+	Email      bool     `json:"email"`
+	Delegation bool     `json:"delegation"`
+	Reverse    bool     `json:"reverse"`
+	Provenance bool     `json:"provenance"`
+	Wordlist   []string `json:"wordlist"`
+}
+
+// Progress counts the steps of a job, a domain scan has one step and an enumeration one per word
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Status is a snapshot of a job
+type Status struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"`
+	Domain   string      `json:"domain"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Progress Progress    `json:"progress"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// EnumerationResult is the result of an enumerate job
+type EnumerationResult struct {
+	Wildcard   dnsrecon.Wildcard    `json:"wildcard"`
+	Subdomains []dnsrecon.Subdomain `json:"subdomains"`
+}
+
+type job struct {
+	mu      sync.Mutex
+	status  Status
+	request Request
+	opts    dnsrecon.LookupOptions
+	words   []string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Manager queues jobs and runs them with dns clients borrowed from the pool
+type Manager struct {
+	Pool      *dnsrecon.Pool
+	Workers   int
+	QueueSize int
+	Retention time.Duration
+	Wordlist  []string
+	Log       *slog.Logger
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue chan *job
+}
+
+// Start runs the workers and removes finished jobs once they are older than the retention period
+func (m *Manager) Start() {
+
+	m.jobs = make(map[string]*job)
+	m.queue = make(chan *job, m.QueueSize)
+
+	for i := 0; i < m.Workers; i++ {
+		go m.worker()
+	}
+
+	go func() {
+		for range time.Tick(reapInterval) {
+			m.reap()
+		}
+	}()
+}
+
+// Submit validates and queues a job
+func (m *Manager) Submit(request Request) (Status, error) {
+
+	j := &job{request: request}
+
+	if request.Domain == "" {
+		return Status{}, fmt.Errorf("domain is required")
+	}
+
+	switch request.Type {
+	case "", TypeDomain:
+		request.Type = TypeDomain
+		j.status.Progress.Total = 1
+
+		types, err := dnsrecon.ParseRecordTypes(request.Types)
+		if err != nil {
+			return Status{}, err
+		}
+		j.opts = dnsrecon.LookupOptions{
+			Types:        types,
+			DNSSEC:       request.DNSSEC,
+			ZoneTransfer: request.AXFR || request.IXFRSerial != 0,
+			IXFRSerial:   request.IXFRSerial,
+			SPF:          request.SPF,
+			Email:        request.Email,
+			Delegation:   request.Delegation,
+			ReverseDNS:   request.Reverse,
+			Provenance:   request.Provenance,
+		}
+
+	case TypeEnumerate:
+		j.words = m.Wordlist
+		if len(request.Wordlist) > 0 {
+			words, err := dnsrecon.ParseWordlist(strings.NewReader(strings.Join(request.Wordlist, "\n")))
+			if err != nil {
+				return Status{}, err
+			}
+			j.words = words
+		}
+		j.status.Progress.Total = len(j.words)
+
+	default:
+		return Status{}, fmt.Errorf("unknown job type %q", request.Type)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Status{}, err
+	}
+
+	j.status.ID = id
+	j.status.Type = request.Type
+	j.status.Domain = request.Domain
+	j.status.Status = StatusQueued
+	j.status.Created = time.Now().UTC()
+	// The lookups of a job log the job ID as their request ID
+	j.ctx, j.cancel = context.WithCancel(logging.WithRequestID(context.Background(), id))
+
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- j:
+	default:
+		j.cancel()
+		m.mu.Lock()
+		delete(m.jobs, id)
+		m.mu.Unlock()
+		return Status{}, ErrQueueFull
+	}
+
+	return j.snapshot(), nil
+}
+
+// Get returns the status of a job and its result once it has finished
+func (m *Manager) Get(id string) (Status, bool) {
+
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return Status{}, false
+	}
+
+	return j.snapshot(), true
+}
+
+// Cancel stops a queued or running job
+func (m *Manager) Cancel(id string) (Status, bool) {
+
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return Status{}, false
+	}
+
+	j.cancel()
+
+	j.mu.Lock()
+	if j.status.Status == StatusQueued {
+		j.finish(StatusCancelled, nil)
+	}
+	j.mu.Unlock()
+
+	return j.snapshot(), true
+}
+
+func (m *Manager) worker() {
+
+	for j := range m.queue {
+
+		// Skip jobs cancelled while they were queued
+		if j.ctx.Err() != nil {
+			continue
+		}
+
+		m.run(j)
+	}
+}
+
+// withClient runs fn with a client from the pool, it returns false when the job is cancelled first
+func (m *Manager) withClient(j *job, fn func(dnsClient *dnsrecon.DnsClient)) bool {
+
+	dnsClient, err := m.Pool.Get(j.ctx)
+	if err != nil {
+		return false
+	}
+	defer m.Pool.Put(dnsClient)
+
+	fn(dnsClient)
+
+	return true
+}
+
+func (m *Manager) run(j *job) {
+
+	defer func() {
+		if err := recover(); err != nil {
+			m.Log.ErrorContext(j.ctx, "job failed", "job", j.status.ID, "error", err)
+			j.mu.Lock()
+			j.status.Error = fmt.Sprintf("%v", err)
+			j.finish(StatusFailed, nil)
+			j.mu.Unlock()
+		}
+	}()
+
+	j.mu.Lock()
+	if j.status.Status != StatusQueued {
+		j.mu.Unlock()
+		return
+	}
+	started := time.Now().UTC()
+	j.status.Started = &started
+	j.status.Status = StatusRunning
+	j.mu.Unlock()
+
+	var result interface{}
+
+	switch j.status.Type {
+	case TypeDomain:
+		m.withClient(j, func(dnsClient *dnsrecon.DnsClient) {
+			result = dnsClient.GetDnsDataWithOptions(j.ctx, j.request.Domain, j.opts)
+		})
+		j.progress(1)
+
+	case TypeEnumerate:
+		result = m.enumerate(j)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.ctx.Err() != nil {
+		j.finish(StatusCancelled, nil)
+		return
+	}
+
+	j.finish(StatusDone, result)
+}
+
+// enumerate resolves the wordlist in batches so progress can be reported
+// The wildcard is detected with one client, the candidates are spread over the pool
+func (m *Manager) enumerate(j *job) *EnumerationResult {
+
+	var result EnumerationResult
+
+	m.withClient(j, func(dnsClient *dnsrecon.DnsClient) {
+		result.Wildcard = dnsClient.DetectWildcard(j.ctx, j.request.Domain)
+	})
+	result.Subdomains = make([]dnsrecon.Subdomain, 0)
+
+	for start := 0; start < len(j.words) && j.ctx.Err() == nil; start += enumerateBatch {
+
+		end := start + enumerateBatch
+		if end > len(j.words) {
+			end = len(j.words)
+		}
+
+		results := make(chan dnsrecon.Subdomain)
+		go m.Pool.EnumerateSubdomains(j.ctx, j.request.Domain, j.words[start:end], result.Wildcard, results)
+
+		for subdomain := range results {
+			result.Subdomains = append(result.Subdomains, subdomain)
+		}
+
+		j.progress(end - start)
+	}
+
+	return &result
+}
+
+// reap removes finished jobs older than the retention period
+func (m *Manager) reap() {
+
+	cutoff := time.Now().Add(-m.Retention)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, j := range m.jobs {
+		j.mu.Lock()
+		expired := j.status.Finished != nil && j.status.Finished.Before(cutoff)
+		j.mu.Unlock()
+
+		if expired {
+			j.cancel()
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *job) progress(n int) {
+
+	j.mu.Lock()
+	j.status.Progress.Done += n
+	j.mu.Unlock()
+}
+
+// finish must be called with the job lock held
+func (j *job) finish(status string, result interface{}) {
+
+	finished := time.Now().UTC()
+	j.status.Finished = &finished
+	j.status.Status = status
+	j.status.Result = result
+}
+
+func (j *job) snapshot() Status {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+func newID() (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,21 +1,85 @@
 package logging
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
 	"os"
 )
 
-var (
-	Trace   *log.Logger
-	Info    *log.Logger
-	Warning *log.Logger
-	Error   *log.Logger
-)
+// level is shared by every logger so a reload can change it
+var level = new(slog.LevelVar)
+
+// NewLogger creates a JSON logger writing to stderr
+// Lines logged with a context carry its request ID
+func NewLogger() *slog.Logger {
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+
+	return slog.New(contextHandler{handler})
+}
+
+// SetLevel changes the level of every logger, debug also logs each dns query
+func SetLevel(name string) error {
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level %q, use debug, info, warn or error", name)
+	}
+
+	level.Set(l)
+
+	return nil
+}
+
+// contextHandler adds the request ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random 16 character hex ID
+func NewRequestID() string {
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context whose log lines carry the ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
 
-func NewLogger() *log.Logger {
+// RequestID returns the ID added by WithRequestID or an empty string
+func RequestID(ctx context.Context) string {
 
-	logFile := log.New(os.Stderr, "", 0)
+	if ctx == nil {
+		return ""
+	}
 
-	return logFile
+	id, _ := ctx.Value(requestIDKey{}).(string)
 
+	return id
 }
@@ -0,0 +1,315 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the histogram buckets in seconds, lookups can take up to the server write timeout
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// collector writes the samples of a metric in the Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors = make(map[string]collector)
+)
+
+// register adds a metric to the ones served by Handler, names must be unique
+func register(name string, c collector) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := collectors[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+
+	collectors[name] = c
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		names := make([]string, 0, len(collectors))
+		for name := range collectors {
+			names = append(names, name)
+		}
+		registered := make([]collector, 0, len(names))
+		sort.Strings(names)
+		for _, name := range names {
+			registered = append(registered, collectors[name])
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		for _, c := range registered {
+			c.write(w)
+		}
+	})
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+	values   map[string][]string
+}
+
+// NewCounterVec registers a counter with the label names
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+
+	v := &CounterVec{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		counters: make(map[string]*Counter),
+		values:   make(map[string][]string),
+	}
+
+	register(name, v)
+
+	return v
+}
+
+// With returns the counter of the label values, in the order of the label names
+func (v *CounterVec) With(values ...string) *Counter {
+
+	key := labelKey(v.name, v.labels, values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.counters[key]
+	if !ok {
+		c = &Counter{}
+		v.counters[key] = c
+		v.values[key] = append([]string{}, values...)
+	}
+
+	return c
+}
+
+func (v *CounterVec) write(w io.Writer) {
+
+	writeHeader(w, v.name, v.help, "counter")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, key := range sortedKeys(v.values) {
+		c := v.counters[key]
+		c.mu.Lock()
+		writeSample(w, v.name, v.labels, v.values[key], c.value)
+		c.mu.Unlock()
+	}
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a histogram per combination of label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+	values     map[string][]string
+}
+
+// NewHistogramVec registers a histogram with the bucket upper bounds and label names
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+
+	v := &HistogramVec{
+		name:       name,
+		help:       help,
+		labels:     labels,
+		buckets:    append([]float64{}, buckets...),
+		histograms: make(map[string]*Histogram),
+		values:     make(map[string][]string),
+	}
+	sort.Float64s(v.buckets)
+
+	register(name, v)
+
+	return v
+}
+
+// With returns the histogram of the label values, in the order of the label names
+func (v *HistogramVec) With(values ...string) *Histogram {
+
+	key := labelKey(v.name, v.labels, values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.histograms[key]
+	if !ok {
+		h = &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+		v.histograms[key] = h
+		v.values[key] = append([]string{}, values...)
+	}
+
+	return h
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+
+	writeHeader(w, v.name, v.help, "histogram")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	labels := append(append([]string{}, v.labels...), "le")
+
+	for _, key := range sortedKeys(v.values) {
+
+		h := v.histograms[key]
+		values := v.values[key]
+
+		h.mu.Lock()
+		for i, bound := range h.buckets {
+			writeSample(w, v.name+"_bucket", labels, append(append([]string{}, values...), formatFloat(bound)), float64(h.counts[i]))
+		}
+		writeSample(w, v.name+"_bucket", labels, append(append([]string{}, values...), "+Inf"), float64(h.count))
+		writeSample(w, v.name+"_sum", v.labels, values, h.sum)
+		writeSample(w, v.name+"_count", v.labels, values, float64(h.count))
+		h.mu.Unlock()
+	}
+}
+
+// funcMetric reads its value when the metrics are served
+type funcMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from f
+func NewGaugeFunc(name string, help string, f func() float64) {
+	register(name, &funcMetric{name: name, help: help, typ: "gauge", value: f})
+}
+
+// NewCounterFunc registers a counter whose value is read from f
+func NewCounterFunc(name string, help string, f func() float64) {
+	register(name, &funcMetric{name: name, help: help, typ: "counter", value: f})
+}
+
+func (m *funcMetric) write(w io.Writer) {
+
+	writeHeader(w, m.name, m.help, m.typ)
+	writeSample(w, m.name, nil, nil, m.value())
+}
+
+func labelKey(name string, labels []string, values []string) string {
+
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", name, labels, values))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys orders the samples by their label values
+func sortedKeys(m map[string][]string) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeHeader(w io.Writer, name string, help string, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+func writeSample(w io.Writer, name string, labels []string, values []string, value float64) {
+
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, escape.Replace(values[i]))
+	}
+
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+// exposition returns the text format of a single collector
+func exposition(c collector) string {
+
+	var b bytes.Buffer
+	c.write(&b)
+
+	return b.String()
+}
+
+func TestCounterVecExposition(t *testing.T) {
+
+	v := NewCounterVec("test_queries_total", "Queries sent.\nBy \\resolver\\.", "resolver", "rcode")
+
+	v.With("google", "NOERROR").Inc()
+	v.With("google", "NOERROR").Add(2.5)
+	v.With(`quote " back\slash`, "line\nbreak").Inc()
+
+	want := `# HELP test_queries_total Queries sent.\nBy \\resolver\\.
+# TYPE test_queries_total counter
+test_queries_total{resolver="google",rcode="NOERROR"} 3.5
+test_queries_total{resolver="quote \" back\\slash",rcode="line\nbreak"} 1
+`
+
+	if got := exposition(v); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncExposition(t *testing.T) {
+
+	values := []float64{42, math.Inf(1), math.NaN(), 1e-7}
+	want := []string{"42", "+Inf", "NaN", "1e-07"}
+
+	for i, value := range values {
+
+		m := &funcMetric{name: "test_pool_size", help: "Enabled clients.", typ: "gauge", value: func() float64 { return value }}
+
+		expected := "# HELP test_pool_size Enabled clients.\n# TYPE test_pool_size gauge\ntest_pool_size " + want[i] + "\n"
+
+		if got := exposition(m); got != expected {
+			t.Errorf("got\n%s\nwant\n%s", got, expected)
+		}
+	}
+}
+
+func TestHistogramVecExposition(t *testing.T) {
+
+	// Buckets are sorted when registered
+	v := NewHistogramVec("test_latency_seconds", "Query latency.", []float64{1, 0.1, 0.5}, "resolver")
+
+	for _, latency := range []float64{0.05, 0.1, 0.3, 2} {
+		v.With(`a"b`).Observe(latency)
+	}
+
+	want := `# HELP test_latency_seconds Query latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{resolver="a\"b",le="0.1"} 2
+test_latency_seconds_bucket{resolver="a\"b",le="0.5"} 3
+test_latency_seconds_bucket{resolver="a\"b",le="1"} 3
+test_latency_seconds_bucket{resolver="a\"b",le="+Inf"} 4
+test_latency_seconds_sum{resolver="a\"b"} 2.45
+test_latency_seconds_count{resolver="a\"b"} 4
+`
+
+	if got := exposition(v); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+
+	NewCounterFunc("test_handler_b_total", "Second metric.", func() float64 { return 2 })
+	NewGaugeFunc("test_handler_a", "First metric.", func() float64 { return 1 })
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+
+	// Metrics are written in name order after the ones registered by the other tests
+	a := bytes.Index(w.Body.Bytes(), []byte("test_handler_a 1\n"))
+	b := bytes.Index(w.Body.Bytes(), []byte("# TYPE test_handler_b_total counter\ntest_handler_b_total 2\n"))
+	if a < 0 || b < 0 || a > b {
+		t.Errorf("unexpected output\n%s", w.Body.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+
+	NewGaugeFunc("test_twice", "Registered twice.", func() float64 { return 0 })
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+
+	NewGaugeFunc("test_twice", "Registered twice.", func() float64 { return 0 })
+}
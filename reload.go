@@ -0,0 +1,230 @@
+package main
+
+import (
+	"dnsrecon/config"
+	"dnsrecon/dnsrecon"
+	"dnsrecon/handlers"
+	"dnsrecon/logging"
+	"dnsrecon/resolvers"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// reloadPollInterval is how often config.yaml and resolvers.yaml are checked for changes
+	reloadPollInterval = time.Second * 5
+)
+
+// liveSettings are applied by a reload, other settings need a restart
+var liveSettings = map[string]bool{
+	"maximum_dns_servers":       true,
+	"log_level":                 true,
+	"dns_timeout":               true,
+	"dns_attempts":              true,
+	"dns_retry_delay":           true,
+	"ratelimit_burst":           true,
+	"dnssec_trust_anchors":      true,
+	"root_hints":                true,
+	"dkim_selectors":            true,
+	"zone_transfer_timeout":     true,
+	"zone_transfer_max_records": true,
+}
+
+// clientSettings are copied into every dns client, changing them replaces all the clients
+var clientSettings = map[string]bool{
+	"dns_timeout":               true,
+	"dns_attempts":              true,
+	"dns_retry_delay":           true,
+	"ratelimit_burst":           true,
+	"dnssec_trust_anchors":      true,
+	"root_hints":                true,
+	"dkim_selectors":            true,
+	"zone_transfer_timeout":     true,
+	"zone_transfer_max_records": true,
+}
+
+// reloader rebuilds the dns client pool when config.yaml or resolvers.yaml change
+// Removed clients finish their in-flight lookups and are then dropped by the pool
+type reloader struct {
+	s         *handlers.Server
+	config    *config.Config
+	resolvers *resolvers.Resolvers
+
+	mu       sync.Mutex
+	modified map[string]time.Time
+}
+
+// Start reloads on SIGHUP and when the modification time of either file changes
+func (rl *reloader) Start() {
+
+	rl.modified = map[string]time.Time{
+		config.ConfigFile:       modTime(config.ConfigFile),
+		resolvers.ResolversFile: modTime(resolvers.ResolversFile),
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		ticker := time.NewTicker(reloadPollInterval)
+
+		for {
+			select {
+			case <-hup:
+				rl.s.Log.Info("reload: SIGHUP received")
+				rl.reload()
+
+			case <-ticker.C:
+				if rl.changed() {
+					rl.reload()
+				}
+			}
+		}
+	}()
+}
+
+// changed checks whether either file was modified since the last check
+func (rl *reloader) changed() bool {
+
+	changed := false
+
+	for filename, modified := range rl.modified {
+		if t := modTime(filename); !t.Equal(modified) {
+			rl.modified[filename] = t
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func modTime(filename string) time.Time {
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload validates both files and swaps the clients of the pool, the running clients are kept on errors
+func (rl *reloader) reload() {
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	log := rl.s.Log
+
+	c, err := config.ReadConfig()
+	if err != nil {
+		log.Error("reload: invalid config, keeping the running configuration", "error", err)
+		return
+	}
+
+	rs, err := resolvers.ReadResolvers()
+	if err != nil {
+		log.Error("reload: invalid resolvers, keeping the running configuration", "error", err)
+		return
+	}
+	if errs := rs.Validate(); len(errs) > 0 {
+		log.Error("reload: invalid resolvers, keeping the running configuration", "file", resolvers.ResolversFile, "error", errs[0])
+		return
+	}
+
+	changes := config.Diff(rl.config, c)
+
+	// Clients are kept unless a setting they copied changed
+	rebuild := !reflect.DeepEqual(rl.resolvers.RetryServers, rs.RetryServers)
+	for _, change := range changes {
+		if clientSettings[change.Setting] {
+			rebuild = true
+		}
+	}
+
+	current := make(map[string]*dnsrecon.DnsClient)
+	for _, client := range rl.s.Pool.Clients() {
+		current[resolverKey(client.Resolver)] = client
+	}
+
+	reuse := current
+	if rebuild {
+		reuse = nil
+	}
+
+	dnsClients, err := newDnsClients(c, rs, rl.s.Cache, log, reuse)
+	if err != nil {
+		log.Error("reload: invalid config, keeping the running configuration", "error", err)
+		return
+	}
+	if len(dnsClients) == 0 {
+		log.Error("reload: no enabled resolvers, keeping the running configuration", "file", resolvers.ResolversFile)
+		return
+	}
+
+	var added, removed, ratelimits []string
+	kept := make(map[string]bool)
+
+	for _, client := range dnsClients {
+		key := resolverKey(client.Resolver)
+		kept[key] = true
+
+		old, ok := current[key]
+		if !ok || old != client {
+			if !ok {
+				added = append(added, client.Resolver.Nameserver)
+			}
+			continue
+		}
+
+		// Reused clients still point at the resolver loaded before
+		for _, resolver := range rs.DnsServers {
+			if resolverKey(resolver) == key && resolver.Ratelimit != client.Ratelimit {
+				ratelimits = append(ratelimits, fmt.Sprintf("%s %d -> %d", client.Resolver.Nameserver, client.Ratelimit, resolver.Ratelimit))
+				client.SetRatelimit(resolver.Ratelimit)
+			}
+		}
+	}
+
+	for key, client := range current {
+		if !kept[key] {
+			removed = append(removed, client.Resolver.Nameserver)
+		}
+	}
+
+	rl.s.Pool.Replace(dnsClients)
+
+	if err := logging.SetLevel(c.LogLevel); err != nil {
+		log.Error("reload: invalid log level", "error", err)
+	}
+
+	rl.config = c
+	rl.resolvers = rs
+
+	log.Info("reload: pool updated", "resolvers", len(dnsClients))
+	if rebuild {
+		log.Info("reload: replaced every client to apply the new client settings")
+	}
+	if len(added) > 0 {
+		log.Info("reload: resolvers added", "resolvers", strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		log.Info("reload: resolvers removed, their in-flight lookups are drained", "resolvers", strings.Join(removed, ", "))
+	}
+	if len(ratelimits) > 0 {
+		log.Info("reload: ratelimit changed", "resolvers", strings.Join(ratelimits, ", "))
+	}
+
+	for _, change := range changes {
+		if liveSettings[change.Setting] {
+			log.Info("reload: setting changed", "setting", change.Setting, "old", change.Old, "new", change.New)
+		} else {
+			log.Warn("reload: setting changed, restart to apply it", "setting", change.Setting, "old", change.Old, "new", change.New)
+		}
+	}
+}
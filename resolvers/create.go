@@ -7,6 +7,15 @@ import (
 	"os"
 )
 
+// ResolversFile is read from the working directory unless DNSRECON_RESOLVERS or -resolvers is set
+var ResolversFile = "resolvers.yaml"
+
+func init() {
+	if filename := os.Getenv("DNSRECON_RESOLVERS"); filename != "" {
+		ResolversFile = filename
+	}
+}
+
 type Resolver struct {
 	Nameserver string   `yaml:"nameserver"`
 	Ips        []string `yaml:"ips"`
@@ -26,51 +35,53 @@ type Resolvers struct {
 	DnsServers   []*Resolver    `yaml:"resolvers"`
 }
 
-func CreateResolversFile() {
+// CreateResolversFile writes the default resolvers if the file doesn't exist
+func CreateResolversFile() error {
 
 	r := Resolvers{}
 
 	r.AddNameservers()
 
-	filename := "resolvers.yaml"
+	filename := ResolversFile
 
-	// Create config file with default modules if it doesn't exist
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		return nil
+	}
 
-		y, err := yaml.Marshal(r)
-		if err != nil {
-			panic(err)
-		}
+	y, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
 
-		f, err := os.Create(filename)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
+	if err := ioutil.WriteFile(filename, y, 0644); err != nil {
+		return err
+	}
 
-		_, err = f.Write(y)
-		if err != nil {
-			panic(err)
-		}
+	fmt.Fprintf(os.Stderr, "\nCreated resolvers file: %s\n", filename)
 
-		fmt.Printf("\nCreated resolvers file: %s\n", filename)
-	}
+	return nil
 }
 
-func LoadResolvers() *Resolvers {
+// ReadResolvers reads the resolvers file without validating it
+// The default resolvers are used when the file doesn't exist
+func ReadResolvers() (*Resolvers, error) {
 
 	var resolvers Resolvers
 
-	filename := "resolvers.yaml"
+	filename := ResolversFile
 
 	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		resolvers.AddNameservers()
+		return &resolvers, nil
+	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if err := yaml.Unmarshal(b, &resolvers); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %v", filename, err)
 	}
 
-	return &resolvers
+	return &resolvers, nil
 }
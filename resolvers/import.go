@@ -0,0 +1,252 @@
+package resolvers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PublicDnsURL lists the resolvers checked by public-dns.info
+	PublicDnsURL = "https://public-dns.info/nameservers-all.csv"
+
+	DefaultImportRatelimit = 10
+
+	downloadTimeout = time.Minute
+)
+
+// PublicDnsServer is a row of the public-dns.info CSV
+type PublicDnsServer struct {
+	Ip          string
+	Name        string
+	AsOrg       string
+	Country     string
+	Error       string
+	Dnssec      bool
+	Reliability float64
+}
+
+// ImportOptions filters the servers returned by Candidates
+type ImportOptions struct {
+	// MinReliability is between 0 and 1
+	MinReliability float64
+
+	// Countries are ISO country codes, every country is imported when empty
+	Countries []string
+
+	// Only import IPv4 or IPv6 servers, both are imported when neither is set
+	IPv4 bool
+	IPv6 bool
+
+	// Ratelimit of every imported resolver, DefaultImportRatelimit is used when zero
+	Ratelimit int
+}
+
+// OpenPublicDnsCSV opens a local file or downloads the CSV when source is a http or https URL
+func OpenPublicDnsCSV(source string) (io.ReadCloser, error) {
+
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	client := &http.Client{Timeout: downloadTimeout}
+
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// ReadPublicDnsCSV parses the nameservers CSV, the columns are found by their header names
+func ReadPublicDnsCSV(r io.Reader) ([]PublicDnsServer, error) {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range []string{"ip_address", "reliability"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv has no %s column", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var servers []PublicDnsServer
+
+	for line := 2; ; line++ {
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reliability, err := strconv.ParseFloat(field(record, "reliability"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid reliability %q", line, field(record, "reliability"))
+		}
+
+		dnssec, _ := strconv.ParseBool(field(record, "dnssec"))
+
+		servers = append(servers, PublicDnsServer{
+			Ip:          field(record, "ip_address"),
+			Name:        field(record, "name"),
+			AsOrg:       field(record, "as_org"),
+			Country:     field(record, "country_code"),
+			Error:       field(record, "error"),
+			Dnssec:      dnssec,
+			Reliability: reliability,
+		})
+	}
+
+	return servers, nil
+}
+
+// Candidates returns the servers passing the filters that aren't already in the resolvers, in the order of the CSV
+// They should be probed before Import adds them
+func (r *Resolvers) Candidates(servers []PublicDnsServer, opts ImportOptions) []*Resolver {
+
+	ratelimit := opts.Ratelimit
+	if ratelimit == 0 {
+		ratelimit = DefaultImportRatelimit
+	}
+
+	countries := make(map[string]bool)
+	for _, country := range opts.Countries {
+		countries[strings.ToUpper(strings.TrimSpace(country))] = true
+	}
+
+	known := make(map[string]bool)
+	for _, resolver := range r.DnsServers {
+		if resolver == nil {
+			continue
+		}
+		for _, address := range resolver.Ips {
+			known[address] = true
+		}
+	}
+
+	var candidates []*Resolver
+
+	for _, server := range servers {
+
+		ip := net.ParseIP(server.Ip)
+		if ip == nil || server.Error != "" || server.Reliability < opts.MinReliability {
+			continue
+		}
+
+		ipv4 := ip.To4() != nil
+		if opts.IPv4 != opts.IPv6 && opts.IPv4 != ipv4 {
+			continue
+		}
+
+		if len(countries) > 0 && !countries[strings.ToUpper(server.Country)] {
+			continue
+		}
+
+		address := net.JoinHostPort(ip.String(), "53")
+		if known[address] {
+			continue
+		}
+		known[address] = true
+
+		name := strings.TrimSuffix(server.Name, ".")
+		if name == "" {
+			name = server.AsOrg
+		}
+		if name == "" {
+			name = ip.String()
+		} else {
+			name = fmt.Sprintf("%s (%s)", name, ip.String())
+		}
+
+		candidates = append(candidates, &Resolver{Nameserver: name, Ips: []string{address}, Ratelimit: ratelimit, Enable: true})
+	}
+
+	return candidates
+}
+
+// Import adds the resolvers, at most limit of them unless it's zero, and returns how many were added
+func (r *Resolvers) Import(candidates []*Resolver, limit int) int {
+
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	r.DnsServers = append(r.DnsServers, candidates...)
+
+	return len(candidates)
+}
+
+// SaveResolvers validates the resolvers and replaces the file
+func SaveResolvers(r *Resolvers, filename string) error {
+
+	// Every problem is reported so they can all be fixed at once
+	if errs := r.Validate(); len(errs) > 0 {
+		problems := make([]string, 0, len(errs))
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+		return fmt.Errorf("invalid resolvers: %s", strings.Join(problems, "; "))
+	}
+
+	y, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write doesn't leave a truncated resolvers file
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".resolvers-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(y); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,175 @@
+package resolvers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// publicDnsCSV has the columns of the public-dns.info export
+const publicDnsCSV = `ip_address,name,as_number,as_org,country_code,city,version,error,dnssec,reliability,checked_at,created_at
+1.1.1.1,one.one.one.one.,13335,Cloudflare,US,,,,true,1.00,,
+8.8.8.8,dns.google.,15169,Google,US,,,,true,0.99,,
+9.9.9.9,,19281,Quad9,CH,,,,true,0.95,,
+2606:4700::1111,,13335,Cloudflare,US,,,,true,1.00,,
+192.0.2.1,,,,DE,,,i/o timeout,false,0.10,,
+203.0.113.5,,,,fr,,,,false,0.50,,
+1.1.1.1,one.one.one.one.,13335,Cloudflare,US,,,,true,1.00,,
+`
+
+func TestReadPublicDnsCSV(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		csv     string
+		servers string
+		error   string
+	}{
+		{
+			name:    "public-dns.info columns",
+			csv:     "ip_address,name,as_org,country_code,error,dnssec,reliability\n1.1.1.1,one.one.one.one.,Cloudflare,US,,true,1.00\n192.0.2.1,,,DE,i/o timeout,false,0.10\n",
+			servers: "1.1.1.1 one.one.one.one. Cloudflare US  true 1; 192.0.2.1   DE i/o timeout false 0.1",
+		},
+		{
+			name:    "columns are found by their header",
+			csv:     "reliability, ip_address ,country_code\n0.5,9.9.9.9,CH\n",
+			servers: "9.9.9.9   CH  false 0.5",
+		},
+		{
+			name:  "missing column",
+			csv:   "ip_address,name\n1.1.1.1,one\n",
+			error: "csv has no reliability column",
+		},
+		{
+			name:  "invalid reliability",
+			csv:   "ip_address,reliability\n1.1.1.1,1\n8.8.8.8,high\n",
+			error: `line 3: invalid reliability "high"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			servers, err := ReadPublicDnsCSV(strings.NewReader(tt.csv))
+
+			if tt.error != "" {
+				if err == nil || err.Error() != tt.error {
+					t.Fatalf("error %v, want %s", err, tt.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, s := range servers {
+				got = append(got, fmt.Sprintf("%s %s %s %s %s %v %v", s.Ip, s.Name, s.AsOrg, s.Country, s.Error, s.Dnssec, s.Reliability))
+			}
+			if strings.Join(got, "; ") != tt.servers {
+				t.Errorf("servers\n%s\nwant\n%s", strings.Join(got, "; "), tt.servers)
+			}
+		})
+	}
+}
+
+func TestCandidates(t *testing.T) {
+
+	servers, err := ReadPublicDnsCSV(strings.NewReader(publicDnsCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8.8.8.8 is already a resolver
+	existing := &Resolvers{DnsServers: []*Resolver{{Nameserver: "Google", Ips: []string{"8.8.8.8:53", "8.8.4.4:53"}, Ratelimit: 10}}}
+
+	tests := []struct {
+		name       string
+		opts       ImportOptions
+		candidates string
+		ratelimit  int
+	}{
+		{
+			name:       "every working server",
+			candidates: "one.one.one.one (1.1.1.1) 1.1.1.1:53; Quad9 (9.9.9.9) 9.9.9.9:53; Cloudflare (2606:4700::1111) [2606:4700::1111]:53; 203.0.113.5 203.0.113.5:53",
+			ratelimit:  DefaultImportRatelimit,
+		},
+		{
+			name:       "reliability",
+			opts:       ImportOptions{MinReliability: 0.96},
+			candidates: "one.one.one.one (1.1.1.1) 1.1.1.1:53; Cloudflare (2606:4700::1111) [2606:4700::1111]:53",
+			ratelimit:  DefaultImportRatelimit,
+		},
+		{
+			name:       "countries",
+			opts:       ImportOptions{Countries: []string{" ch", "FR"}},
+			candidates: "Quad9 (9.9.9.9) 9.9.9.9:53; 203.0.113.5 203.0.113.5:53",
+			ratelimit:  DefaultImportRatelimit,
+		},
+		{
+			name:       "IPv4",
+			opts:       ImportOptions{IPv4: true, MinReliability: 0.9},
+			candidates: "one.one.one.one (1.1.1.1) 1.1.1.1:53; Quad9 (9.9.9.9) 9.9.9.9:53",
+			ratelimit:  DefaultImportRatelimit,
+		},
+		{
+			name:       "IPv6",
+			opts:       ImportOptions{IPv6: true},
+			candidates: "Cloudflare (2606:4700::1111) [2606:4700::1111]:53",
+			ratelimit:  DefaultImportRatelimit,
+		},
+		{
+			name:       "IPv4 and IPv6 with a ratelimit",
+			opts:       ImportOptions{IPv4: true, IPv6: true, MinReliability: 0.96, Ratelimit: 25},
+			candidates: "one.one.one.one (1.1.1.1) 1.1.1.1:53; Cloudflare (2606:4700::1111) [2606:4700::1111]:53",
+			ratelimit:  25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var got []string
+			for _, candidate := range existing.Candidates(servers, tt.opts) {
+				got = append(got, candidate.Nameserver+" "+strings.Join(candidate.Ips, ","))
+				if candidate.Ratelimit != tt.ratelimit || !candidate.Enable {
+					t.Errorf("%s has ratelimit %d, enabled %v, want %d", candidate.Nameserver, candidate.Ratelimit, candidate.Enable, tt.ratelimit)
+				}
+			}
+
+			if strings.Join(got, "; ") != tt.candidates {
+				t.Errorf("candidates\n%s\nwant\n%s", strings.Join(got, "; "), tt.candidates)
+			}
+		})
+	}
+}
+
+func TestSaveResolversErrors(t *testing.T) {
+
+	r := &Resolvers{
+		RetryServers: &RetryResolver{Nameserver: "retry", Ips: []string{"1.1.1.1:53"}, Ratelimit: 10},
+		DnsServers: []*Resolver{
+			{Nameserver: "no port", Ips: []string{"1.1.1.1"}, Ratelimit: 10},
+			{Nameserver: "no ratelimit", Ips: []string{"8.8.8.8:53"}},
+		},
+	}
+
+	filename := filepath.Join(t.TempDir(), "resolvers.yaml")
+
+	err := SaveResolvers(r, filename)
+	if err == nil {
+		t.Fatal("invalid resolvers saved")
+	}
+
+	for _, problem := range []string{`no port: "1.1.1.1" is not an ip:port address`, "no ratelimit: ratelimit must be greater than 0"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("%v doesn't report %s", err, problem)
+		}
+	}
+
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("file written: %v", err)
+	}
+}
@@ -30,8 +30,6 @@ func (r *Resolvers) AddNameservers() {
 
 	r.Add("google", []string{"8.8.8.8:53", "8.8.4.4:53"}, 40)
 
-	r.Add("google", []string{"8.8.8.8:53", "8.8.4.4:53"}, 40)
-
 	r.Add("comodo secure dns", []string{"8.26.56.26:53", "8.10.247.10:53"}, 10)
 
 	r.Add("level3", []string{"109.244.0.3:53", "109.244.0.4:53"}, 10)
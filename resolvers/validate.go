@@ -0,0 +1,95 @@
+package resolvers
+
+import (
+	"fmt"
+	"net"
+)
+
+// Validate checks that every resolver has a name, a positive rate limit and ip:port addresses
+func (r *Resolvers) Validate() []error {
+
+	var errs []error
+
+	if r.RetryServers == nil {
+		errs = append(errs, fmt.Errorf("retry_servers is missing"))
+	} else {
+		errs = append(errs, validateResolver(r.RetryServers.Nameserver, r.RetryServers.Ips, r.RetryServers.Ratelimit)...)
+	}
+
+	if len(r.DnsServers) == 0 {
+		errs = append(errs, fmt.Errorf("no resolvers"))
+	}
+
+	for i, resolver := range r.DnsServers {
+		if resolver == nil {
+			errs = append(errs, fmt.Errorf("resolver %d is empty", i))
+			continue
+		}
+		errs = append(errs, validateResolver(resolver.Nameserver, resolver.Ips, resolver.Ratelimit)...)
+	}
+
+	return errs
+}
+
+func validateResolver(nameserver string, ips []string, ratelimit int) []error {
+
+	var errs []error
+
+	if nameserver == "" {
+		errs = append(errs, fmt.Errorf("resolver without a nameserver name"))
+	}
+	if len(ips) == 0 {
+		errs = append(errs, fmt.Errorf("%s: no ips", nameserver))
+	}
+	if ratelimit <= 0 {
+		errs = append(errs, fmt.Errorf("%s: ratelimit must be greater than 0", nameserver))
+	}
+
+	for _, address := range ips {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) == nil || port == "" {
+			errs = append(errs, fmt.Errorf("%s: %q is not an ip:port address", nameserver, address))
+		}
+	}
+
+	return errs
+}
+
+// Duplicates lists resolvers sharing a name and addresses used more than once
+func (r *Resolvers) Duplicates() []string {
+
+	var duplicates []string
+
+	names := make(map[string]bool)
+	addresses := make(map[string]string)
+
+	for _, resolver := range r.DnsServers {
+		if resolver == nil {
+			continue
+		}
+
+		if names[resolver.Nameserver] {
+			duplicates = append(duplicates, fmt.Sprintf("%s: resolver is listed more than once", resolver.Nameserver))
+		}
+		names[resolver.Nameserver] = true
+
+		for _, address := range resolver.Ips {
+			if other, ok := addresses[address]; ok && other != resolver.Nameserver {
+				duplicates = append(duplicates, fmt.Sprintf("%s: %s is also used by %s", resolver.Nameserver, address, other))
+			}
+			addresses[address] = resolver.Nameserver
+		}
+	}
+
+	if r.RetryServers != nil {
+		seen := make(map[string]bool)
+		for _, address := range r.RetryServers.Ips {
+			if seen[address] {
+				duplicates = append(duplicates, fmt.Sprintf("%s: %s is listed more than once", r.RetryServers.Nameserver, address))
+			}
+			seen[address] = true
+		}
+	}
+
+	return duplicates
+}